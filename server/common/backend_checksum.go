@@ -0,0 +1,124 @@
+package common
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/zeebo/blake3"
+)
+
+/*
+ * Backends that know the hash of a file without reading it all, like S3 which stores them along
+ * with the object, implement IBackendChecksum. The checksum is given in hexadecimal and a backend
+ * that can't provide the requested algorithm returns ErrNotImplemented so the file gets streamed
+ * through the hash function instead.
+ */
+const (
+	CHECKSUM_MD5    = "md5"
+	CHECKSUM_SHA1   = "sha1"
+	CHECKSUM_SHA256 = "sha256"
+	CHECKSUM_BLAKE3 = "blake3"
+)
+
+var ErrChecksumMismatch = NewError("Checksum Mismatch", http.StatusBadRequest)
+
+type IBackendChecksum interface {
+	Checksum(path string, algo string) (string, error)
+}
+
+func NewChecksumHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case CHECKSUM_MD5:
+		return md5.New(), nil
+	case CHECKSUM_SHA1:
+		return sha1.New(), nil
+	case CHECKSUM_SHA256:
+		return sha256.New(), nil
+	case CHECKSUM_BLAKE3:
+		return blake3.New(), nil
+	}
+	return nil, NewError("Unsupported checksum algorithm", http.StatusBadRequest)
+}
+
+// Checksum gives the hash of a file and tells if it was computed by reading its content
+func Checksum(b IBackend, path string, algo string) (string, bool, error) {
+	h, err := NewChecksumHash(algo)
+	if err != nil {
+		return "", false, err
+	}
+	if obj, ok := b.(IBackendChecksum); ok {
+		sum, err := obj.Checksum(path, algo)
+		if err == nil {
+			return strings.ToLower(sum), false, nil
+		} else if err != ErrNotImplemented {
+			return "", false, err
+		}
+	}
+	r, err := b.Cat(path)
+	if err != nil {
+		return "", true, err
+	}
+	defer r.Close()
+	if _, err = io.Copy(h, r); err != nil {
+		return "", true, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), true, nil
+}
+
+/*
+ * ParseDigestHeader reads the digest a client sends along with an upload, either the
+ * Content-Digest header from RFC 9530: `sha-256=:<base64>:` or the older Digest header from
+ * RFC 3230: `sha-256=<base64>`. When many are given, we keep the strongest one we know about and
+ * the ones we don't know about, like sha-512, are ignored as if they weren't sent
+ */
+func ParseDigestHeader(req *http.Request) (string, []byte, error) {
+	header := req.Header.Get("Content-Digest")
+	if header == "" {
+		header = req.Header.Get("Digest")
+	}
+	if header == "" {
+		return "", nil, nil
+	}
+	bestAlgo, bestValue, rank := "", []byte(nil), -1
+	for _, part := range strings.Split(header, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		algo := ""
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "md5":
+			algo = CHECKSUM_MD5
+		case "sha", "sha-1":
+			algo = CHECKSUM_SHA1
+		case "sha-256":
+			algo = CHECKSUM_SHA256
+		case "blake3":
+			algo = CHECKSUM_BLAKE3
+		default:
+			continue
+		}
+		r := map[string]int{CHECKSUM_MD5: 0, CHECKSUM_SHA1: 1, CHECKSUM_SHA256: 2, CHECKSUM_BLAKE3: 3}[algo]
+		if r <= rank {
+			continue
+		}
+		// the RFCs want base64 but plenty of clients send hex, the length tells them apart
+		value = strings.Trim(strings.TrimSpace(value), ":")
+		h, _ := NewChecksumHash(algo)
+		b, err := hex.DecodeString(value)
+		if err != nil || len(b) != h.Size() {
+			if b, err = base64.StdEncoding.DecodeString(value); err != nil || len(b) != h.Size() {
+				return "", nil, NewError("Invalid digest", http.StatusBadRequest)
+			}
+		}
+		bestAlgo, bestValue, rank = algo, b, r
+	}
+	return bestAlgo, bestValue, nil
+}
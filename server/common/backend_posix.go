@@ -0,0 +1,65 @@
+package common
+
+import (
+	"os"
+	"strconv"
+)
+
+/*
+ * Backends sitting on a POSIX filesystem, like local, sftp and nfs, implement IBackendPosix to
+ * change the permissions and owner of a file and deal with symlinks. A backend that can't do one
+ * of those returns ErrNotImplemented. What they know of a file when listing it or doing a stat goes
+ * in File.Posix, the mode there being the unix permission bits: 0755, 04755, ...
+ * A uid or gid of -1 given to Chown leaves it as it is.
+ */
+type IBackendPosix interface {
+	Chmod(path string, mode os.FileMode) error
+	Chown(path string, uid int, gid int) error
+	Symlink(target string, path string) error
+	Readlink(path string) (string, error)
+}
+
+type FilePosix struct {
+	Mode uint32 `json:"mode"`
+	Uid  int    `json:"uid"`
+	Gid  int    `json:"gid"`
+	Link string `json:"link,omitempty"`
+}
+
+// UnixMode gives the unix permission bits of a FileMode, setuid, setgid and sticky included
+func UnixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+func FileModeFromUnix(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// ParseUnixMode reads a mode the way chmod does: in octal, eg: 755 or 0644
+func ParseUnixMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 07777 {
+		return 0, ErrNotValid
+	}
+	return FileModeFromUnix(uint32(m)), nil
+}
@@ -0,0 +1,190 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+)
+
+/*
+ * Backends able to read part of a file implement IBackendRange so range requests, like the ones
+ * made when streaming a video, and archive browsing only fetch the bytes they need instead of
+ * downloading the whole file first. A length of -1 reads until the end of the file.
+ */
+const RANGE_READ_AHEAD = 512 * 1024
+
+type IBackendRange interface {
+	CatRange(path string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// RangeReader is a file from a backend that supports range reads. Nothing is fetched until
+// someone reads from it, a seek only moves the position of the next read.
+type RangeReader struct {
+	backend IBackendRange
+	path    string
+	size    int64
+	offset  int64
+	reader  io.ReadCloser
+	mu      sync.Mutex
+
+	block       []byte
+	blockOffset int64
+}
+
+func NewRangeReader(backend IBackendRange, path string, size int64) *RangeReader {
+	return &RangeReader{backend: backend, path: path, size: size}
+}
+
+func (this *RangeReader) Size() int64 {
+	return this.size
+}
+
+func (this *RangeReader) Read(p []byte) (int, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.offset >= this.size {
+		return 0, io.EOF
+	}
+	if this.reader == nil {
+		r, err := this.backend.CatRange(this.path, this.offset, this.size-this.offset)
+		if err != nil {
+			return 0, err
+		}
+		this.reader = r
+	}
+	n, err := this.reader.Read(p)
+	this.offset += int64(n)
+	return n, err
+}
+
+func (this *RangeReader) Seek(offset int64, whence int) (int64, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += this.offset
+	case io.SeekEnd:
+		offset += this.size
+	default:
+		return this.offset, ErrNotImplemented
+	}
+	if offset < 0 {
+		return this.offset, os.ErrInvalid
+	}
+	if offset != this.offset && this.reader != nil {
+		this.reader.Close()
+		this.reader = nil
+	}
+	this.offset = offset
+	return this.offset, nil
+}
+
+// ReadAt is what archive/zip needs to browse an archive. Readers of compressed content make lots
+// of small reads so the content is fetched by blocks of at least RANGE_READ_AHEAD bytes
+func (this *RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	} else if off >= this.size {
+		return 0, io.EOF
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	n := 0
+	for n < len(p) && off < this.size {
+		if off < this.blockOffset || off >= this.blockOffset+int64(len(this.block)) {
+			length := int64(len(p) - n)
+			if length < RANGE_READ_AHEAD {
+				length = RANGE_READ_AHEAD
+			}
+			if off+length > this.size {
+				length = this.size - off
+			}
+			r, err := this.backend.CatRange(this.path, off, length)
+			if err != nil {
+				return n, err
+			}
+			block := make([]byte, length)
+			m, err := io.ReadFull(r, block)
+			r.Close()
+			if err != nil {
+				return n, err
+			}
+			this.block, this.blockOffset = block[:m], off
+		}
+		c := copy(p[n:], this.block[off-this.blockOffset:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Range gives the content from start to end included
+func (this *RangeReader) Range(start int64, end int64) (io.ReadCloser, error) {
+	return this.backend.CatRange(this.path, start, end-start+1)
+}
+
+func (this *RangeReader) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.reader == nil {
+		return nil
+	}
+	err := this.reader.Close()
+	this.reader = nil
+	return err
+}
+
+// HTTPRange is the value of the Range header to use for a range read
+func HTTPRange(offset int64, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// HTTPRangeBody gives the body of the response to a range request. Servers are free to ignore
+// the Range header and send the whole content, in which case we skip what's not needed. An error
+// status is reported as an error rather than being read as content
+func HTTPRangeBody(res *http.Response, offset int64, length int64) (io.ReadCloser, error) {
+	if res.StatusCode == http.StatusPartialContent {
+		return res.Body, nil
+	} else if res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		res.Body.Close()
+		return nil, NewError(http.StatusText(res.StatusCode), res.StatusCode)
+	} else if res.StatusCode >= 400 {
+		res.Body.Close()
+		return nil, NewError(HTTPFriendlyStatus(res.StatusCode), res.StatusCode)
+	} else if offset > 0 {
+		if _, err := io.CopyN(io.Discard, res.Body, offset); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return res.Body, nil
+	}
+	return limitedReadCloser{io.LimitReader(res.Body, length), res.Body}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// NewSectionReadCloser is a range read for anything that can read at a given offset, like a file
+func NewSectionReadCloser(f interface {
+	io.ReaderAt
+	io.Closer
+}, offset int64, length int64) io.ReadCloser {
+	if length < 0 {
+		length = math.MaxInt64 - offset
+	}
+	return limitedReadCloser{io.NewSectionReader(f, offset, length), f}
+}
@@ -8,9 +8,15 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 )
 
-var Config Configuration
+var (
+	Config        Configuration
+	configWatcher sync.Once
+)
+
+const CONFIG_WATCH_INTERVAL = 5 * time.Second
 
 type Configuration struct {
 	mu    sync.RWMutex
@@ -47,6 +53,10 @@ type FormElement struct {
 	Datalist    []string    `json:"datalist,omitempty"`
 	Order       int         `json:"-"`
 	Required    bool        `json:"required"`
+	Source      string      `json:"source,omitempty"`
+
+	override        *string
+	overrideChecked bool
 }
 
 func InitConfig() error {
@@ -67,7 +77,8 @@ func NewConfiguration() Configuration {
 					FormElement{Name: "name", Type: "text", Default: APPNAME, Description: "Name as shown in the UI", Placeholder: "Default: \"" + APPNAME + "\""},
 					FormElement{Name: "port", Type: "number", Default: 8334, Description: "Port on which the application is available.", Placeholder: "Default: 8334"},
 					FormElement{Name: "host", Type: "text", Description: "The host people need to use to access this server", Placeholder: WhiteLabelText("Eg: \"demo.filestash.app\"", "Eg: \"files.yourcompany.com\"")},
-					FormElement{Name: "secret_key", Type: "password", Required: true, Pattern: "[a-zA-Z0-9]{16}", Description: "The key that's used to encrypt and decrypt content. Update this settings will invalidate existing user sessions and shared links, use with caution!"},
+					FormElement{Name: "secret_key", Type: "password", Required: true, Pattern: "[a-zA-Z0-9]{16}", Description: "The key that's used to encrypt and decrypt content. Update this settings will invalidate existing user sessions and shared links, use with caution! Use the key rotation from the admin API to change it without disruption."},
+					FormElement{Name: "secret_keyring", Type: "hidden", Description: "Encrypted keys that have been rotated and can still decrypt content until they are retired."},
 					FormElement{Name: "force_ssl", Type: "boolean", Description: "Enable the web security mechanism called 'Strict Transport Security'"},
 					FormElement{Name: "editor", Type: "select", Default: "emacs", Opts: []string{"base", "emacs", "vim"}, Description: "Keybinding to be use in the editor. Default: \"emacs\""},
 					FormElement{Name: "logout", Type: "text", Default: "", Description: "Redirection URL whenever user click on the logout button"},
@@ -187,7 +198,18 @@ func (this *Configuration) Load() error {
 		Log.Error("config::load %s", err)
 		return err
 	}
+	this.hydrate(cFile)
+	this.applyOverrides()
+	this.cache.Clear()
+	Log.SetVisibility(this.Get("log.level").String())
+	for _, fn := range Hooks.Get.OnConfig() {
+		fn()
+	}
+	configWatcher.Do(this.watch)
+	return nil
+}
 
+func (this *Configuration) hydrate(cFile []byte) {
 	// Extract enabled backends
 	var d struct {
 		Connections []map[string]any `json:"connections"`
@@ -207,13 +229,27 @@ func (this *Configuration) Load() error {
 			el.currentElement.Value = value
 		}
 	}
+}
 
-	this.cache.Clear()
-	Log.SetVisibility(this.Get("log.level").String())
-	for _, fn := range Hooks.Get.OnConfig() {
-		fn()
+// watch reloads the config whenever the store reports a change made elsewhere, eg: by another
+// replica when the admin console is used
+func (this *Configuration) watch() {
+	ch, err := Hooks.Get.ConfigStore().Watch()
+	if err != nil {
+		Log.Warning("config::watch %s", err.Error())
+		return
+	} else if ch == nil {
+		return
 	}
-	return nil
+	go func() {
+		for range ch {
+			Log.Info("config::watch msg=reload")
+			// the key might have been rotated from another replica
+			if this.Load() == nil {
+				this.initSecrets()
+			}
+		}
+	}()
 }
 
 func flattenJSON(prefix string, m map[string]any) map[string]any {
@@ -254,7 +290,15 @@ func (this *Configuration) Initialise() {
 	if shouldSave {
 		this.Save()
 	}
+	this.initSecrets()
+}
+
+// initSecrets sets up the key material from the config as it stands once the overrides coming
+// from the environment are applied. Loading the config doesn't do it on its own as the loader is
+// also used to look at a config without making it the one in use
+func (this *Configuration) initSecrets() {
 	InitSecretDerivate(this.Get("general.secret_key").String())
+	InitSecretKeyring(this.Get("general.secret_keyring").String())
 }
 
 func (this *Configuration) Save() {
@@ -391,6 +435,9 @@ func (this *Configuration) Get(key string) *ConfigElement {
 	}
 	this.mu.Lock()
 	currentElement := traverse(&this.Form, strings.Split(key, "."))
+	if currentElement != nil {
+		currentElement.lookupOverride(key)
+	}
 	this.cache.Store(key, currentElement)
 	this.mu.Unlock()
 	return &ConfigElement{currentElement: currentElement, cfg: this}
@@ -473,7 +520,9 @@ func (this *ConfigElement) Interface() interface{} {
 	this.cfg.mu.RLock()
 	el := *this.currentElement
 	this.cfg.mu.RUnlock()
-	if el.Value == nil {
+	if el.override != nil {
+		return el.overrideValue()
+	} else if el.Value == nil {
 		return el.Default
 	}
 	return el.Value
@@ -488,13 +537,15 @@ func (this *Configuration) MarshalJSON() ([]byte, error) {
 			username = u.Name
 		}
 	}
-	return Form{Form: append(this.Form, Form{
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return formToJSON(Form{Form: append(this.Form, Form{
 		Title: "constant",
 		Elmnts: []FormElement{
 			{Name: "user", Type: "boolean", ReadOnly: true, Value: username},
 			{Name: "license", Type: "text", ReadOnly: true, Value: LICENSE},
 		},
-	})}.MarshalJSON()
+	})}, func(el FormElement) any { return el.withSource() })
 }
 
 func defaultValue(dval string, envName string) string {
@@ -0,0 +1,231 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Every save of the configuration goes through the OnConfigSave hooks with the config as it was
+ * before and after the change so it can be versioned. The diff shown in the admin console never
+ * contains the value of secrets, only the fact they've changed.
+ */
+const (
+	CONFIG_CHANGE_ADDED   = "added"
+	CONFIG_CHANGE_REMOVED = "removed"
+	CONFIG_CHANGE_UPDATED = "updated"
+	CONFIG_SECRET_MASK    = "********"
+	CONFIG_AUTHOR_SYSTEM  = "system"
+)
+
+type ConfigChange struct {
+	Key    string      `json:"key"`
+	Op     string      `json:"op"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+var configSecretName = regexp.MustCompile("(?i)(password|passwd|secret|token|passphrase|private_key|access_key|api_key)")
+
+func DiffConfig(before []byte, after []byte) []ConfigChange {
+	var a, b interface{}
+	json.Unmarshal(before, &a)
+	json.Unmarshal(after, &b)
+	flatBefore := flattenConfigDiff("", a, map[string]interface{}{})
+	flatAfter := flattenConfigDiff("", b, map[string]interface{}{})
+	secrets := Config.secretKeys()
+
+	changes := []ConfigChange{}
+	for key, valBefore := range flatBefore {
+		valAfter, ok := flatAfter[key]
+		if !ok {
+			changes = append(changes, ConfigChange{Key: key, Op: CONFIG_CHANGE_REMOVED, Before: valBefore})
+		} else if !reflect.DeepEqual(valBefore, valAfter) {
+			changes = append(changes, ConfigChange{Key: key, Op: CONFIG_CHANGE_UPDATED, Before: valBefore, After: valAfter})
+		}
+	}
+	for key, valAfter := range flatAfter {
+		if _, ok := flatBefore[key]; !ok {
+			changes = append(changes, ConfigChange{Key: key, Op: CONFIG_CHANGE_ADDED, After: valAfter})
+		}
+	}
+	for i := range changes {
+		if isConfigSecret(changes[i].Key, secrets) {
+			if changes[i].Before != nil {
+				changes[i].Before = CONFIG_SECRET_MASK
+			}
+			if changes[i].After != nil {
+				changes[i].After = CONFIG_SECRET_MASK
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+func flattenConfigDiff(prefix string, v interface{}, out map[string]interface{}) map[string]interface{} {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, nv := range val {
+			flattenConfigDiff(join(k), nv, out)
+		}
+	case []interface{}:
+		for i, nv := range val {
+			flattenConfigDiff(join(strconv.Itoa(i)), nv, out)
+		}
+	case nil:
+	default:
+		out[prefix] = val
+	}
+	return out
+}
+
+func isConfigSecret(key string, secrets map[string]bool) bool {
+	if secrets[key] {
+		return true
+	}
+	for _, p := range configKeysToEncrypt {
+		if key == p || strings.HasPrefix(key, p+".") {
+			return true
+		}
+	}
+	parts := strings.Split(key, ".")
+	return configSecretName.MatchString(parts[len(parts)-1])
+}
+
+// secretKeys lists the keys of the form that should never be shown in clear text
+func (this *Configuration) secretKeys() map[string]bool {
+	secrets := map[string]bool{}
+	this.mu.RLock()
+	walkForm("", this.Form, func(key string, el *FormElement) {
+		if el.Type == "password" || el.Type == "bcrypt" {
+			secrets[key] = true
+		}
+	})
+	this.mu.RUnlock()
+	return secrets
+}
+
+/*
+ * Validate is a dry run of a candidate config: every value it sets is checked against what the
+ * config form expects for it, then the candidate goes through the OnConfigValidate hooks so the
+ * plugins can reject what they can't make sense of. The live config is left alone.
+ */
+func (this *Configuration) Validate(candidate []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(candidate, &raw); err != nil {
+		return NewError("Invalid config: "+err.Error(), http.StatusBadRequest)
+	} else if conn, ok := raw["connections"]; ok && conn != nil {
+		list, ok := conn.([]interface{})
+		if !ok {
+			return NewError("Invalid config: connections must be a list", http.StatusBadRequest)
+		}
+		for i := range list {
+			c, ok := list[i].(map[string]interface{})
+			if !ok {
+				return NewError("Invalid config: connection must be an object", http.StatusBadRequest)
+			} else if t, _ := c["type"].(string); t == "" {
+				return NewError("Invalid config: connection without a type", http.StatusBadRequest)
+			}
+		}
+	}
+
+	schema := map[string]FormElement{}
+	this.mu.RLock()
+	walkForm("", this.Form, func(key string, el *FormElement) {
+		schema[key] = *el
+	})
+	this.mu.RUnlock()
+	keys := []string{}
+	values := flattenJSON("", raw)
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		el, ok := schema[key]
+		if !ok || values[key] == nil {
+			continue
+		}
+		el.Value = values[key]
+		if err := validateConfigElement(&el); err != nil {
+			Log.Debug("config::validate key=%s err=%s", key, err.Error())
+			return NewError("Invalid config: "+key+" "+err.Error(), http.StatusBadRequest)
+		}
+	}
+	c := this.candidate(candidate)
+	for _, fn := range Hooks.Get.OnConfigValidate() {
+		if err := fn(c); err != nil {
+			Log.Debug("config::validate action=hook err=%s", err.Error())
+			return NewError("Invalid config: "+err.Error(), http.StatusBadRequest)
+		}
+	}
+	return nil
+}
+
+// candidate gives a config that has the same form as this one with the values of cFile
+func (this *Configuration) candidate(cFile []byte) *Configuration {
+	var clone func(forms []Form) []Form
+	clone = func(forms []Form) []Form {
+		out := make([]Form, len(forms))
+		for i := range forms {
+			out[i] = Form{Title: forms[i].Title, Form: clone(forms[i].Form)}
+			for _, el := range forms[i].Elmnts {
+				el.Value = nil
+				out[i].Elmnts = append(out[i].Elmnts, el)
+			}
+		}
+		return out
+	}
+	c := &Configuration{}
+	this.mu.RLock()
+	c.Form = clone(this.Form)
+	this.mu.RUnlock()
+	c.hydrate(cFile)
+	c.applyOverrides()
+	return c
+}
+
+func validateConfigElement(el *FormElement) error {
+	switch el.Type {
+	case "number":
+		if _, ok := el.Value.(float64); !ok {
+			return fmt.Errorf("must be a number")
+		}
+	case "boolean", "enable":
+		if _, ok := el.Value.(bool); !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+	case "select":
+		v, ok := el.Value.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		} else if len(el.Opts) == 0 || v == "" || el.MultiValue {
+			return nil
+		}
+		for _, opt := range el.Opts {
+			if opt == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(el.Opts, ", "))
+	}
+	if v, ok := el.Value.(string); ok && v != "" && el.Pattern != "" {
+		if re, err := regexp.Compile("^(?:" + el.Pattern + ")$"); err == nil && !re.MatchString(v) {
+			return fmt.Errorf("doesn't match the expected format")
+		}
+	}
+	return nil
+}
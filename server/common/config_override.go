@@ -0,0 +1,128 @@
+package common
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/sjson"
+)
+
+/*
+ * Any config key can be set from the environment, which is handy for container deployments:
+ * - FILESTASH_<KEY>=value, eg: FILESTASH_FEATURES_SHARE_ENABLE=false for features.share.enable
+ * - FILESTASH_<KEY>_FILE=/path, eg: FILESTASH_EMAIL_PASSWORD_FILE=/run/secrets/smtp for secrets
+ * An overridden key takes precedence over config.json, is read only in the admin console and
+ * is never persisted in the config store.
+ */
+const (
+	CONFIG_SOURCE_ENV     = "env"
+	CONFIG_SOURCE_FILE    = "file"
+	CONFIG_SOURCE_CONFIG  = "config"
+	CONFIG_SOURCE_DEFAULT = "default"
+)
+
+var configEnvReplacer = regexp.MustCompile("[^A-Z0-9]+")
+
+func ConfigEnvName(key string) string {
+	return "FILESTASH_" + configEnvReplacer.ReplaceAllString(strings.ToUpper(key), "_")
+}
+
+// lookupOverride must be called with the lock of the config held
+func (this *FormElement) lookupOverride(key string) {
+	if this.overrideChecked {
+		return
+	}
+	this.overrideChecked = true
+	name := ConfigEnvName(key)
+	if val, ok := os.LookupEnv(name); ok {
+		this.override = &val
+		this.Source = CONFIG_SOURCE_ENV
+		return
+	}
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			Log.Warning("config::override cannot read '%s' for %s: %s", path, key, err.Error())
+			return
+		}
+		val := strings.TrimRight(string(b), "\r\n")
+		this.override = &val
+		this.Source = CONFIG_SOURCE_FILE
+	}
+}
+
+// IsOverridden tells if the value of the element comes from the environment
+func (this *ConfigElement) IsOverridden() bool {
+	if this.currentElement == nil {
+		return false
+	}
+	this.cfg.mu.RLock()
+	defer this.cfg.mu.RUnlock()
+	return this.currentElement.override != nil
+}
+
+// overrideValue converts the raw string coming from the environment into the type of the element
+func (this FormElement) overrideValue() interface{} {
+	val := *this.override
+	switch this.Type {
+	case "boolean", "enable":
+		b, _ := strconv.ParseBool(val)
+		return b
+	case "number":
+		if n, err := strconv.ParseFloat(val, 64); err == nil {
+			return n
+		}
+	}
+	return val
+}
+
+// withSource is the representation of an element in the admin console
+func (this FormElement) withSource() FormElement {
+	if this.override != nil {
+		this.Value = this.overrideValue()
+		this.ReadOnly = true
+	} else if this.Value != nil {
+		this.Source = CONFIG_SOURCE_CONFIG
+	} else {
+		this.Source = CONFIG_SOURCE_DEFAULT
+	}
+	return this
+}
+
+// applyOverrides looks for overrides on every key, including the ones no one has asked for yet
+func (this *Configuration) applyOverrides() {
+	this.mu.Lock()
+	walkForm("", this.Form, func(key string, el *FormElement) {
+		el.lookupOverride(key)
+	})
+	this.mu.Unlock()
+}
+
+// restoreOverrides ensures values coming from the environment never land in the config store
+// by putting back what was there before
+func (this *Configuration) restoreOverrides(configStr string) string {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	walkForm("", this.Form, func(key string, el *FormElement) {
+		if el.override == nil {
+			return
+		} else if el.Value == nil {
+			configStr, _ = sjson.Delete(configStr, key)
+		} else {
+			configStr, _ = sjson.Set(configStr, key, el.Value)
+		}
+	})
+	return configStr
+}
+
+func walkForm(prefix string, forms []Form, fn func(key string, el *FormElement)) {
+	for i := range forms {
+		p := prefix + strings.ReplaceAll(forms[i].Title, " ", "_")
+		for j := range forms[i].Elmnts {
+			fn(p+"."+strings.ReplaceAll(forms[i].Elmnts[j].Name, " ", "_"), &forms[i].Elmnts[j])
+		}
+		walkForm(p+".", forms[i].Form, fn)
+	}
+}
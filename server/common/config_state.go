@@ -1,24 +1,21 @@
 package common
 
 /*
- * WARNING WARNING WARNING WARNING WARNING WARNING WARNING WARNING WARNING WARNI
- * WARNING - CHANGE IN THIS FILE CAN SILENTLY BREAK OTHER INSTALLATION - WARNING
- * WARNING WARNING WARNING WARNING WARNING WARNING WARNING WARNING WARNING WARN
- *
- * Some contributors wanted to be able to load and persist config in other system
- * like S3 and provide custom encryption layer on top of it. Those contributors have
- * custom plugins which run generators that override this file before the build is
- * generated. Indeed for that specific use case we couldn't extend the runtime plugin
- * mechanism so had to fallback to this approach which would set the config loader at
- * build time, hence this warning.
+ * The configuration is persisted through a store that's pluggable with Hooks.Register.ConfigStore
+ * so it can live outside of config.json, like in S3, a database or a secrets manager. The store
+ * deals with raw bytes, the encryption of the sensitive parts of the config happens here before
+ * anything reaches the store.
  */
 
 import (
 	"fmt"
-	"github.com/tidwall/gjson"
-	"github.com/tidwall/sjson"
 	"io"
 	"os"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 var (
@@ -26,7 +23,8 @@ var (
 		"middleware.identity_provider.params",
 		"middleware.attribute_mapping.params",
 	}
-	config_path func() string
+	config_path        func() string
+	defaultConfigStore *fileConfigStore = &fileConfigStore{}
 )
 
 func init() {
@@ -36,31 +34,41 @@ func init() {
 }
 
 func LoadConfig() ([]byte, error) {
-	file, err := os.OpenFile(config_path(), os.O_RDONLY, os.ModePerm)
-	if err != nil {
-		if os.IsNotExist(err) {
-			os.MkdirAll(GetAbsolutePath(CONFIG_PATH), os.ModePerm)
-			return []byte(""), nil
-		}
-		return nil, err
-	}
-	cFile, err := io.ReadAll(file)
-	file.Close()
+	cFile, err := Hooks.Get.ConfigStore().Load()
 	if err != nil {
 		return nil, err
 	}
+	return DecryptConfig(cFile), nil
+}
+
+// DecryptConfig reverts the encryption made on the sensitive parts of the config when it's saved
+func DecryptConfig(cFile []byte) []byte {
 	configStr := string(cFile)
+	keys := []string{os.Getenv("CONFIG_SECRET")}
+	if keys[0] == "" {
+		// the config might have been encrypted with a key that has been rotated since
+		keys = []string{}
+		if secret := gjson.Get(configStr, "general.secret_key").String(); secret != "" {
+			keys = append(keys, deriveSecret("PROOF_", secret))
+			state, _ := keyringDecode(secret, gjson.Get(configStr, "general.secret_keyring").String())
+			for _, k := range state.Keys {
+				keys = append(keys, deriveSecret("PROOF_", k))
+			}
+		}
+		keys = append(keys, SecretDerivates("PROOF_")...)
+	}
 	for _, jsonPathWithEncryptedData := range configKeysToEncrypt {
 		p := gjson.Get(configStr, jsonPathWithEncryptedData).String()
 		if p == "" {
 			continue
 		}
-		key := os.Getenv("CONFIG_SECRET")
-		if key == "" {
-			InitSecretDerivate(gjson.Get(configStr, "general.secret_key").String())
-			key = SECRET_KEY_DERIVATE_FOR_PROOF
+		var t string
+		var err error = ErrNotValid
+		for _, key := range keys {
+			if t, err = DecryptString(Hash(key, 16), p); err == nil {
+				break
+			}
 		}
-		t, err := DecryptString(Hash(key, 16), p)
 		if err != nil {
 			Log.Warning("common::config_state::load cannot decrypt config path '%s': %s", jsonPathWithEncryptedData, err.Error())
 			continue
@@ -72,20 +80,17 @@ func LoadConfig() ([]byte, error) {
 		}
 		configStr = val
 	}
-	return []byte(configStr), nil
+	return []byte(configStr)
 }
 
 func SaveConfig(v []byte) error {
-	file, err := os.Create(config_path())
-	if err != nil {
-		return fmt.Errorf(
-			APPNAME+" needs to be able to create and edit its configuration, but it currently cannot. "+
-				"Change the permissions to allow writing to `%s`",
-			config_path(),
-		)
-	}
+	return SaveConfigAs(v, CONFIG_AUTHOR_SYSTEM)
+}
 
-	configStr := string(v)
+// SaveConfigAs persists the config on behalf of someone, eg: an admin session, so the change
+// can be traced back in the config history
+func SaveConfigAs(v []byte, author string) error {
+	configStr := Config.restoreOverrides(string(v))
 	for _, jsonPathWithEncryptedData := range configKeysToEncrypt {
 		key := os.Getenv("CONFIG_SECRET")
 		if key == "" {
@@ -107,10 +112,95 @@ func SaveConfig(v []byte) error {
 		}
 		configStr = val
 	}
-	file.Write(PrettyPrint([]byte(configStr)))
+	store := Hooks.Get.ConfigStore()
+	before, err := store.Load()
+	if err != nil {
+		before = []byte("")
+	}
+	after := PrettyPrint([]byte(configStr))
+	if err = store.Save(after); err != nil {
+		return err
+	}
+	for _, fn := range Hooks.Get.OnConfigSave() {
+		fn(before, after, author)
+	}
+	return nil
+}
+
+// FileConfigStore gives the store keeping the config in config.json, the one used by default
+func FileConfigStore() IConfigStore {
+	return defaultConfigStore
+}
+
+/*
+ * fileConfigStore keeps the configuration in config.json. Changes made by something else than
+ * this process, like another replica sharing the same volume, are picked up by polling the file
+ */
+type fileConfigStore struct {
+	mu        sync.Mutex
+	lastWrite time.Time
+}
+
+func (this *fileConfigStore) Load() ([]byte, error) {
+	file, err := os.OpenFile(config_path(), os.O_RDONLY, os.ModePerm)
+	if err != nil {
+		if os.IsNotExist(err) {
+			os.MkdirAll(GetAbsolutePath(CONFIG_PATH), os.ModePerm)
+			return []byte(""), nil
+		}
+		return nil, err
+	}
+	cFile, err := io.ReadAll(file)
+	file.Close()
+	return cFile, err
+}
+
+func (this *fileConfigStore) Save(v []byte) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	file, err := os.Create(config_path())
+	if err != nil {
+		return fmt.Errorf(
+			APPNAME+" needs to be able to create and edit its configuration, but it currently cannot. "+
+				"Change the permissions to allow writing to `%s`",
+			config_path(),
+		)
+	}
+	file.Write(v)
 	if err = file.Sync(); err != nil {
 		file.Close()
 		return err
 	}
-	return file.Close()
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if f, err := os.Stat(config_path()); err == nil {
+		this.lastWrite = f.ModTime()
+	}
+	return nil
+}
+
+func (this *fileConfigStore) Watch() (chan struct{}, error) {
+	ch := make(chan struct{})
+	go func() {
+		var lastSeen time.Time
+		if f, err := os.Stat(config_path()); err == nil {
+			lastSeen = f.ModTime()
+		}
+		for {
+			time.Sleep(CONFIG_WATCH_INTERVAL)
+			f, err := os.Stat(config_path())
+			if err != nil || f.ModTime().Equal(lastSeen) {
+				continue
+			}
+			lastSeen = f.ModTime()
+			this.mu.Lock()
+			isOwnWrite := lastSeen.Equal(this.lastWrite)
+			this.mu.Unlock()
+			if !isOwnWrite {
+				ch <- struct{}{}
+			}
+		}
+	}()
+	return ch, nil
 }
@@ -72,11 +72,12 @@ var (
 
 func InitSecretDerivate(secret string) {
 	SECRET_KEY = secret
-	SECRET_KEY_DERIVATE_FOR_PROOF = Hash("PROOF_"+SECRET_KEY, len(SECRET_KEY))
-	SECRET_KEY_DERIVATE_FOR_ADMIN = Hash("ADMIN_"+SECRET_KEY, len(SECRET_KEY))
-	SECRET_KEY_DERIVATE_FOR_USER = Hash("USER_"+SECRET_KEY, len(SECRET_KEY))
-	SECRET_KEY_DERIVATE_FOR_HASH = Hash("HASH_"+SECRET_KEY, len(SECRET_KEY))
-	SECRET_KEY_DERIVATE_FOR_SIGNATURE = Hash("SGN_"+SECRET_KEY, len(SECRET_KEY))
+	SECRET_KEY_DERIVATE_FOR_PROOF = deriveSecret("PROOF_", SECRET_KEY)
+	SECRET_KEY_DERIVATE_FOR_ADMIN = deriveSecret("ADMIN_", SECRET_KEY)
+	SECRET_KEY_DERIVATE_FOR_USER = deriveSecret("USER_", SECRET_KEY)
+	SECRET_KEY_DERIVATE_FOR_HASH = deriveSecret("HASH_", SECRET_KEY)
+	SECRET_KEY_DERIVATE_FOR_SIGNATURE = deriveSecret("SGN_", SECRET_KEY)
+	keyringSetActive(SECRET_KEY)
 }
 
 func WithBase(href string) string {
@@ -16,6 +16,7 @@ import (
 	"os"
 	"runtime"
 	"sort"
+	"strings"
 	"sync"
 )
 
@@ -33,15 +34,29 @@ func EncryptString(secret string, data string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if id := keyringEncryptId(secret); id != "" {
+		return id + KEYRING_SEPARATOR + base64.URLEncoding.EncodeToString(d), nil
+	}
 	return base64.URLEncoding.EncodeToString(d), nil
 }
 
 func DecryptString(secret string, data string) (string, error) {
+	id := ""
+	if i := strings.Index(data, KEYRING_SEPARATOR); i != -1 {
+		id, data = data[:i], data[i+1:]
+	}
 	d, err := base64.URLEncoding.DecodeString(data)
 	if err != nil {
 		return "", err
 	}
-	d, err = DecryptAESGCM([]byte(secret), d)
+	err = ErrNotValid
+	for _, s := range keyringDecryptSecrets(secret, id) {
+		var out []byte
+		if out, err = DecryptAESGCM([]byte(s), d); err == nil {
+			d = out
+			break
+		}
+	}
 	if err != nil {
 		return "", err
 	}
@@ -213,7 +228,7 @@ func GenerateID(params map[string]string) string {
 	if p == "" {
 		return "na"
 	}
-	p += "salt=>" + SECRET_KEY
+	p += "salt=>" + secretSalt()
 	return Hash(p, 20)
 }
 
@@ -29,6 +29,7 @@ var (
 	ErrCongestion           = NewError("Traffic congestion, try again later", 500)
 	ErrTimeout              = NewError("Timeout", 500)
 	ErrInternal             = NewError("Internal Error", 500)
+	ErrQuotaExceeded        = NewError("Quota exceeded", 507)
 )
 
 func IsATranslatedError(err error) bool {
@@ -83,7 +83,7 @@ func SafeOsMkdir(path string, mode os.FileMode) error {
 }
 
 func SafeOsRemove(path string) error {
-	if err := safePath(path); err != nil {
+	if err := safeLinkPath(path); err != nil {
 		Log.Debug("common::files safeOsRemove err[%s] path[%s]", err.Error(), path)
 		return ErrFilesystemError
 	}
@@ -91,7 +91,7 @@ func SafeOsRemove(path string) error {
 }
 
 func SafeOsRemoveAll(path string) error {
-	if err := safePath(path); err != nil {
+	if err := safeLinkPath(path); err != nil {
 		Log.Debug("common::files safeOsRemoveAll err[%s] path[%s]", err.Error(), path)
 		return ErrFilesystemError
 	}
@@ -99,7 +99,7 @@ func SafeOsRemoveAll(path string) error {
 }
 
 func SafeOsRename(from string, to string) error {
-	if err := safePath(from); err != nil {
+	if err := safeLinkPath(from); err != nil {
 		Log.Debug("common::files safeOsRename err[%s] from[%s]", err.Error(), from)
 		return ErrFilesystemError
 	} else if err := safePath(to); err != nil {
@@ -109,6 +109,52 @@ func SafeOsRename(from string, to string) error {
 	return processError(os.Rename(from, to))
 }
 
+func SafeOsLstat(path string) (os.FileInfo, error) {
+	if err := safeLinkPath(path); err != nil {
+		Log.Debug("common::files safeOsLstat err[%s] path[%s]", err.Error(), path)
+		return nil, ErrFilesystemError
+	}
+	info, err := os.Lstat(strings.TrimSuffix(path, "/"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return info, processError(err)
+}
+
+func SafeOsChmod(path string, mode os.FileMode) error {
+	if err := safePath(path); err != nil {
+		Log.Debug("common::files safeOsChmod err[%s] path[%s]", err.Error(), path)
+		return ErrFilesystemError
+	}
+	return processError(os.Chmod(path, mode))
+}
+
+func SafeOsLchown(path string, uid int, gid int) error {
+	if err := safeLinkPath(path); err != nil {
+		Log.Debug("common::files safeOsLchown err[%s] path[%s]", err.Error(), path)
+		return ErrFilesystemError
+	}
+	return processError(os.Lchown(strings.TrimSuffix(path, "/"), uid, gid))
+}
+
+// SafeOsSymlink creates a link, where it points to doesn't matter as it is never followed
+func SafeOsSymlink(target string, path string) error {
+	if err := safePath(path); err != nil {
+		Log.Debug("common::files safeOsSymlink err[%s] path[%s]", err.Error(), path)
+		return ErrFilesystemError
+	}
+	return processError(os.Symlink(target, strings.TrimSuffix(path, "/")))
+}
+
+func SafeOsReadlink(path string) (string, error) {
+	if err := safeLinkPath(path); err != nil {
+		Log.Debug("common::files safeOsReadlink err[%s] path[%s]", err.Error(), path)
+		return "", ErrFilesystemError
+	}
+	target, err := os.Readlink(strings.TrimSuffix(path, "/"))
+	return target, processError(err)
+}
+
 func GlobMatch(pattern, name string) bool {
 	m, _ := doublestar.Match(pattern, name)
 	return m
@@ -130,6 +176,16 @@ func safePath(path string) error {
 	return nil
 }
 
+// safeLinkPath is safePath for the things that act on a symlink itself instead of where it
+// points to: a symlink is fine as long as it sits in a safe place
+func safeLinkPath(path string) error {
+	path = strings.TrimSuffix(path, "/")
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return safePath(filepath.Dir(path))
+	}
+	return safePath(path)
+}
+
 func processError(err error) error {
 	if err == nil {
 		return nil
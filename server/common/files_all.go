@@ -13,3 +13,7 @@ func SafeOsOpenFile(path string, flag int, perm os.FileMode) (*os.File, error) {
 	}
 	return os.OpenFile(path, flag, perm)
 }
+
+func NewFilePosix(info os.FileInfo) *FilePosix {
+	return &FilePosix{Mode: UnixMode(info.Mode()), Uid: -1, Gid: -1}
+}
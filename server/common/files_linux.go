@@ -21,3 +21,12 @@ func SafeOsOpenFile(path string, flag int, perm os.FileMode) (*os.File, error) {
 	}
 	return f, err
 }
+
+// NewFilePosix gives the permissions and owner of a file from the local filesystem
+func NewFilePosix(info os.FileInfo) *FilePosix {
+	p := &FilePosix{Mode: UnixMode(info.Mode()), Uid: -1, Gid: -1}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		p.Mode, p.Uid, p.Gid = st.Mode&07777, int(st.Uid), int(st.Gid)
+	}
+	return p
+}
@@ -0,0 +1,269 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+)
+
+/*
+ * The keyring keeps track of the secret keys that have been used over time. The active key is
+ * general.secret_key, it encrypts anything new and its id is embedded in the ciphertext as
+ * "<id>.<data>". Keys that have been rotated stay in the keyring to decrypt what was made with
+ * them until they are retired. Identifiers made by GenerateID are salted with the key that was
+ * active before the first rotation so they survive it, otherwise every tenant data would be
+ * orphaned. The rotated keys and the salt are kept in general.secret_keyring encrypted with the
+ * active key, or CONFIG_SECRET when it's set, so they never show up in clear text in the config,
+ * its history or the admin console.
+ */
+const KEYRING_SEPARATOR = "."
+
+var (
+	keyring struct {
+		sync.RWMutex
+		active  keyringEntry
+		retired []keyringEntry
+		salt    string
+	}
+	secretDerivateLabels = []string{"PROOF_", "ADMIN_", "USER_", "HASH_", "SGN_"}
+)
+
+type keyringState struct {
+	Salt string   `json:"salt"`
+	Keys []string `json:"keys"`
+}
+
+type KeyringKey struct {
+	Id     string `json:"id"`
+	Active bool   `json:"active"`
+}
+
+type keyringEntry struct {
+	id        string
+	secret    string
+	derivates map[string]string
+}
+
+func newKeyringEntry(secret string) keyringEntry {
+	k := keyringEntry{
+		id:        Hash("KID_"+secret, 6),
+		secret:    secret,
+		derivates: map[string]string{},
+	}
+	for _, label := range secretDerivateLabels {
+		k.derivates[label] = deriveSecret(label, secret)
+	}
+	return k
+}
+
+func deriveSecret(label string, secret string) string {
+	return Hash(label+secret, len(secret))
+}
+
+func keyringSetActive(secret string) {
+	keyring.Lock()
+	keyring.active = newKeyringEntry(secret)
+	keyring.Unlock()
+}
+
+// RegisterSecretDerivate makes the keyring aware of a secret a plugin derives from the secret
+// key so what it encrypts keeps working once the key is rotated. It has to be called from init
+func RegisterSecretDerivate(label string) {
+	secretDerivateLabels = append(secretDerivateLabels, label)
+}
+
+// SecretDerivate gives the derivate of the active key for a purpose
+func SecretDerivate(label string) string {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	if s, ok := keyring.active.derivates[label]; ok {
+		return s
+	}
+	return deriveSecret(label, keyring.active.secret)
+}
+
+// InitSecretKeyring loads the keys that have been rotated and the salt used for identifiers
+// from general.secret_keyring, it has to be called once the active key is known
+func InitSecretKeyring(encrypted string) {
+	keyring.RLock()
+	secret := keyring.active.secret
+	keyring.RUnlock()
+	state, err := keyringDecode(secret, encrypted)
+	if err != nil {
+		Log.Warning("common::keyring::init cannot decrypt the keyring: %s", err.Error())
+	}
+	keyring.Lock()
+	defer keyring.Unlock()
+	keyring.retired = []keyringEntry{}
+	for _, secret := range state.Keys {
+		if secret == "" || secret == keyring.active.secret {
+			continue
+		}
+		keyring.retired = append(keyring.retired, newKeyringEntry(secret))
+	}
+	keyring.salt = state.Salt
+}
+
+// KeyringRotate makes secret the active key, the previous one joining the keyring. It gives
+// what general.secret_keyring has to be set to
+func KeyringRotate(secret string) (string, error) {
+	keyring.Lock()
+	previous := keyring.active
+	if keyring.salt == "" {
+		keyring.salt = previous.secret
+	}
+	if previous.secret != "" && previous.secret != secret {
+		keyring.retired = append([]keyringEntry{previous}, keyring.retired...)
+	}
+	keyring.Unlock()
+	InitSecretDerivate(secret)
+	return keyringExport(func(k keyringEntry) bool { return true })
+}
+
+func KeyringList() []KeyringKey {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	keys := []KeyringKey{{Id: keyring.active.id, Active: true}}
+	for _, k := range keyring.retired {
+		keys = append(keys, KeyringKey{Id: k.id, Active: false})
+	}
+	return keys
+}
+
+// KeyringRetire gives what general.secret_keyring becomes once the key matching id is removed
+// from the keyring, an empty id retires every key that isn't active
+func KeyringRetire(id string) (string, error) {
+	keyring.RLock()
+	isActive := id == keyring.active.id
+	keyring.RUnlock()
+	if isActive {
+		return "", NewError("The active key cannot be retired", http.StatusBadRequest)
+	}
+	found := id == ""
+	encrypted, err := keyringExport(func(k keyringEntry) bool {
+		if id == "" || k.id == id {
+			found = true
+			return false
+		}
+		return true
+	})
+	if err == nil && !found {
+		return "", ErrNotFound
+	}
+	return encrypted, err
+}
+
+// keyringExport encrypts the salt and the rotated keys that are kept
+func keyringExport(keep func(k keyringEntry) bool) (string, error) {
+	keyring.RLock()
+	state := keyringState{Salt: keyring.salt, Keys: []string{}}
+	for _, k := range keyring.retired {
+		if keep(k) {
+			state.Keys = append(state.Keys, k.secret)
+		}
+	}
+	keyring.RUnlock()
+	if state.Salt == "" && len(state.Keys) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	return EncryptString(keyringSecret(), string(b))
+}
+
+func keyringSecret() string {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	return keyringSecretFor(keyring.active.secret)
+}
+
+func keyringSecretFor(secret string) string {
+	if key := os.Getenv("CONFIG_SECRET"); key != "" {
+		return Hash("KEYRING_"+key, 16)
+	}
+	return Hash(deriveSecret("KEYRING_", secret), 16)
+}
+
+// keyringDecode reads a general.secret_keyring that was made while secret was the active key
+// without touching the keyring in use
+func keyringDecode(secret string, encrypted string) (keyringState, error) {
+	state := keyringState{}
+	if encrypted == "" {
+		return state, nil
+	}
+	str, err := DecryptString(keyringSecretFor(secret), encrypted)
+	if err == nil {
+		err = json.Unmarshal([]byte(str), &state)
+	}
+	return state, err
+}
+
+// SecretDerivates gives the derivates of every known key for a purpose, the active one first
+func SecretDerivates(label string) []string {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	secrets := []string{}
+	if keyring.active.derivates != nil {
+		secrets = append(secrets, keyring.active.derivates[label])
+	}
+	for _, k := range keyring.retired {
+		secrets = append(secrets, k.derivates[label])
+	}
+	return secrets
+}
+
+func secretSalt() string {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	if keyring.salt != "" {
+		return keyring.salt
+	}
+	return SECRET_KEY
+}
+
+// keyringEncryptId is the id to embed in a ciphertext made with secret. Secrets that aren't
+// derived from the active key, like the ones plugins derive on their own, don't get any
+func keyringEncryptId(secret string) string {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	for _, s := range keyring.active.derivates {
+		if s == secret {
+			return keyring.active.id
+		}
+	}
+	return ""
+}
+
+// keyringDecryptSecrets gives the secrets to try to decrypt something that was encrypted with
+// the key id, or with an unknown key for data made before the keyring existed
+func keyringDecryptSecrets(secret string, id string) []string {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	label := ""
+	for l, s := range keyring.active.derivates {
+		if s == secret {
+			label = l
+			break
+		}
+	}
+	if label == "" {
+		return []string{secret}
+	} else if id == keyring.active.id {
+		return []string{secret}
+	} else if id != "" {
+		for _, k := range keyring.retired {
+			if k.id == id {
+				return []string{k.derivates[label]}
+			}
+		}
+		return []string{}
+	}
+	secrets := []string{secret}
+	for _, k := range keyring.retired {
+		secrets = append(secrets, k.derivates[label])
+	}
+	return secrets
+}
@@ -274,6 +274,43 @@ func (this Get) OnConfig() []func() {
 	return configChange
 }
 
+var configValidate []func(candidate *Configuration) error
+
+// OnConfigValidate is called when a config is validated before being saved with the candidate
+// config, the environment overrides applied. A plugin uses it to reject what it can't make
+// sense of without touching the config in use
+func (this Register) OnConfigValidate(fn func(candidate *Configuration) error) {
+	configValidate = append(configValidate, fn)
+}
+
+func (this Get) OnConfigValidate() []func(candidate *Configuration) error {
+	return configValidate
+}
+
+var configSave []func(before []byte, after []byte, author string)
+
+// OnConfigSave is called every time the config is persisted with its raw content before and
+// after the change as it was given to the config store
+func (this Register) OnConfigSave(fn func(before []byte, after []byte, author string)) {
+	configSave = append(configSave, fn)
+}
+
+func (this Get) OnConfigSave() []func(before []byte, after []byte, author string) {
+	return configSave
+}
+
+var keyringReencrypt []func() error
+
+// OnKeyringReencrypt is called once the secret key has been rotated and before a rotated key
+// gets retired so what was encrypted with an older key is encrypted again with the active one
+func (this Register) OnKeyringReencrypt(fn func() error) {
+	keyringReencrypt = append(keyringReencrypt, fn)
+}
+
+func (this Get) OnKeyringReencrypt() []func() error {
+	return keyringReencrypt
+}
+
 var middlewares []func(HandlerFunc) HandlerFunc
 
 func (this Register) Middleware(m func(HandlerFunc) HandlerFunc) {
@@ -315,6 +352,49 @@ func (this Get) Metadata() IMetadata {
 	return meta
 }
 
+var quota IQuota
+
+func (this Register) Quota(q IQuota) {
+	quota = q
+}
+
+func (this Get) Quota() IQuota {
+	return quota
+}
+
+/*
+ * ConfigStore is where the configuration is persisted. By default it's the config.json file
+ * but plugins can keep it elsewhere like in a database, in which case the store must be
+ * registered from an init function as the configuration is loaded before the plugins
+ */
+var config_store IConfigStore
+
+func (this Register) ConfigStore(s IConfigStore) {
+	config_store = s
+}
+
+func (this Get) ConfigStore() IConfigStore {
+	if config_store == nil {
+		return defaultConfigStore
+	}
+	return config_store
+}
+
+/*
+ * BackendCache puts a cache in front of the connections flagged with "cache": true in the config.
+ * connect makes the actual connection to the remote, the cache only calls it when it can't
+ * answer on its own
+ */
+var backend_cache func(params map[string]string, app *App, connect func(app *App) (IBackend, error)) (IBackend, error)
+
+func (this Register) BackendCache(fn func(params map[string]string, app *App, connect func(app *App) (IBackend, error)) (IBackend, error)) {
+	backend_cache = fn
+}
+
+func (this Get) BackendCache() func(params map[string]string, app *App, connect func(app *App) (IBackend, error)) (IBackend, error) {
+	return backend_cache
+}
+
 var workflow_triggers []ITrigger
 
 func (this Register) WorkflowTrigger(t ITrigger) {
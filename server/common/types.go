@@ -36,6 +36,7 @@ type IAuthorisation interface {
 	Mv(ctx *App, from string, to string) error
 	Save(ctx *App, path string) error
 	Touch(ctx *App, path string) error
+	Chmod(ctx *App, path string) error
 }
 
 type IFile interface {
@@ -80,6 +81,50 @@ type IMetadata interface {
 	Search(ctx *App, path string, facets map[string]any) (map[string][]FormElement, error)
 }
 
+/*
+ * IQuota keeps track of how much people store. Allowance gives the number of bytes that can still
+ * be written under a path, -1 when there's no limit, the On* functions are called once a change
+ * has been made on the storage so the usage can be updated without scanning everything again
+ */
+type IQuota interface {
+	Usage(ctx *App, path string) (QuotaUsage, error)
+	Allowance(ctx *App, path string) (int64, error)
+	OnSave(ctx *App, path string, size int64)
+	OnRm(ctx *App, path string)
+	OnMv(ctx *App, from string, to string)
+}
+
+type QuotaUsage struct {
+	Used      int64            `json:"used"`
+	Limit     int64            `json:"limit"`
+	Available int64            `json:"available"`
+	Scanning  bool             `json:"scanning"`
+	Backend   *BackendCapacity `json:"backend,omitempty"`
+}
+
+// IBackendCapacity is for backends able to tell how much space they use and have left without
+// listing every file, a value of -1 means unknown or unlimited
+type IBackendCapacity interface {
+	Capacity(path string) (BackendCapacity, error)
+}
+
+// IBackendReplace is for backends able to move a file over an existing one in a single step, an
+// upload can then be written aside and only take the place of the file once it's complete
+type IBackendReplace interface {
+	Replace(from string, to string) error
+}
+
+type BackendCapacity struct {
+	Used      int64 `json:"used"`
+	Available int64 `json:"available"`
+}
+
+type IConfigStore interface {
+	Load() ([]byte, error)
+	Save(config []byte) error
+	Watch() (chan struct{}, error)
+}
+
 type ITrigger interface {
 	Manifest() WorkflowSpecs
 	Init() (chan ITriggerEvent, error)
@@ -115,12 +160,13 @@ type WorkflowSpecs struct {
 }
 
 type File struct {
-	FName   string `json:"name"`
-	FType   string `json:"type"`
-	FTime   int64  `json:"time"`
-	FSize   int64  `json:"size"`
-	FPath   string `json:"path,omitempty"`
-	Offline bool   `json:"offline,omitempty"`
+	FName   string     `json:"name"`
+	FType   string     `json:"type"`
+	FTime   int64      `json:"time"`
+	FSize   int64      `json:"size"`
+	FPath   string     `json:"path,omitempty"`
+	Offline bool       `json:"offline,omitempty"`
+	Posix   *FilePosix `json:"posix,omitempty"`
 }
 
 func (f File) Name() string {
@@ -130,7 +176,11 @@ func (f File) Size() int64 {
 	return f.FSize
 }
 func (f File) Mode() os.FileMode {
-	if f.IsDir() {
+	if f.Posix != nil && f.IsDir() {
+		return FileModeFromUnix(f.Posix.Mode) | os.ModeDir
+	} else if f.Posix != nil {
+		return FileModeFromUnix(f.Posix.Mode)
+	} else if f.IsDir() {
 		return os.ModeDir
 	}
 	return os.FileMode(0664)
@@ -0,0 +1,56 @@
+package ctrl
+
+import (
+	"net/http"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+type FileChecksumResult struct {
+	Algo     string `json:"algo"`
+	Checksum string `json:"checksum"`
+	Computed bool   `json:"computed"`
+	Match    *bool  `json:"match,omitempty"`
+}
+
+func FileChecksum(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if model.CanRead(ctx) == false {
+		Log.Debug("checksum::permission 'permission denied'")
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	path, err := PathBuilder(ctx, req.URL.Query().Get("path"))
+	if err != nil {
+		Log.Debug("checksum::path '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	} else if IsDirectory(path) {
+		SendErrorResult(res, ErrNotValid)
+		return
+	}
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err = auth.Cat(ctx, path); err != nil {
+			Log.Info("checksum::auth '%s'", err.Error())
+			SendErrorResult(res, ErrNotAuthorized)
+			return
+		}
+	}
+	algo := strings.ToLower(req.URL.Query().Get("algo"))
+	if algo == "" {
+		algo = CHECKSUM_SHA256
+	}
+	sum, computed, err := Checksum(ctx.Backend, path, algo)
+	if err != nil {
+		Log.Debug("checksum::compute algo=%s err=%s", algo, err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	result := FileChecksumResult{Algo: algo, Checksum: sum, Computed: computed}
+	if expected := req.URL.Query().Get("expected"); expected != "" {
+		match := strings.EqualFold(expected, sum)
+		result.Match = &match
+	}
+	SendSuccessResult(res, result)
+}
@@ -2,8 +2,14 @@ package ctrl
 
 import (
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
 	"io"
 	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 var configpath = GetAbsolutePath(CONFIG_PATH, "config.json")
@@ -14,7 +20,7 @@ func PrivateConfigHandler(ctx *App, res http.ResponseWriter, req *http.Request)
 
 func PrivateConfigUpdateHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
 	b, _ := io.ReadAll(req.Body)
-	if err := SaveConfig(b); err != nil {
+	if err := SaveConfigAs(b, adminAuthor(req)); err != nil {
 		SendErrorResult(res, err)
 		return
 	}
@@ -22,7 +28,103 @@ func PrivateConfigUpdateHandler(ctx *App, res http.ResponseWriter, req *http.Req
 	SendSuccessResult(res, nil)
 }
 
+func PrivateConfigValidateHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	b, _ := io.ReadAll(req.Body)
+	if err := Config.Validate(b); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	current, err := Hooks.Get.ConfigStore().Load()
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	SendSuccessResult(res, DiffConfig(DecryptConfig(current), b))
+}
+
+func PrivateConfigHistoryHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	versions, err := model.ConfigHistoryList()
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	SendSuccessResults(res, versions)
+}
+
+// PrivateConfigHistoryDiffHandler shows the changes made by a version or, with against=current,
+// the changes that would happen if the version was restored
+func PrivateConfigHistoryDiffHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	version, err := configVersion(req)
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	if req.URL.Query().Get("against") == "current" {
+		current, err := Hooks.Get.ConfigStore().Load()
+		if err != nil {
+			SendErrorResult(res, err)
+			return
+		}
+		version.Changes = DiffConfig(DecryptConfig(current), DecryptConfig(version.Config))
+	}
+	SendSuccessResult(res, version)
+}
+
+func PrivateConfigHistoryRestoreHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	version, err := configVersion(req)
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	current, err := Hooks.Get.ConfigStore().Load()
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	// the keys in use stay as they are, going back to an older one would make what was encrypted
+	// since then unreadable
+	b := DecryptConfig(version.Config)
+	for _, key := range []string{"general.secret_key", "general.secret_keyring"} {
+		if v := gjson.GetBytes(current, key); v.Exists() {
+			b, err = sjson.SetBytes(b, key, v.String())
+		} else {
+			b, err = sjson.DeleteBytes(b, key)
+		}
+		if err != nil {
+			SendErrorResult(res, err)
+			return
+		}
+	}
+	if err = Config.Validate(b); err != nil {
+		SendErrorResult(res, err)
+		return
+	} else if err = SaveConfigAs(b, adminAuthor(req)); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	Log.Info("config::history action=restore version=%d", version.Id)
+	Config.Load()
+	SendSuccessResult(res, nil)
+}
+
 func PublicConfigHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
 	cfg := Config.Export()
 	SendSuccessResultWithEtagAndGzip(res, req, cfg)
 }
+
+func configVersion(req *http.Request) (model.ConfigVersion, error) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		return model.ConfigVersion{}, ErrNotValid
+	}
+	return model.ConfigHistoryGet(id)
+}
+
+// adminAuthor identifies the admin session making a change without revealing its token
+func adminAuthor(req *http.Request) string {
+	token := req.Header.Get("Authorization")
+	if c, err := req.Cookie(COOKIE_NAME_ADMIN); err == nil && token == "" {
+		token = c.Value
+	}
+	return "admin:" + Hash(token, 8) + "@" + ip(req)
+}
@@ -0,0 +1,62 @@
+package ctrl
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+func FileDu(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if model.CanRead(ctx) == false {
+		Log.Debug("du::permission 'permission denied'")
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	p := req.URL.Query().Get("path")
+	if p == "" {
+		p = "/"
+	}
+	path, err := PathBuilder(ctx, EnforceDirectory(p))
+	if err != nil {
+		Log.Debug("du::path '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	opts := model.DiskUsageOptions{}
+	opts.Top, _ = strconv.Atoi(req.URL.Query().Get("top"))
+	if t, err := strconv.Atoi(req.URL.Query().Get("timeout")); err == nil {
+		opts.Timeout = time.Duration(t) * time.Second
+	}
+
+	// on huge trees, the client can get what's known so far as newline delimited json while the
+	// walk is still going, the last line being the final result
+	stream := req.URL.Query().Get("stream") == "true"
+	started := false
+	encoder := json.NewEncoder(res)
+	encoder.SetEscapeHTML(false)
+	if f, ok := res.(http.Flusher); ok && stream {
+		opts.Progress = func(du model.DiskUsage) {
+			if started == false {
+				res.Header().Set("Content-Type", "application/x-ndjson")
+				started = true
+			}
+			encoder.Encode(APISuccessResult{Status: "ok", Result: du})
+			f.Flush()
+		}
+	}
+	du, err := model.DiskUsageOf(ctx, path, opts)
+	if err != nil {
+		Log.Debug("du::walk '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	if started {
+		encoder.Encode(APISuccessResult{Status: "ok", Result: du})
+		return
+	}
+	SendSuccessResult(res, du)
+}
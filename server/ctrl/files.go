@@ -2,16 +2,20 @@ package ctrl
 
 import (
 	"archive/zip"
+	"bytes"
 	"context"
 	"crypto/sha1"
 	"hash/crc32"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"hash/fnv"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -25,13 +29,17 @@ import (
 )
 
 type FileInfo struct {
-	Name    string `json:"name"`
-	Type    string `json:"type"`
-	Size    int64  `json:"size"`
-	Time    int64  `json:"time"`
-	Offline bool   `json:"offline,omitempty"`
+	Name    string     `json:"name"`
+	Type    string     `json:"type"`
+	Size    int64      `json:"size"`
+	Time    int64      `json:"time"`
+	Offline bool       `json:"offline,omitempty"`
+	Posix   *FilePosix `json:"posix,omitempty"`
 }
 
+// MAX_RANGES is the number of ranges above which a range request is served as a regular one
+const MAX_RANGES = 32
+
 var (
 	file_cache  AppCache
 	zip_timeout func() int
@@ -175,8 +183,9 @@ func FileLs(ctx *App, res http.ResponseWriter, req *http.Request) {
 				return "directory"
 			}(entries[i].Mode()),
 		}
-		if f, ok := entries[i].Sys().(File); ok && f.Offline == true {
-			files[i].Offline = true
+		if f, ok := entries[i].Sys().(File); ok {
+			files[i].Offline = f.Offline
+			files[i].Posix = f.Posix
 		}
 	}
 
@@ -244,6 +253,19 @@ func FileCat(ctx *App, res http.ResponseWriter, req *http.Request) {
 
 	// perform the actual `cat` if needed
 	mType := GetMimeType(query.Get("path"))
+	if mType == "application/javascript" {
+		mType = "text/plain"
+	}
+	if file == nil && req.Header.Get("range") != "" && query.Get("thumbnail") != "true" {
+		// backends that can read part of a file only fetch what's requested
+		if obj, ok := ctx.Backend.(IBackendRange); ok {
+			if finfo, err := ctx.Backend.Stat(path); err == nil && finfo.IsDir() == false && finfo.Size() >= 0 {
+				file = NewRangeReader(obj, path, finfo.Size())
+				contentLength = finfo.Size()
+				header.Set("Content-Type", mType)
+			}
+		}
+	}
 	if file == nil {
 		if file, err = ctx.Backend.Cat(path); err != nil {
 			if req.Method == http.MethodHead {
@@ -260,9 +282,6 @@ func FileCat(ctx *App, res http.ResponseWriter, req *http.Request) {
 			SendErrorResult(res, err)
 			return
 		}
-		if mType == "application/javascript" {
-			mType = "text/plain"
-		}
 		header.Set("Content-Type", mType)
 		if req.Header.Get("range") != "" {
 			needToCreateCache = true
@@ -307,6 +326,11 @@ func FileCat(ctx *App, res http.ResponseWriter, req *http.Request) {
 			fileMutation = true
 		}
 	}
+	if _, ok := file.(*RangeReader); ok == false && fileMutation && contentLength >= 0 {
+		// what we know about the original file doesn't apply to the transformed content
+		contentLength = -1
+		needToCreateCache = thumb != "true"
+	}
 
 	// The extra complexity is to support: https://en.wikipedia.org/wiki/Progressive_download
 	// => range request requires a seeker to work, some backend support it, some don't. 2 strategies:
@@ -359,27 +383,7 @@ func FileCat(ctx *App, res http.ResponseWriter, req *http.Request) {
 	// Range request: find how much data we need to send
 	var ranges [][]int64
 	if req.Header.Get("range") != "" {
-		ranges = make([][]int64, 0)
-		for _, r := range strings.Split(strings.TrimPrefix(req.Header.Get("range"), "bytes="), ",") {
-			r = strings.TrimSpace(r)
-			if r == "" {
-				continue
-			}
-			var start int64 = -1
-			var end int64 = -1
-			sides := strings.Split(r, "-")
-			if len(sides) == 2 {
-				if start, err = strconv.ParseInt(sides[0], 10, 64); err != nil || start < 0 {
-					start = 0
-				}
-				if end, err = strconv.ParseInt(sides[1], 10, 64); err != nil || end < start {
-					end = contentLength - 1
-				}
-			}
-			if start != -1 && end != -1 && end-start >= 0 {
-				ranges = append(ranges, []int64{start, end})
-			}
-		}
+		ranges = parseRange(req.Header.Get("range"), contentLength)
 	} else if fileMutation == false && contentLength < 0 {
 		if finfo, err := ctx.Backend.Stat(path); err == nil {
 			if finfo.ModTime().Unix() > 0 {
@@ -423,15 +427,47 @@ func FileCat(ctx *App, res http.ResponseWriter, req *http.Request) {
 			}
 		}
 		buf := make([]byte, size*1024)
-		if f, ok := file.(io.ReadSeeker); ok && len(ranges) > 0 {
-			if _, err = f.Seek(ranges[0][0], io.SeekStart); err == nil {
+		if f, ok := file.(io.ReadSeeker); ok && len(ranges) == 1 {
+			r, err := readRange(f, ranges[0][0], ranges[0][1])
+			if err != nil {
+				Log.Debug("cat::range '%s'", err.Error())
+				header.Set("Content-Range", fmt.Sprintf("bytes */%d", contentLength))
+				res.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			} else {
 				header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", ranges[0][0], ranges[0][1], contentLength))
 				header.Set("Content-Length", fmt.Sprintf("%d", ranges[0][1]-ranges[0][0]+1))
 				res.WriteHeader(http.StatusPartialContent)
-				io.CopyBuffer(res, io.LimitReader(f, ranges[0][1]-ranges[0][0]+1), buf)
-			} else {
-				res.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				io.CopyBuffer(res, r, buf)
+				r.Close()
 			}
+		} else if ok && len(ranges) > 1 {
+			mw := multipart.NewWriter(res)
+			header.Del("Content-Length")
+			header.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+			res.WriteHeader(http.StatusPartialContent)
+			for _, rng := range ranges {
+				r, err := readRange(f, rng[0], rng[1])
+				if err != nil {
+					Log.Debug("cat::multirange '%s'", err.Error())
+					break
+				}
+				part, err := mw.CreatePart(textproto.MIMEHeader{
+					"Content-Type":  {mType},
+					"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", rng[0], rng[1], contentLength)},
+				})
+				if err == nil {
+					_, err = io.CopyBuffer(part, r, buf)
+				}
+				r.Close()
+				if err != nil {
+					break
+				}
+			}
+			mw.Close()
+		} else if ok && ranges != nil && len(ranges) == 0 {
+			header.Del("Content-Length")
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", contentLength))
+			res.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
 		} else {
 			io.CopyBuffer(res, file, buf)
 		}
@@ -439,6 +475,60 @@ func FileCat(ctx *App, res http.ResponseWriter, req *http.Request) {
 	file.Close()
 }
 
+// parseRange gives the list of ranges to send for a Range header, nil when the header should be
+// ignored and an empty list when none of the ranges can be satisfied
+func parseRange(rangeHeader string, size int64) [][]int64 {
+	if size < 0 || strings.HasPrefix(rangeHeader, "bytes=") == false {
+		return nil
+	}
+	ranges := make([][]int64, 0)
+	for _, r := range strings.Split(strings.TrimPrefix(rangeHeader, "bytes="), ",") {
+		r = strings.TrimSpace(r)
+		sides := strings.Split(r, "-")
+		if r == "" || len(sides) != 2 {
+			continue
+		}
+		var start, end int64
+		var err error
+		if sides[0] == "" { // suffix range, eg: the last 500 bytes with "-500"
+			n, err := strconv.ParseInt(sides[1], 10, 64)
+			if err != nil || n <= 0 {
+				continue
+			} else if n > size {
+				n = size
+			}
+			start, end = size-n, size-1
+		} else {
+			if start, err = strconv.ParseInt(sides[0], 10, 64); err != nil || start < 0 {
+				continue
+			}
+			if end, err = strconv.ParseInt(sides[1], 10, 64); err != nil || end >= size {
+				end = size - 1
+			}
+		}
+		if start >= size || end < start {
+			continue
+		}
+		ranges = append(ranges, []int64{start, end})
+	}
+	if len(ranges) > MAX_RANGES {
+		return nil
+	}
+	return ranges
+}
+
+func readRange(f io.ReadSeeker, start int64, end int64) (io.ReadCloser, error) {
+	if obj, ok := f.(interface {
+		Range(start int64, end int64) (io.ReadCloser, error)
+	}); ok {
+		return obj.Range(start, end)
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return NewReadCloserFromReader(io.LimitReader(f, end-start+1)), nil
+}
+
 func FileAccess(ctx *App, res http.ResponseWriter, req *http.Request) {
 	path, err := PathBuilder(ctx, req.URL.Query().Get("path"))
 	if err != nil {
@@ -527,13 +617,44 @@ func FileSave(ctx *App, res http.ResponseWriter, req *http.Request) {
 		proto = "tus"
 	}
 	if proto == "" && req.Method == http.MethodPost {
-		err = ctx.Backend.Save(path, req.Body)
-		req.Body.Close()
+		allowance, err := quotaCheck(ctx, path, req.ContentLength)
 		if err != nil {
+			Log.Debug("files::save action=quota err=%s", err.Error())
+			req.Body.Close()
+			SendErrorResult(res, err)
+			return
+		}
+		algo, expectedDigest, err := ParseDigestHeader(req)
+		if err != nil {
+			Log.Debug("files::save action=digest err=%s", err.Error())
+			req.Body.Close()
+			SendErrorResult(res, err)
+			return
+		}
+		body := newQuotaReader(req.Body, allowance)
+		var digest hash.Hash
+		if algo != "" {
+			digest, _ = NewChecksumHash(algo)
+			body.reader = io.TeeReader(req.Body, digest)
+		}
+		// what landed on the storage has to be what the client sent, the digest is checked as the
+		// upload comes in so a corrupted one fails before the backend commits it
+		err = saveUpload(ctx, ctx.Backend, path, body, digestVerifier(digest, expectedDigest))
+		req.Body.Close()
+		if body.n > allowance && allowance >= 0 {
+			Log.Debug("files::save action=quota err=exceeded_while_uploading")
+			SendErrorResult(res, ErrQuotaExceeded)
+			return
+		} else if errors.Is(err, ErrChecksumMismatch) {
+			Log.Debug("files::save action=digest err=mismatch algo=%s", algo)
+			SendErrorResult(res, err)
+			return
+		} else if err != nil {
 			Log.Debug("files::save action=backend_save err=%s", err.Error())
 			SendErrorResult(res, NewError(err.Error(), 403))
 			return
 		}
+		quotaOnSave(ctx, path, body.n)
 		SendSuccessResult(res, nil)
 		return
 	}
@@ -574,6 +695,18 @@ func FileSave(ctx *App, res http.ResponseWriter, req *http.Request) {
 			SendErrorResult(res, ErrNotValid)
 			return
 		}
+		if _, err = quotaCheck(ctx, path, int64(size)); err != nil {
+			Log.Debug("files::save::tus action=quota err=%s", err.Error())
+			SendErrorResult(res, err)
+			return
+		}
+		// the digest given on creation is the one of the whole file
+		algo, expectedDigest, err := ParseDigestHeader(req)
+		if err != nil {
+			Log.Debug("files::save::tus action=digest err=%s", err.Error())
+			SendErrorResult(res, err)
+			return
+		}
 		ctx.Context = context.Background()
 		b, err := ctx.Backend.Init(ctx.Session, ctx)
 		if err != nil {
@@ -581,7 +714,16 @@ func FileSave(ctx *App, res http.ResponseWriter, req *http.Request) {
 			SendErrorResult(res, ErrNotValid)
 			return
 		}
-		uploader := createChunkedUploader(b.Save, path, size)
+		var digest hash.Hash
+		if algo != "" {
+			digest, _ = NewChecksumHash(algo)
+		}
+		uploader := createChunkedUploader(func(path string, file io.Reader) error {
+			if digest != nil {
+				file = io.TeeReader(file, digest)
+			}
+			return saveUpload(ctx, b, path, file, digestVerifier(digest, expectedDigest))
+		}, path, size)
 		chunkedUploadCache.Set(cacheKey, uploader)
 		h.Set("Tus-Resumable", "1.0.0")
 		h.Set("Content-Length", "0")
@@ -654,11 +796,17 @@ func FileSave(ctx *App, res http.ResponseWriter, req *http.Request) {
 			return
 		} else if newOffset == totalSize {
 			if err := uploader.Close(); err != nil {
+				chunkedUploadCache.Del(cacheKey)
 				Log.Debug("files::save::tus action=uploader.close err=%s", err.Error())
+				if errors.Is(err, ErrChecksumMismatch) {
+					SendErrorResult(res, err)
+					return
+				}
 				SendErrorResult(res, ErrNotValid)
 				return
 			}
 			chunkedUploadCache.Del(cacheKey)
+			quotaOnSave(ctx, path, int64(totalSize))
 		}
 		h.Set("Tus-Resumable", "1.0.0")
 		h.Set("Upload-Offset", fmt.Sprintf("%d", newOffset))
@@ -668,6 +816,89 @@ func FileSave(ctx *App, res http.ResponseWriter, req *http.Request) {
 	SendErrorResult(res, ErrNotImplemented)
 }
 
+// saveUpload writes an upload straight where it belongs, a new file being removed when the upload
+// doesn't go through. verify runs when the body has been read fully so the backend sees its error
+// before committing anything. Backends able to replace a file in one go write it aside first so the
+// file being overwritten is only replaced once the upload is complete
+func saveUpload(ctx *App, backend IBackend, path string, body io.Reader, verify func() error) error {
+	exists := true
+	if _, err := backend.Stat(path); err != nil && isNotFound(err) {
+		exists = false
+	}
+	if verify != nil {
+		body = &verifyReader{Reader: body, verify: verify}
+	}
+	finish := func(p string, cleanup bool) error {
+		err := backend.Save(p, body)
+		if err == nil && verify != nil {
+			err = verify()
+		}
+		if err != nil && cleanup {
+			if e := backend.Rm(p); e != nil {
+				Log.Debug("files::save action=cleanup path=%s err=%s", p, e.Error())
+			}
+		}
+		return err
+	}
+	obj, ok := backend.(IBackendReplace)
+	if exists == false || ok == false {
+		return finish(path, exists == false)
+	}
+	i := strings.LastIndex(path, "/") + 1
+	tmp := path[:i] + "." + path[i:] + ".upload-" + QuickString(8)
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err := auth.Save(ctx, tmp); err != nil {
+			return finish(path, false)
+		}
+	}
+	if err := finish(tmp, true); err != nil {
+		return err
+	} else if err = obj.Replace(tmp, path); err != nil {
+		backend.Rm(tmp)
+		return err
+	}
+	return nil
+}
+
+// digestVerifier checks what was computed while uploading against what the client expects
+func digestVerifier(digest hash.Hash, expected []byte) func() error {
+	if digest == nil {
+		return nil
+	}
+	return func() error {
+		if bytes.Equal(digest.Sum(nil), expected) == false {
+			return ErrChecksumMismatch
+		}
+		return nil
+	}
+}
+
+// verifyReader runs verify once the whole upload went through and fails the read when it isn't
+// happy, the backend sees the error before it has a chance to commit anything
+type verifyReader struct {
+	io.Reader
+	verify func() error
+}
+
+func (this *verifyReader) Read(p []byte) (int, error) {
+	n, err := this.Reader.Read(p)
+	if err == io.EOF {
+		if e := this.verify(); e != nil {
+			return n, e
+		}
+	}
+	return n, err
+}
+
+func isNotFound(err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	} else if e, ok := err.(interface{ Status() int }); ok {
+		return e.Status() == http.StatusNotFound
+	}
+	return false
+}
+
 func createChunkedUploader(save func(path string, file io.Reader) error, path string, size uint64) *chunkedUpload {
 	r, w := io.Pipe()
 	done := make(chan error, 1)
@@ -771,6 +1002,7 @@ func FileMv(ctx *App, res http.ResponseWriter, req *http.Request) {
 		SendErrorResult(res, err)
 		return
 	}
+	quotaOnMv(ctx, from, to)
 	SendSuccessResult(res, nil)
 }
 
@@ -802,6 +1034,7 @@ func FileRm(ctx *App, res http.ResponseWriter, req *http.Request) {
 		SendErrorResult(res, err)
 		return
 	}
+	quotaOnRm(ctx, path)
 	SendSuccessResult(res, nil)
 }
 
@@ -1016,25 +1249,35 @@ func FileExtract(ctx *App, res http.ResponseWriter, req *http.Request) {
 			return ErrTimeout
 		}
 
-		zipFile, err := ctx.Backend.Cat(path)
-		if err != nil {
-			return err
-		}
-		defer zipFile.Close()
-		f, err := os.CreateTemp("", "tmpzip.*.zip")
-		if err != nil {
-			Log.Debug("extract::create_temp '%s'", err.Error())
-			return nil
-		}
-		defer os.Remove(f.Name())
-		io.Copy(f, zipFile)
-		s, err := f.Stat()
-		if err != nil {
-			return err
+		var r *zip.Reader
+		if obj, ok := ctx.Backend.(IBackendRange); ok {
+			// the archive is browsed with range reads instead of being downloaded
+			if s, err := ctx.Backend.Stat(path); err == nil {
+				zipFile := NewRangeReader(obj, path, s.Size())
+				defer zipFile.Close()
+				r, _ = zip.NewReader(zipFile, s.Size())
+			}
 		}
-		r, err := zip.NewReader(f, s.Size())
-		if err != nil {
-			return err
+		if r == nil {
+			zipFile, err := ctx.Backend.Cat(path)
+			if err != nil {
+				return err
+			}
+			defer zipFile.Close()
+			f, err := os.CreateTemp("", "tmpzip.*.zip")
+			if err != nil {
+				Log.Debug("extract::create_temp '%s'", err.Error())
+				return nil
+			}
+			defer os.Remove(f.Name())
+			io.Copy(f, zipFile)
+			s, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			if r, err = zip.NewReader(f, s.Size()); err != nil {
+				return err
+			}
 		}
 		isFolderAlreadyCreated := map[string]bool{
 			fmt.Sprintf("%s/", filepath.Dir(path)): true,
@@ -1071,15 +1314,25 @@ func FileExtract(ctx *App, res http.ResponseWriter, req *http.Request) {
 					Log.Debug("extract::chroot %s", err.Error())
 					return err
 				}
+				allowance, err := quotaCheck(ctx, p, int64(f.UncompressedSize64))
+				if err != nil {
+					Log.Debug("extract::quota %s", err.Error())
+					return err
+				}
 				rc, err := f.Open()
 				if err != nil {
 					Log.Debug("extract::fopen %s", err.Error())
 					return err
 				}
-				err = ctx.Backend.Save(p, rc)
+				body := newQuotaReader(rc, allowance)
+				err = saveUpload(ctx, ctx.Backend, p, body, nil)
 				rc.Close()
-				if err != nil {
+				if body.n > allowance && allowance >= 0 {
+					return ErrQuotaExceeded
+				} else if err != nil {
 					Log.Debug("extract::save err %s", err.Error())
+				} else {
+					quotaOnSave(ctx, p, body.n)
 				}
 			}
 		}
@@ -0,0 +1,164 @@
+package ctrl
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+/*
+ * The health of the configured backends comes from 2 places: probes an admin runs on demand against
+ * each connection using test credentials, and the outcome of what users are doing, counted per
+ * backend type over the last few minutes. A request is counted as an error when it fails on the
+ * server side, a 404 or a permission denied being the user's problem rather than the backend's.
+ */
+const (
+	HEALTH_WINDOW       = 15 // in minutes
+	HEALTH_STEP_TIMEOUT = 10 * time.Second
+)
+
+type BackendHealth struct {
+	Connections []model.HealthReport    `json:"connections"`
+	Backends    map[string]BackendStats `json:"backends"`
+}
+
+type BackendStats struct {
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Latency   int64   `json:"latency_ms"`
+	Window    int     `json:"window_minutes"`
+}
+
+type healthBucket struct {
+	minute   int64
+	requests int
+	errors   int
+	latency  int64
+}
+
+var health = struct {
+	sync.Mutex
+	stats   map[string]*[HEALTH_WINDOW]healthBucket
+	reports map[string]model.HealthReport
+}{
+	stats:   map[string]*[HEALTH_WINDOW]healthBucket{},
+	reports: map[string]model.HealthReport{},
+}
+
+// AdminBackendHealthHandler gives the result of the last probe of each connection alongside the
+// error rates of what users have been doing
+func AdminBackendHealthHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	SendSuccessResult(res, healthSummary())
+}
+
+// AdminBackendHealthProbeHandler probes the connections, all of them or the one given with
+// ?label=xxx. The body gives the test credentials for each connection:
+// { "my sftp": { "username": "test", "password": "xxx", "path": "/tmp/" } }
+// those are added to what's in the config for that connection, the path being where the scratch
+// folder gets created
+func AdminBackendHealthProbeHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	credentials := map[string]map[string]string{}
+	if b, _ := io.ReadAll(req.Body); len(b) > 0 {
+		if err := json.Unmarshal(b, &credentials); err != nil {
+			SendErrorResult(res, ErrNotValid)
+			return
+		}
+	}
+	only := req.URL.Query().Get("label")
+	var wg sync.WaitGroup
+	found := false
+	for i := range Config.Conn {
+		params := model.MapStringInterfaceToMapStringString(Config.Conn[i])
+		label := healthLabel(params)
+		if only != "" && only != label {
+			continue
+		}
+		found = true
+		for key, value := range credentials[label] {
+			params[key] = value
+		}
+		wg.Add(1)
+		go func(label string, params map[string]string) {
+			defer wg.Done()
+			report := model.HealthProbe(&App{Context: ctx.Context}, label, params, HEALTH_STEP_TIMEOUT)
+			Log.Debug("ctrl::health label=%s type=%s status=%s latency=%dms", label, report.Type, report.Status, report.Latency)
+			health.Lock()
+			health.reports[label] = report
+			health.Unlock()
+		}(label, params)
+	}
+	if found == false {
+		SendErrorResult(res, ErrNotFound)
+		return
+	}
+	wg.Wait()
+	SendSuccessResult(res, healthSummary())
+}
+
+func healthLabel(conn map[string]string) string {
+	if conn["label"] != "" {
+		return conn["label"]
+	}
+	return conn["type"]
+}
+
+// HealthRecord counts a request a user made on a type of backend, it's fed by plg_metrics_health
+func HealthRecord(backendType string, status int, duration time.Duration) {
+	minute := time.Now().Unix() / 60
+	health.Lock()
+	defer health.Unlock()
+	buckets, ok := health.stats[backendType]
+	if ok == false {
+		buckets = &[HEALTH_WINDOW]healthBucket{}
+		health.stats[backendType] = buckets
+	}
+	b := &buckets[minute%HEALTH_WINDOW]
+	if b.minute != minute {
+		*b = healthBucket{minute: minute}
+	}
+	b.requests += 1
+	b.latency += duration.Milliseconds()
+	if status >= 500 && status != http.StatusNotImplemented {
+		b.errors += 1
+	}
+}
+
+func healthSummary() BackendHealth {
+	minute := time.Now().Unix() / 60
+	out := BackendHealth{
+		Connections: []model.HealthReport{},
+		Backends:    map[string]BackendStats{},
+	}
+	health.Lock()
+	defer health.Unlock()
+	for backendType, buckets := range health.stats {
+		s := BackendStats{Window: HEALTH_WINDOW}
+		for _, b := range buckets {
+			if minute-b.minute >= HEALTH_WINDOW {
+				continue
+			}
+			s.Requests += b.requests
+			s.Errors += b.errors
+			s.Latency += b.latency
+		}
+		if s.Requests == 0 {
+			continue
+		}
+		s.ErrorRate = float64(s.Errors) / float64(s.Requests)
+		s.Latency = s.Latency / int64(s.Requests)
+		out.Backends[backendType] = s
+	}
+	for i := range Config.Conn {
+		label := healthLabel(model.MapStringInterfaceToMapStringString(Config.Conn[i]))
+		if report, ok := health.reports[label]; ok {
+			out.Connections = append(out.Connections, report)
+		}
+	}
+	return out
+}
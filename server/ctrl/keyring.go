@@ -0,0 +1,98 @@
+package ctrl
+
+import (
+	"net/http"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+func AdminKeyringHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	SendSuccessResults(res, KeyringList())
+}
+
+// AdminKeyringRotateHandler makes a new secret key the active one. The previous key remains in
+// the keyring so existing sessions and shared links keep working until it's retired
+func AdminKeyringRotateHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if Config.Get("general.secret_key").IsOverridden() {
+		SendErrorResult(res, NewError("The secret key is managed from the environment", http.StatusBadRequest))
+		return
+	}
+	b, err := LoadConfig()
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	previousKey := gjson.GetBytes(b, "general.secret_key").String()
+	previousKeyring := gjson.GetBytes(b, "general.secret_keyring").String()
+	key := RandomString(16)
+
+	// the encrypted parts of the config are saved with the new key
+	encryptedKeyring, err := KeyringRotate(key)
+	if err == nil {
+		b, _ = sjson.SetBytes(b, "general.secret_key", key)
+		b, _ = sjson.SetBytes(b, "general.secret_keyring", encryptedKeyring)
+		err = SaveConfigAs(b, adminAuthor(req))
+	}
+	if err != nil {
+		InitSecretDerivate(previousKey)
+		InitSecretKeyring(previousKeyring)
+		SendErrorResult(res, err)
+		return
+	}
+	Config.Load()
+	n, err := keyringReencrypt()
+	if err != nil {
+		Log.Warning("ctrl::keyring::rotate action=reencrypt err=%s", err.Error())
+	}
+	Log.Info("ctrl::keyring::rotate shares=%d", n)
+	SendSuccessResult(res, map[string]any{
+		"keys":   KeyringList(),
+		"shares": n,
+	})
+}
+
+// AdminKeyringRetireHandler removes a rotated key from the keyring, or all of them when no id is
+// given. Whatever was encrypted with a retired key and not migrated can't be decrypted anymore
+func AdminKeyringRetireHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	encryptedKeyring, err := KeyringRetire(req.URL.Query().Get("id"))
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	if _, err = keyringReencrypt(); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	b, err := LoadConfig()
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	b, _ = sjson.SetBytes(b, "general.secret_keyring", encryptedKeyring)
+	if err = SaveConfigAs(b, adminAuthor(req)); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	InitSecretKeyring(encryptedKeyring)
+	Config.Load()
+	SendSuccessResults(res, KeyringList())
+}
+
+// keyringReencrypt encrypts with the active key the shares and what the plugins keep, it gives
+// the number of shares that were migrated
+func keyringReencrypt() (int, error) {
+	n, err := model.ShareReencrypt()
+	if err != nil {
+		return n, err
+	}
+	for _, fn := range Hooks.Get.OnKeyringReencrypt() {
+		if err = fn(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
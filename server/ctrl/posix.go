@@ -0,0 +1,179 @@
+package ctrl
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+type FileLinkResult struct {
+	Target string `json:"target"`
+}
+
+// FileChmod changes the permissions and/or the owner of a file: ?path=/foo&mode=0640&uid=1000&gid=100
+func FileChmod(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if model.CanEdit(ctx) == false {
+		Log.Debug("chmod::permission 'permission denied'")
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	backend, ok := ctx.Backend.(IBackendPosix)
+	if ok == false {
+		SendErrorResult(res, ErrNotImplemented)
+		return
+	}
+	path, err := PathBuilder(ctx, req.URL.Query().Get("path"))
+	if err != nil {
+		Log.Debug("chmod::path '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	query := req.URL.Query()
+	var uid, gid int
+	if query.Get("mode") == "" && query.Get("uid") == "" && query.Get("gid") == "" {
+		SendErrorResult(res, ErrNotValid)
+		return
+	} else if uid, err = parseOwner(query.Get("uid")); err != nil {
+		SendErrorResult(res, err)
+		return
+	} else if gid, err = parseOwner(query.Get("gid")); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err = auth.Chmod(ctx, path); err != nil {
+			Log.Info("chmod::auth '%s'", err.Error())
+			SendErrorResult(res, ErrNotAuthorized)
+			return
+		}
+	}
+	if query.Get("mode") != "" {
+		mode, err := ParseUnixMode(query.Get("mode"))
+		if err != nil {
+			SendErrorResult(res, err)
+			return
+		} else if err = backend.Chmod(path, mode); err != nil {
+			Log.Debug("chmod::backend action=chmod err=%s", err.Error())
+			SendErrorResult(res, err)
+			return
+		}
+	}
+	if uid >= 0 || gid >= 0 {
+		if err = backend.Chown(path, uid, gid); err != nil {
+			Log.Debug("chmod::backend action=chown err=%s", err.Error())
+			SendErrorResult(res, err)
+			return
+		}
+	}
+	SendSuccessResult(res, nil)
+}
+
+// FileReadlink gives where a symlink points to
+func FileReadlink(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if model.CanRead(ctx) == false {
+		Log.Debug("readlink::permission 'permission denied'")
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	backend, ok := ctx.Backend.(IBackendPosix)
+	if ok == false {
+		SendErrorResult(res, ErrNotImplemented)
+		return
+	}
+	path, err := PathBuilder(ctx, req.URL.Query().Get("path"))
+	if err != nil {
+		Log.Debug("readlink::path '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err = auth.Stat(ctx, path); err != nil {
+			Log.Info("readlink::auth '%s'", err.Error())
+			SendErrorResult(res, ErrNotAuthorized)
+			return
+		}
+	}
+	target, err := backend.Readlink(path)
+	if err != nil {
+		Log.Debug("readlink::backend '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	SendSuccessResult(res, FileLinkResult{Target: target})
+}
+
+// FileSymlink creates a symlink: ?path=/foo/link&target=../bar. The target has to be somewhere the
+// user can go to so a link can't be used to get out of the folder a user is given access to
+func FileSymlink(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if model.CanEdit(ctx) == false || model.CanUpload(ctx) == false {
+		Log.Debug("symlink::permission 'permission denied'")
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	backend, ok := ctx.Backend.(IBackendPosix)
+	if ok == false {
+		SendErrorResult(res, ErrNotImplemented)
+		return
+	}
+	path, err := PathBuilder(ctx, req.URL.Query().Get("path"))
+	if err != nil {
+		Log.Debug("symlink::path '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	target := req.URL.Query().Get("target")
+	if target == "" {
+		SendErrorResult(res, ErrNotValid)
+		return
+	}
+	// the check below is lexical while the OS follows the links already in place, a ".." coming
+	// after one of them would lead wherever it points to, outside of the chroot included
+	for _, part := range strings.Split(target, "/") {
+		if part == ".." {
+			Log.Debug("symlink::target path=%s target=%s", path, target)
+			SendErrorResult(res, ErrNotValid)
+			return
+		}
+	}
+	resolved := target
+	if filepath.IsAbs(target) == false {
+		resolved = filepath.Join(filepath.Dir(strings.TrimSuffix(path, "/")), target)
+	}
+	if strings.HasPrefix(EnforceDirectory(filepath.Clean(resolved)), EnforceDirectory(ctx.Session["path"])) == false {
+		Log.Debug("symlink::target path=%s target=%s", path, target)
+		SendErrorResult(res, ErrFilesystemError)
+		return
+	}
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err = auth.Touch(ctx, path); err != nil {
+			Log.Info("symlink::auth '%s'", err.Error())
+			SendErrorResult(res, ErrNotAuthorized)
+			return
+		} else if err = auth.Chmod(ctx, path); err != nil {
+			Log.Info("symlink::auth '%s'", err.Error())
+			SendErrorResult(res, ErrNotAuthorized)
+			return
+		}
+	}
+	if err = backend.Symlink(target, path); err != nil {
+		Log.Debug("symlink::backend '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	SendSuccessResult(res, nil)
+}
+
+func parseOwner(s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 0 {
+		return -1, ErrNotValid
+	}
+	return id, nil
+}
@@ -0,0 +1,135 @@
+package ctrl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+func FileUsage(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if model.CanRead(ctx) == false {
+		Log.Debug("usage::permission 'permission denied'")
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	p := req.URL.Query().Get("path")
+	if p == "" {
+		p = "/"
+	}
+	path, err := PathBuilder(ctx, p)
+	if err != nil {
+		Log.Debug("usage::path '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err = auth.Stat(ctx, path); err != nil {
+			Log.Info("usage::auth '%s'", err.Error())
+			SendErrorResult(res, ErrNotAuthorized)
+			return
+		}
+	}
+
+	usage := QuotaUsage{Used: -1, Limit: -1, Available: -1}
+	hasQuota := false
+	if q := Hooks.Get.Quota(); q != nil {
+		if u, err := q.Usage(ctx, path); err == nil {
+			usage, hasQuota = u, true
+		} else if err != ErrNotImplemented {
+			Log.Debug("usage::quota '%s'", err.Error())
+			SendErrorResult(res, err)
+			return
+		}
+	}
+	if obj, ok := ctx.Backend.(IBackendCapacity); ok {
+		if c, err := obj.Capacity(path); err == nil {
+			usage.Backend = &c
+			if c.Available >= 0 && (usage.Available < 0 || c.Available < usage.Available) {
+				usage.Available = c.Available
+			}
+		} else {
+			Log.Debug("usage::capacity '%s'", err.Error())
+		}
+	} else if hasQuota == false {
+		SendErrorResult(res, ErrNotImplemented)
+		return
+	}
+	SendSuccessResult(res, usage)
+}
+
+// quotaCheck gives how many bytes can be written at path, -1 meaning there's no limit. A size
+// of -1 is for when we don't know yet how much is going to be written
+func quotaCheck(ctx *App, path string, size int64) (int64, error) {
+	q := Hooks.Get.Quota()
+	if q == nil {
+		return -1, nil
+	}
+	allowance, err := q.Allowance(ctx, path)
+	if err != nil {
+		return 0, err
+	} else if allowance < 0 {
+		return -1, nil
+	} else if size > allowance || allowance == 0 {
+		return allowance, NewError(fmt.Sprintf("%s: %s left", ErrQuotaExceeded.Error(), humanSize(allowance)), ErrQuotaExceeded.Status())
+	}
+	return allowance, nil
+}
+
+func quotaOnSave(ctx *App, path string, size int64) {
+	if q := Hooks.Get.Quota(); q != nil {
+		q.OnSave(ctx, path, size)
+	}
+}
+
+func quotaOnRm(ctx *App, path string) {
+	if q := Hooks.Get.Quota(); q != nil {
+		q.OnRm(ctx, path)
+	}
+}
+
+func quotaOnMv(ctx *App, from string, to string) {
+	if q := Hooks.Get.Quota(); q != nil {
+		q.OnMv(ctx, from, to)
+	}
+}
+
+// quotaReader counts what's being uploaded and stops as soon as it goes over the allowance, the
+// size given upfront by the client can't be trusted
+type quotaReader struct {
+	reader     io.Reader
+	allowance  int64
+	n          int64
+	onExceeded func()
+}
+
+func newQuotaReader(r io.Reader, allowance int64) *quotaReader {
+	return &quotaReader{reader: r, allowance: allowance}
+}
+
+func (this *quotaReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	this.n += int64(n)
+	if this.allowance >= 0 && this.n > this.allowance {
+		if this.onExceeded != nil {
+			this.onExceeded()
+		}
+		return n, ErrQuotaExceeded
+	}
+	return n, err
+}
+
+func humanSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
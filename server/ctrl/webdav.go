@@ -1,7 +1,10 @@
 package ctrl
 
 import (
+	"context"
+	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strings"
 
@@ -51,12 +54,54 @@ func WebdavHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	prefix := "/s/" + ctx.Share.Id
+	path := webdavPath(ctx, prefix, req.URL.Path)
+	var body *quotaReader
+	if req.Method == "PUT" {
+		allowance, err := quotaCheck(ctx, path, req.ContentLength)
+		if err != nil {
+			Log.Debug("webdav::quota '%s'", err.Error())
+			http.Error(res, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		// the size isn't always known upfront, what comes in is counted and the upload is
+		// aborted before reaching the storage once it goes over the allowance
+		c, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		body = newQuotaReader(req.Body, allowance)
+		body.onExceeded = cancel
+		req = req.WithContext(c)
+		req.Body = io.NopCloser(body)
+	}
+
 	h := &webdav.Handler{
-		Prefix:     "/s/" + ctx.Share.Id,
+		Prefix:     prefix,
 		FileSystem: model.NewWebdavFs(ctx.Backend, ctx.Share.Backend, ctx.Share.Path, req),
 		LockSystem: model.NewWebdavLock(),
 	}
 	h.ServeHTTP(res, req)
+
+	if r, ok := res.(interface{ Status() int }); ok && r.Status() < 300 {
+		switch req.Method {
+		case "PUT":
+			quotaOnSave(ctx, path, body.n)
+		case "DELETE":
+			quotaOnRm(ctx, path)
+		case "MOVE":
+			if u, err := url.Parse(req.Header.Get("Destination")); err == nil {
+				quotaOnMv(ctx, path, webdavPath(ctx, prefix, u.Path))
+			}
+		}
+	}
+}
+
+func webdavPath(ctx *App, prefix string, urlPath string) string {
+	name := strings.TrimPrefix(TrimBase(urlPath), prefix)
+	p := filepath.Join(ctx.Share.Path, name)
+	if strings.HasSuffix(name, "/") && strings.HasSuffix(p, "/") == false {
+		p += "/"
+	}
+	return p
 }
 
 /*
@@ -232,10 +232,12 @@ func _extractShare(req *http.Request) (Share, error) {
 		if len(usr) != 3 {
 			return "", p
 		}
-		if Hash(usr[1]+SECRET_KEY_DERIVATE_FOR_HASH, 10) != usr[2] {
-			return "", p
+		for _, secret := range SecretDerivates("HASH_") {
+			if Hash(usr[1]+secret, 10) == usr[2] {
+				return usr[1], p
+			}
 		}
-		return usr[1], p
+		return "", p
 	}(req.Header.Get("Authorization"))
 
 	if s.Users != nil && username != "" {
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+const CONFIG_HISTORY_SIZE = 500
+
+type ConfigVersion struct {
+	Id      int64          `json:"id"`
+	Time    time.Time      `json:"time"`
+	Author  string         `json:"author"`
+	Changes []ConfigChange `json:"changes"`
+	Config  []byte         `json:"-"`
+}
+
+func init() {
+	Hooks.Register.OnConfigSave(func(before []byte, after []byte, author string) {
+		if DB == nil { // saves happening before the app is fully loaded aren't tracked
+			return
+		}
+		// the encrypted parts of the config change on every save, the diff is made on clear values
+		changes := DiffConfig(DecryptConfig(before), DecryptConfig(after))
+		if err := ConfigHistoryAdd(author, after, changes); err != nil {
+			Log.Warning("model::config_history::add err=%s", err.Error())
+		}
+	})
+}
+
+func ConfigHistoryAdd(author string, config []byte, changes []ConfigChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	c, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	if _, err = DB.Exec(
+		"INSERT INTO ConfigHistory(created_at, author, config, changes) VALUES(?, ?, ?, ?)",
+		time.Now().UTC(), author, string(config), string(c),
+	); err != nil {
+		return err
+	}
+	_, err = DB.Exec(
+		"DELETE FROM ConfigHistory WHERE id <= (SELECT MAX(id) FROM ConfigHistory) - ?",
+		CONFIG_HISTORY_SIZE,
+	)
+	return err
+}
+
+func ConfigHistoryList() ([]ConfigVersion, error) {
+	rows, err := DB.Query("SELECT id, created_at, author, changes FROM ConfigHistory ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := []ConfigVersion{}
+	for rows.Next() {
+		var v ConfigVersion
+		var changes []byte
+		if err = rows.Scan(&v.Id, &v.Time, &v.Author, &changes); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(changes, &v.Changes)
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+func ConfigHistoryGet(id int64) (ConfigVersion, error) {
+	var v ConfigVersion
+	var changes []byte
+	var config string
+	err := DB.QueryRow(
+		"SELECT id, created_at, author, config, changes FROM ConfigHistory WHERE id = ?", id,
+	).Scan(&v.Id, &v.Time, &v.Author, &config, &changes)
+	if err == sql.ErrNoRows {
+		return v, ErrNotFound
+	} else if err != nil {
+		return v, err
+	}
+	v.Config = []byte(config)
+	json.Unmarshal(changes, &v.Changes)
+	return v, nil
+}
@@ -0,0 +1,316 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * DiskUsage walks through a subtree to tell what's eating up the space. Directories are listed
+ * concurrently within a time budget, when it runs out what we've seen so far is returned as an
+ * incomplete result. The summary of a subdirectory is cached against its modification time so
+ * running it again on the same tree only lists what has changed. Most filesystems only update the
+ * time of a directory when its direct content changes so a cached subtree is at most as old as
+ * the cache retention.
+ */
+const (
+	DU_CONCURRENCY       = 8
+	DU_TIME_BUDGET       = 30 * time.Second
+	DU_TOP               = 20
+	DU_MAX_TOP           = 500
+	DU_PROGRESS_INTERVAL = time.Second
+)
+
+var duCache AppCache
+
+func init() {
+	duCache = NewAppCache(5, 10)
+}
+
+type DiskUsage struct {
+	Size        int64            `json:"size"`
+	Files       int64            `json:"files"`
+	Directories int64            `json:"directories"`
+	Errors      int64            `json:"errors"`
+	Children    []DiskUsageEntry `json:"children"`
+	Types       []DiskUsageEntry `json:"types"`
+	Complete    bool             `json:"complete"`
+	Elapsed     int64            `json:"elapsed"`
+}
+
+type DiskUsageEntry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type,omitempty"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files"`
+}
+
+type DiskUsageOptions struct {
+	Top      int
+	Timeout  time.Duration
+	Progress func(DiskUsage) // receives what's known so far while the walk is still going
+}
+
+func DiskUsageOf(ctx *App, path string, opts DiskUsageOptions) (DiskUsage, error) {
+	if opts.Top <= 0 {
+		opts.Top = DU_TOP
+	} else if opts.Top > DU_MAX_TOP {
+		opts.Top = DU_MAX_TOP
+	}
+	if opts.Timeout <= 0 || opts.Timeout > DU_TIME_BUDGET {
+		opts.Timeout = DU_TIME_BUDGET
+	}
+	parent := ctx.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	deadline, cancel := context.WithTimeout(parent, opts.Timeout)
+	defer cancel()
+
+	path = EnforceDirectory(path)
+	du := &diskUsage{
+		app:      ctx,
+		ctx:      deadline,
+		tenantID: GenerateID(ctx.Session),
+		slots:    make(chan struct{}, DU_CONCURRENCY),
+		children: map[string]*DiskUsageEntry{},
+		types:    map[string]*DiskUsageEntry{},
+		start:    time.Now(),
+		top:      opts.Top,
+	}
+	files, err := du.ls(path)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	if opts.Progress != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(DU_PROGRESS_INTERVAL)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					opts.Progress(du.result(false))
+				}
+			}
+		}()
+	}
+	s := du.walkEntries(path, "", files)
+	close(done)
+	wg.Wait()
+	Log.Debug("model::du path=%s files=%d elapsed=%s complete=%t", path, s.files, time.Since(du.start), s.complete)
+	// a subtree that couldn't be listed leaves the result incomplete, not only running out of time
+	return du.result(s.complete && deadline.Err() == nil), nil
+}
+
+type diskUsage struct {
+	app      *App
+	ctx      context.Context
+	tenantID string
+	slots    chan struct{}
+	start    time.Time
+	top      int
+
+	mu          sync.Mutex
+	size        int64
+	files       int64
+	directories int64
+	errors      int64
+	children    map[string]*DiskUsageEntry
+	types       map[string]*DiskUsageEntry
+}
+
+// duSummary is what we know about a subtree, it is what's kept in cache
+type duSummary struct {
+	size     int64
+	files    int64
+	dirs     int64
+	types    map[string]*DiskUsageEntry
+	complete bool
+}
+
+func (this *duSummary) merge(s duSummary) {
+	this.size += s.size
+	this.files += s.files
+	this.dirs += s.dirs
+	this.complete = this.complete && s.complete
+	for ext, t := range s.types {
+		if this.types[ext] == nil {
+			this.types[ext] = &DiskUsageEntry{Name: ext}
+		}
+		this.types[ext].Size += t.Size
+		this.types[ext].Files += t.Files
+	}
+}
+
+// walk lists a directory and everything under it. What's found is accounted as we go so progress
+// can be reported, bucket is the child of the root the directory belongs to
+func (this *diskUsage) walk(path string, bucket string) duSummary {
+	s := duSummary{types: map[string]*DiskUsageEntry{}, complete: true}
+	if this.ctx.Err() != nil {
+		s.complete = false
+		return s
+	}
+	files, err := this.ls(path)
+	if err != nil {
+		this.fail(path, err)
+		s.complete = false
+		return s
+	}
+	return this.walkEntries(path, bucket, files)
+}
+
+func (this *diskUsage) walkEntries(path string, bucket string, files []os.FileInfo) duSummary {
+	s := duSummary{types: map[string]*DiskUsageEntry{}, complete: true}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	merge := func(c duSummary) {
+		mu.Lock()
+		s.merge(c)
+		mu.Unlock()
+	}
+	for _, f := range files {
+		b := bucket
+		if b == "" {
+			b = f.Name()
+		}
+		if f.IsDir() == false {
+			file := duSummary{size: max(f.Size(), 0), files: 1, types: map[string]*DiskUsageEntry{}, complete: true}
+			ext := duFileType(f.Name())
+			file.types[ext] = &DiskUsageEntry{Name: ext, Size: file.size, Files: 1}
+			this.account(b, false, file)
+			merge(file)
+			continue
+		}
+
+		this.account(b, true, duSummary{dirs: 1})
+		merge(duSummary{dirs: 1, complete: true})
+		child := path + f.Name() + "/"
+		var key map[string]string
+		if t := f.ModTime(); t.IsZero() == false && t.Unix() > 0 {
+			key = map[string]string{"tenant": this.tenantID, "path": child, "mtime": fmt.Sprintf("%d", t.UnixNano())}
+			if c := duCache.Get(key); c != nil {
+				this.account(b, true, c.(duSummary))
+				merge(c.(duSummary))
+				continue
+			}
+		}
+		run := func() {
+			r := this.walk(child, b)
+			if r.complete && key != nil {
+				duCache.Set(key, r)
+			}
+			merge(r)
+		}
+		select {
+		case this.slots <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-this.slots }()
+				run()
+			}()
+		default:
+			run()
+		}
+	}
+	wg.Wait()
+	return s
+}
+
+func (this *diskUsage) ls(path string) ([]os.FileInfo, error) {
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err := auth.Ls(this.app, path); err != nil {
+			return nil, err
+		}
+	}
+	return this.app.Backend.Ls(path)
+}
+
+func (this *diskUsage) account(bucket string, isDir bool, s duSummary) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.size += s.size
+	this.files += s.files
+	this.directories += s.dirs
+	c := this.children[bucket]
+	if c == nil {
+		c = &DiskUsageEntry{Name: bucket, Type: "file"}
+		if isDir {
+			c.Type = "directory"
+		}
+		this.children[bucket] = c
+	}
+	c.Size += s.size
+	c.Files += s.files
+	for ext, t := range s.types {
+		if this.types[ext] == nil {
+			this.types[ext] = &DiskUsageEntry{Name: ext}
+		}
+		this.types[ext].Size += t.Size
+		this.types[ext].Files += t.Files
+	}
+}
+
+func (this *diskUsage) fail(path string, err error) {
+	Log.Debug("model::du path=%s err=%s", path, err.Error())
+	this.mu.Lock()
+	this.errors += 1
+	this.mu.Unlock()
+}
+
+func (this *diskUsage) result(complete bool) DiskUsage {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return DiskUsage{
+		Size:        this.size,
+		Files:       this.files,
+		Directories: this.directories,
+		Errors:      this.errors,
+		Children:    duTop(this.children, this.top),
+		Types:       duTop(this.types, this.top),
+		Complete:    complete,
+		Elapsed:     time.Since(this.start).Milliseconds(),
+	}
+}
+
+func duTop(m map[string]*DiskUsageEntry, n int) []DiskUsageEntry {
+	out := make([]DiskUsageEntry, 0, len(m))
+	for _, e := range m {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Size == out[j].Size {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Size > out[j].Size
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func duFileType(name string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	if ext == "" {
+		return "other"
+	}
+	return ext
+}
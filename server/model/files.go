@@ -7,7 +7,7 @@ import (
 )
 
 func NewBackend(ctx *App, conn map[string]string) (IBackend, error) {
-	isAllowed := func() bool {
+	allowed := func() []map[string]interface{} {
 		// by default, a hacker could use filestash to establish connections outside of what's
 		// define in the config file. We need to prevent this
 		possibilities := make([]map[string]interface{}, 0)
@@ -38,16 +38,33 @@ func NewBackend(ctx *App, conn map[string]string) (IBackend, error) {
 			}
 			possibilities = append(possibilities, Config.Conn[i])
 		}
-		if len(possibilities) > 0 {
-			return true
-		}
-		return false
+		return possibilities
 	}
 
-	if isAllowed() == false {
+	possibilities := allowed()
+	if len(possibilities) == 0 {
 		return Backend.Get(BACKEND_NIL), ErrNotAllowed
 	}
-	return Backend.Get(conn["type"]).Init(conn, ctx)
+	connect := func(app *App) (IBackend, error) {
+		return Backend.Get(conn["type"]).Init(conn, app)
+	}
+	// the cache is turned on by the admin on the connection, not by what the user sends
+	if wrap := Hooks.Get.BackendCache(); wrap != nil && fmt.Sprintf("%v", possibilities[0]["cache"]) == "true" {
+		return wrap(conn, ctx, connect)
+	}
+	return connect(ctx)
+}
+
+// SourceParams gives the parameters of the connection a backend like crypt or restic sits on top
+// of: its type is in `source` and its parameters are the ones prefixed with `source_`
+func SourceParams(params map[string]string) map[string]string {
+	sourceParams := map[string]string{"type": params["source"]}
+	for key, value := range params {
+		if strings.HasPrefix(key, "source_") {
+			sourceParams[strings.TrimPrefix(key, "source_")] = value
+		}
+	}
+	return sourceParams
 }
 
 func GetHome(b IBackend, base string) (string, error) {
@@ -0,0 +1,242 @@
+package model
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * A health probe goes through what a user does on a connection: login, list the content of the
+ * root folder, then create a scratch folder in which a file is uploaded, read back, touched and
+ * removed. Every step is timed and a step that takes longer than the timeout aborts the probe, the
+ * backend being left to finish on its own. What a backend can do beyond the basics is detected
+ * along the way to help figure out why a feature doesn't show up for a connection.
+ */
+type HealthReport struct {
+	Label        string             `json:"label"`
+	Type         string             `json:"type"`
+	Status       string             `json:"status"`
+	Time         int64              `json:"time"`
+	Latency      int64              `json:"latency_ms"`
+	Steps        []HealthStep       `json:"steps"`
+	Capabilities HealthCapabilities `json:"capabilities"`
+}
+
+type HealthStep struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Duration int64  `json:"duration_ms"`
+	Error    string `json:"error,omitempty"`
+}
+
+type HealthCapabilities struct {
+	Meta     bool `json:"meta"`
+	Home     bool `json:"home"`
+	Range    bool `json:"range"`
+	Seek     bool `json:"seek"`
+	Checksum bool `json:"checksum"`
+	Posix    bool `json:"posix"`
+	Capacity bool `json:"capacity"`
+}
+
+const (
+	HEALTH_STATUS_HEALTHY  = "healthy"
+	HEALTH_STATUS_DEGRADED = "degraded"
+	HEALTH_STATUS_DOWN     = "down"
+)
+
+type healthProbe struct {
+	report  *HealthReport
+	timeout time.Duration
+	aborted bool
+}
+
+func HealthProbe(app *App, label string, params map[string]string, timeout time.Duration) (report HealthReport) {
+	report = HealthReport{
+		Label:  label,
+		Type:   params["type"],
+		Status: HEALTH_STATUS_HEALTHY,
+		Time:   time.Now().Unix(),
+		Steps:  []HealthStep{},
+	}
+	probe := healthProbe{report: &report, timeout: timeout}
+	start := time.Now()
+	defer func() {
+		report.Latency = time.Since(start).Milliseconds()
+		for _, s := range report.Steps {
+			if s.Status == "error" && report.Status == HEALTH_STATUS_HEALTHY {
+				report.Status = HEALTH_STATUS_DEGRADED
+			}
+		}
+	}()
+
+	var backend IBackend
+	if probe.step("init", func() (err error) {
+		backend, err = NewBackend(app, params)
+		return err
+	}) == false {
+		report.Status = HEALTH_STATUS_DOWN
+		return report
+	}
+	report.Capabilities = healthCapabilities(backend)
+
+	root := EnforceDirectory(params["path"])
+	if strings.TrimSpace(params["path"]) == "" {
+		root = "/"
+	}
+	if report.Capabilities.Home {
+		var home string
+		if probe.step("home", func() (err error) {
+			home, err = GetHome(backend, root)
+			return err
+		}) {
+			root = EnforceDirectory(strings.TrimSuffix(root, "/") + home)
+		}
+	}
+	if probe.step("ls", func() error {
+		_, err := backend.Ls(root)
+		return err
+	}) == false {
+		report.Status = HEALTH_STATUS_DOWN
+		return report
+	}
+	var meta Metadata
+	if obj, ok := backend.(interface{ Meta(path string) Metadata }); ok {
+		probe.step("meta", func() error {
+			meta = obj.Meta(root)
+			return nil
+		})
+	}
+	if probe.aborted == false && (isFalse(meta.CanCreateDirectory) || isFalse(meta.CanUpload) || isFalse(meta.CanDelete)) {
+		probe.skip("mkdir", "save", "stat", "cat", "range", "touch", "rm")
+		return report
+	}
+
+	scratch := root + ".filestash-health-" + QuickString(8) + "/"
+	content := []byte("filestash health check " + QuickString(32) + "\n")
+	aborted := probe.aborted
+	if probe.step("mkdir", func() error {
+		return backend.Mkdir(scratch)
+	}) == false && (aborted || probe.aborted == false) {
+		probe.skip("save", "stat", "cat", "range", "touch", "rm")
+		return report
+	}
+	// the scratch folder is removed whatever happens next, a probe that got aborted included as
+	// the step that timed out might have gone through in the meantime
+	defer probe.run("rm", func() error {
+		return backend.Rm(scratch)
+	})
+	probe.step("save", func() error {
+		return backend.Save(scratch+"probe.txt", bytes.NewReader(content))
+	})
+	probe.step("stat", func() error {
+		info, err := backend.Stat(scratch + "probe.txt")
+		if err != nil {
+			return err
+		} else if info.Size() != int64(len(content)) {
+			return NewError("Size mismatch", 500)
+		}
+		return nil
+	})
+	seek := false
+	if probe.step("cat", func() error {
+		reader, err := backend.Cat(scratch + "probe.txt")
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		_, seek = reader.(io.Seeker)
+		b, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		} else if bytes.Equal(b, content) == false {
+			return NewError("Content mismatch", 500)
+		}
+		return nil
+	}) {
+		report.Capabilities.Seek = seek
+	}
+	if obj, ok := backend.(IBackendRange); ok {
+		probe.step("range", func() error {
+			reader, err := obj.CatRange(scratch+"probe.txt", 10, 6)
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+			b, err := io.ReadAll(reader)
+			if err != nil {
+				return err
+			} else if bytes.Equal(b, content[10:16]) == false {
+				return NewError("Content mismatch", 500)
+			}
+			return nil
+		})
+	}
+	probe.step("touch", func() error {
+		return backend.Touch(scratch + "empty.txt")
+	})
+	return report
+}
+
+// step runs a part of the probe, telling if it went well. Once a step has timed out, the
+// connection is assumed to be stuck and whatever comes next is skipped
+func (this *healthProbe) step(name string, fn func() error) bool {
+	if this.aborted {
+		this.skip(name)
+		return false
+	}
+	return this.run(name, fn)
+}
+
+// run is a step that goes ahead even when the probe was aborted, with a timeout of its own
+func (this *healthProbe) run(name string, fn func() error) bool {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(this.timeout):
+		err = ErrTimeout
+		this.aborted = true
+	}
+	s := HealthStep{
+		Name:     name,
+		Status:   "ok",
+		Duration: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		s.Status = "error"
+		s.Error = err.Error()
+		Log.Debug("model::health step=%s type=%s err=%s", name, this.report.Type, err.Error())
+	}
+	this.report.Steps = append(this.report.Steps, s)
+	return err == nil
+}
+
+func (this *healthProbe) skip(names ...string) {
+	for _, name := range names {
+		this.report.Steps = append(this.report.Steps, HealthStep{Name: name, Status: "skipped"})
+	}
+}
+
+func healthCapabilities(backend IBackend) HealthCapabilities {
+	c := HealthCapabilities{}
+	_, c.Meta = backend.(interface{ Meta(path string) Metadata })
+	_, c.Home = backend.(interface{ Home() (string, error) })
+	_, c.Range = backend.(IBackendRange)
+	_, c.Checksum = backend.(IBackendChecksum)
+	_, c.Posix = backend.(IBackendPosix)
+	_, c.Capacity = backend.(IBackendCapacity)
+	return c
+}
+
+func isFalse(b *bool) bool {
+	return b != nil && *b == false
+}
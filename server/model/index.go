@@ -32,6 +32,10 @@ func init() {
 			}
 		}
 
+		if stmt, err := DB.Prepare("CREATE TABLE IF NOT EXISTS ConfigHistory(id INTEGER PRIMARY KEY AUTOINCREMENT, created_at DATETIME DEFAULT CURRENT_TIMESTAMP, author VARCHAR(512), config TEXT NOT NULL, changes JSON)"); err == nil {
+			stmt.Exec()
+		}
+
 		go func() {
 			autovacuum()
 		}()
@@ -11,6 +11,7 @@ import (
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
 )
 
 func WasmAdapterForMiddleware(wasmBytes []byte) (Middleware, error) {
@@ -58,6 +59,65 @@ func WasmAdapterForMiddleware(wasmBytes []byte) (Middleware, error) {
 	}, nil
 }
 
+/*
+ * WasmExecute runs a sandboxed function exported by a wasm module. The module must export:
+ * - malloc(size i32) i32: used to allocate the input in the guest memory
+ * - <fnName>(ptr i32, len i32) i32: receiving the input and returning a pointer to a null
+ *   terminated response
+ * The host functions are made available to the guest under the "filestash" namespace
+ */
+func WasmExecute(ctx context.Context, wasmBytes []byte, fnName string, input []byte, memoryLimitMB int, hostFuncs map[string]any) ([]byte, error) {
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if memoryLimitMB > 0 {
+		config = config.WithMemoryLimitPages(uint32(memoryLimitMB) * 16) // 1 page = 64KiB
+	}
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	defer runtime.Close(ctx)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		return nil, err
+	}
+	if len(hostFuncs) > 0 {
+		builder := runtime.NewHostModuleBuilder("filestash")
+		for name, fn := range hostFuncs {
+			builder = builder.NewFunctionBuilder().WithFunc(fn).Export(name)
+		}
+		if _, err := builder.Instantiate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	compiledModule, err := runtime.CompileModule(ctx, wasmBytes)
+	if err != nil {
+		return nil, err
+	}
+	module, err := runtime.InstantiateModule(ctx, compiledModule, wazero.NewModuleConfig().WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, err
+	}
+	malloc := module.ExportedFunction("malloc")
+	if malloc == nil {
+		return nil, NewError("plugin::adapter action=export error=missing+malloc+function", http.StatusInternalServerError)
+	}
+	wasmFunc := module.ExportedFunction(fnName)
+	if wasmFunc == nil {
+		return nil, NewError("plugin::adapter action=export error=missing+"+fnName+"+function", http.StatusInternalServerError)
+	}
+	ptr, err := malloc.Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	} else if len(ptr) != 1 || !module.Memory().Write(uint32(ptr[0]), input) {
+		return nil, NewError("plugin::adapter action=write error=out+of+range", http.StatusInternalServerError)
+	}
+	response, err := wasmFunc.Call(ctx, ptr[0], uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	responseBytes, err := wasmOutput(module.Memory(), response)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(responseBytes), nil
+}
+
 func wasmPrepare(wasmBytes []byte) (api.Module, error) {
 	ctx := context.Background()
 	runtime := wazero.NewRuntime(ctx)
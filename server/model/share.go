@@ -147,6 +147,42 @@ func ShareDelete(id string) error {
 	return err
 }
 
+// ShareReencrypt encrypts the auth of every share with the active key, it returns the number of
+// shares that have been updated
+func ShareReencrypt() (int, error) {
+	rows, err := DB.Query("SELECT id, auth FROM Share")
+	if err != nil {
+		return 0, err
+	}
+	auths := map[string]string{}
+	for rows.Next() {
+		var id, auth string
+		if err = rows.Scan(&id, &auth); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		auths[id] = auth
+	}
+	rows.Close()
+
+	n := 0
+	for id, auth := range auths {
+		str, err := DecryptString(SECRET_KEY_DERIVATE_FOR_USER, auth)
+		if err != nil {
+			Log.Warning("model::share::reencrypt id=%s err=%s", id, err.Error())
+			continue
+		}
+		if auth, err = EncryptString(SECRET_KEY_DERIVATE_FOR_USER, str); err != nil {
+			return n, err
+		}
+		if _, err = DB.Exec("UPDATE Share SET auth = ? WHERE id = ?", auth, id); err != nil {
+			return n, err
+		}
+		n += 1
+	}
+	return n, nil
+}
+
 func ShareProofVerifier(s Share, proof Proof) (Proof, error) {
 	p := proof
 
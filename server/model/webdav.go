@@ -68,6 +68,7 @@ func (this *WebdavFs) OpenFile(ctx context.Context, name string, flag int, perm
 		return nil
 	}
 	if this.webdavFile != nil {
+		this.webdavFile.ctx = ctx
 		this.webdavFile.fwrite = fwriteFile()
 		return this.webdavFile, nil
 	}
@@ -75,6 +76,7 @@ func (this *WebdavFs) OpenFile(ctx context.Context, name string, flag int, perm
 		return nil, os.ErrNotExist
 	}
 	this.webdavFile = &WebdavFile{
+		ctx:     ctx,
 		path:    name,
 		backend: this.backend,
 		cache:   cachePath,
@@ -137,6 +139,7 @@ func (this WebdavFs) fullpath(path string) string {
  * Implement a webdav.File and os.Stat : https://godoc.org/golang.org/x/net/webdav#File
  */
 type WebdavFile struct {
+	ctx     context.Context
 	path    string
 	backend IBackend
 	cache   string
@@ -262,6 +265,12 @@ func (this *WebdavFile) push_to_remote_if_needed() error {
 		return nil
 	}
 	this.fwrite.Close()
+	if this.ctx != nil && this.ctx.Err() != nil {
+		// the upload was aborted, what we got so far isn't worth sending
+		this.fwrite = nil
+		os.Remove(this.cache + "_writer")
+		return this.ctx.Err()
+	}
 	f, err := os.OpenFile(this.cache+"_writer", os.O_RDONLY, os.ModePerm)
 	if err != nil {
 		return err
@@ -0,0 +1,275 @@
+package tabular
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * The database backends show every table as a folder in which those virtual files let people
+ * get the whole table out or load many rows at once instead of going form by form
+ */
+const (
+	EXPORT_CSV   = "_export.csv"
+	EXPORT_JSONL = "_export.jsonl"
+	IMPORT_CSV   = "_import.csv"
+)
+
+func IsExport(name string) bool {
+	return name == EXPORT_CSV || name == EXPORT_JSONL
+}
+
+func IsImport(name string) bool {
+	return name == IMPORT_CSV
+}
+
+// Files are the virtual files to show in the folder of a table
+func Files() []File {
+	return []File{
+		{FName: EXPORT_CSV, FType: "file", FSize: -1},
+		{FName: EXPORT_JSONL, FType: "file", FSize: -1},
+	}
+}
+
+/*
+ * Export streams the result of a query as the rows come out of the database so a table never
+ * has to fit in memory, the pace is set by whoever reads it. onClose is called once the rows are
+ * all out or the reader gets closed, it's where the connection to the database should be released
+ */
+func Export(name string, rows *sql.Rows, onClose func()) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer onClose()
+		defer rows.Close()
+		var err error
+		if name == EXPORT_JSONL {
+			err = writeJSONL(pw, rows)
+		} else {
+			err = writeCSV(pw, rows)
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func writeCSV(w io.Writer, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	c := csv.NewWriter(w)
+	if err = c.Write(columns); err != nil {
+		return err
+	}
+	values, ptrs := scanTargets(len(columns))
+	record := make([]string, len(columns))
+	for rows.Next() {
+		if err = rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			record[i] = toString(v)
+		}
+		if err = c.Write(record); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+func writeJSONL(w io.Writer, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	keys := make([][]byte, len(columns))
+	for i, name := range columns {
+		keys[i], _ = json.Marshal(name)
+	}
+	values, ptrs := scanTargets(len(columns))
+	for rows.Next() {
+		if err = rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		line := []byte{'{'}
+		for i, v := range values {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			b, err := json.Marshal(toJSON(v, types[i].DatabaseTypeName()))
+			if err != nil {
+				return err
+			}
+			line = append(append(append(line, keys[i]...), ':'), b...)
+		}
+		if _, err = w.Write(append(line, '}', '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanTargets(n int) ([]any, []any) {
+	values := make([]any, n)
+	ptrs := make([]any, n)
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	return values, ptrs
+}
+
+func toString(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(t)
+	case time.Time:
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// toJSON keeps numbers as numbers for drivers like mysql which give everything as bytes
+func toJSON(v any, dbType string) any {
+	switch t := v.(type) {
+	case []byte:
+		if isNumeric(dbType) {
+			if _, err := strconv.ParseFloat(string(t), 64); err == nil {
+				return json.Number(t)
+			}
+		}
+		return string(t)
+	case time.Time:
+		return t.Format(time.RFC3339)
+	}
+	return v
+}
+
+func isNumeric(dbType string) bool {
+	dbType = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(dbType)), "UNSIGNED ")
+	if i := strings.IndexAny(dbType, "( "); i != -1 {
+		dbType = dbType[:i]
+	}
+	switch dbType {
+	case "INT", "INTEGER", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "INT2", "INT4", "INT8",
+		"DEC", "DECIMAL", "NUMERIC", "FLOAT", "FLOAT4", "FLOAT8", "DOUBLE", "REAL":
+		return true
+	}
+	return false
+}
+
+type Column struct {
+	Name     string
+	Type     string // the type as the database calls it, used to check numbers and booleans
+	Nullable bool
+	Default  bool // the database fills it up when it isn't given
+}
+
+/*
+ * Import reads a csv whose header gives the name of the columns and calls insert for every
+ * record once it's been checked against what we know of the table. An empty cell is NULL when
+ * the column allows it, is left to the database when it has a default and is an empty string
+ * otherwise. Errors refer to the line of the csv so the user can fix the file
+ */
+func Import(r io.Reader, columns []Column, insert func(names []string, values []any) error) (int, error) {
+	c := csv.NewReader(r)
+	header, err := c.Read()
+	if err == io.EOF {
+		return 0, NewError("Empty csv", 400)
+	} else if err != nil {
+		return 0, NewError("Invalid csv: "+err.Error(), 400)
+	}
+	known := map[string]Column{}
+	for _, col := range columns {
+		known[col.Name] = col
+	}
+	cols := make([]Column, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		col, ok := known[name]
+		if !ok {
+			return 0, NewError(fmt.Sprintf("Unknown column '%s'", name), 400)
+		} else if seen[name] {
+			return 0, NewError(fmt.Sprintf("Duplicate column '%s'", name), 400)
+		}
+		seen[name] = true
+		cols[i] = col
+	}
+	for _, col := range columns {
+		if !col.Nullable && !col.Default && !seen[col.Name] {
+			return 0, NewError(fmt.Sprintf("Missing column '%s'", col.Name), 400)
+		}
+	}
+
+	n := 0
+	for {
+		record, err := c.Read()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, NewError("Invalid csv: "+err.Error(), 400)
+		}
+		line, _ := c.FieldPos(0)
+		names := make([]string, 0, len(record))
+		values := make([]any, 0, len(record))
+		for i, cell := range record {
+			if cell == "" && cols[i].Default && !cols[i].Nullable {
+				continue
+			}
+			v, err := convert(cell, cols[i])
+			if err != nil {
+				return n, NewError(fmt.Sprintf("line %d: %s", line, err.Error()), 400)
+			}
+			names = append(names, cols[i].Name)
+			values = append(values, v)
+		}
+		if len(names) == 0 {
+			continue
+		} else if err = insert(names, values); err != nil {
+			return n, NewError(fmt.Sprintf("line %d: %s", line, err.Error()), 400)
+		}
+		n += 1
+	}
+}
+
+func convert(cell string, col Column) (any, error) {
+	t := strings.ToUpper(col.Type)
+	if cell == "" {
+		if col.Nullable {
+			return nil, nil
+		} else if isNumeric(t) || strings.Contains(t, "BOOL") {
+			return nil, fmt.Errorf("'%s' can't be empty", col.Name)
+		}
+		return cell, nil
+	}
+	if isNumeric(t) {
+		if _, err := strconv.ParseFloat(cell, 64); err != nil {
+			return nil, fmt.Errorf("'%s' should be a number, got '%s'", col.Name, cell)
+		}
+	} else if strings.Contains(t, "BOOL") {
+		b, err := strconv.ParseBool(cell)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' should be a boolean, got '%s'", col.Name, cell)
+		}
+		return b, nil
+	}
+	return cell, nil
+}
@@ -0,0 +1,276 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+func init() {
+	Hooks.Register.WorkflowAction(&RunWasm{})
+}
+
+/*
+ * RunWasm executes custom logic compiled to webassembly. The module is called through its
+ * "execute" export with the job input as a JSON object and must return a JSON object that
+ * gets merged into the job output. See model.WasmExecute for the calling convention.
+ *
+ * When a token is set, the guest can access files through host functions imported from the
+ * "filestash" module:
+ * - fs_read(path_ptr, path_len i32, offset i64, buf_ptr, buf_cap i32) i64: number of bytes read
+ * - fs_write(path_ptr, path_len, buf_ptr, buf_len i32) i32: 0 on success
+ * - fs_ls(path_ptr, path_len, buf_ptr, buf_cap i32) i64: length of the JSON listing, nothing is
+ *   written if it doesn't fit in the buffer
+ * Errors are reported as negative values
+ */
+type RunWasm struct{}
+
+func (this *RunWasm) Manifest() WorkflowSpecs {
+	return WorkflowSpecs{
+		Name:  "run/wasm",
+		Title: "Run WebAssembly",
+		Icon:  `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 640 640"><path d="M160 96C124.7 96 96 124.7 96 160L96 480C96 515.3 124.7 544 160 544L480 544C515.3 544 544 515.3 544 480L544 160C544 124.7 515.3 96 480 96L160 96zM263 231C272.4 221.6 287.6 221.6 296.9 231C306.2 240.4 306.3 255.6 296.9 264.9L241.9 319.9L296.9 374.9C306.3 384.3 306.3 399.5 296.9 408.8C287.5 418.1 272.3 418.2 263 408.8L191 336.8C181.6 327.4 181.6 312.2 191 302.9L263 230.9zM377 231L449 303C458.4 312.4 458.4 327.6 449 336.9L377 408.9C367.6 418.3 352.4 418.3 343.1 408.9C333.8 399.5 333.7 384.3 343.1 375L398.1 320L343.1 265C333.7 255.6 333.7 240.4 343.1 231.1C352.5 221.8 367.7 221.7 377 231.1z"/></svg>`,
+		Specs: Form{
+			Elmnts: []FormElement{
+				{
+					Name:        "module",
+					Type:        "text",
+					Placeholder: "eg: plugin://myplugin/action.wasm or /path/on/backend.wasm",
+					Description: "Location of the wasm module, either in an installed plugin or on the backend",
+				},
+				{
+					Name:        "token",
+					Type:        "text",
+					Description: "Give the module access to a backend",
+				},
+				{
+					Name:        "memory",
+					Type:        "number",
+					Placeholder: "Default: 16",
+					Description: "Memory limit in MB",
+				},
+				{
+					Name:        "timeout",
+					Type:        "number",
+					Placeholder: "Default: 10",
+					Description: "Execution time limit in seconds",
+				},
+			},
+		},
+	}
+}
+
+func (this *RunWasm) Execute(params map[string]string, input map[string]string) (map[string]string, error) {
+	var backend IBackend
+	if params["token"] != "" {
+		b, err := createBackend(params["token"])
+		if err != nil {
+			return input, err
+		}
+		backend = b
+	}
+	wasmBytes, err := loadWasmModule(Render(params["module"], input), backend)
+	if err != nil {
+		return input, err
+	}
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return input, err
+	}
+	memory := 16
+	if n, err := strconv.Atoi(params["memory"]); err == nil && n > 0 {
+		memory = n
+	}
+	timeout := 10
+	if n, err := strconv.Atoi(params["timeout"]); err == nil && n > 0 {
+		timeout = n
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	var hostFuncs map[string]any
+	if backend != nil {
+		hostFuncs = wasmHostFunctions(backend)
+	}
+	response, err := model.WasmExecute(ctx, wasmBytes, "execute", inputJSON, memory, hostFuncs)
+	if err != nil {
+		if ctx.Err() != nil {
+			return input, ErrTimeout
+		}
+		return input, err
+	}
+	var result map[string]any
+	if err = json.Unmarshal(response, &result); err != nil {
+		return input, NewError("Invalid wasm response: "+err.Error(), http.StatusInternalServerError)
+	}
+	output := make(map[string]string)
+	for k, v := range input {
+		output[k] = v
+	}
+	for k, v := range result {
+		switch val := v.(type) {
+		case string:
+			output[k] = val
+		default:
+			output[k] = toJSON(val)
+		}
+	}
+	return output, nil
+}
+
+func loadWasmModule(location string, backend IBackend) ([]byte, error) {
+	if p, ok := strings.CutPrefix(location, "plugin://"); ok {
+		pluginName, path, _ := strings.Cut(p, "/")
+		return model.GetPluginFile(pluginName, path)
+	} else if backend == nil {
+		return nil, NewError("A token is required to load a module from the backend", http.StatusBadRequest)
+	}
+	f, err := backend.Cat(location)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func wasmHostFunctions(backend IBackend) map[string]any {
+	readString := func(m api.Module, ptr, length uint32) (string, bool) {
+		b, ok := m.Memory().Read(ptr, length)
+		return string(b), ok
+	}
+	return map[string]any{
+		"fs_read": func(ctx context.Context, m api.Module, pathPtr, pathLen uint32, offset uint64, bufPtr, bufCap uint32) int64 {
+			path, ok := readString(m, pathPtr, pathLen)
+			if !ok {
+				return -1
+			} else if size := m.Memory().Size(); bufPtr > size {
+				return -1
+			} else {
+				bufCap = min(bufCap, size-bufPtr)
+			}
+			buf, err := wasmDo(ctx, func() ([]byte, error) {
+				return wasmRead(backend, path, int64(offset), int64(bufCap))
+			})
+			if err != nil {
+				Log.Debug("[workflow] action=run/wasm step=fs_read path=%s err=%s", path, err.Error())
+				return -1
+			} else if !m.Memory().Write(bufPtr, buf) {
+				return -1
+			}
+			return int64(len(buf))
+		},
+		"fs_write": func(ctx context.Context, m api.Module, pathPtr, pathLen, bufPtr, bufLen uint32) int32 {
+			path, ok := readString(m, pathPtr, pathLen)
+			if !ok {
+				return -1
+			}
+			content, ok := m.Memory().Read(bufPtr, bufLen)
+			if !ok {
+				return -1
+			}
+			content = bytes.Clone(content)
+			if _, err := wasmDo(ctx, func() ([]byte, error) {
+				return nil, backend.Save(path, bytes.NewReader(content))
+			}); err != nil {
+				Log.Debug("[workflow] action=run/wasm step=fs_write path=%s err=%s", path, err.Error())
+				return -1
+			}
+			return 0
+		},
+		"fs_ls": func(ctx context.Context, m api.Module, pathPtr, pathLen, bufPtr, bufCap uint32) int64 {
+			path, ok := readString(m, pathPtr, pathLen)
+			if !ok {
+				return -1
+			}
+			out, err := wasmDo(ctx, func() ([]byte, error) {
+				files, err := backend.Ls(path)
+				if err != nil {
+					return nil, err
+				}
+				list := make([]File, len(files))
+				for i, f := range files {
+					list[i] = File{
+						FName: f.Name(),
+						FType: "file",
+						FSize: f.Size(),
+						FTime: f.ModTime().Unix(),
+					}
+					if f.IsDir() {
+						list[i].FType = "directory"
+					}
+				}
+				return json.Marshal(list)
+			})
+			if err != nil {
+				Log.Debug("[workflow] action=run/wasm step=fs_ls path=%s err=%s", path, err.Error())
+				return -1
+			} else if uint32(len(out)) <= bufCap && !m.Memory().Write(bufPtr, out) {
+				return -1
+			}
+			return int64(len(out))
+		},
+	}
+}
+
+// wasmRead gives up to length bytes of a file from offset. The buffer is sized by the caller
+// after what fits in the guest memory so a guest can't make the host allocate more than its limit
+func wasmRead(backend IBackend, path string, offset int64, length int64) ([]byte, error) {
+	var (
+		f   io.ReadCloser
+		err error
+	)
+	if b, ok := backend.(IBackendRange); ok {
+		f, err = b.CatRange(path, offset, length)
+	} else if f, err = backend.Cat(path); err == nil {
+		if s, ok := f.(io.Seeker); ok {
+			_, err = s.Seek(offset, io.SeekStart)
+		} else if _, err = io.CopyN(io.Discard, f, offset); err == io.EOF {
+			err = nil
+		}
+		if err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, length)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// wasmDo runs a backend call made by the guest, giving up once the execution time limit is
+// reached. The call carries on in the background but the guest doesn't wait for it anymore
+func wasmDo(ctx context.Context, fn func() ([]byte, error)) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := fn()
+		done <- result{out, err}
+	}()
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -1,7 +1,12 @@
 package actions
 
 import (
+	"context"
+	"encoding/json"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
 	. "github.com/mickael-kerjean/filestash/server/ctrl"
+	"github.com/mickael-kerjean/filestash/server/model"
 )
 
 func Render(templateText string, variables map[string]string) string {
@@ -11,3 +16,22 @@ func Render(templateText string, variables map[string]string) string {
 	}
 	return rendered
 }
+
+func createBackend(token string) (IBackend, error) {
+	session := map[string]string{}
+	str, err := DecryptString(SECRET_KEY_DERIVATE_FOR_USER, token)
+	if err != nil {
+		return nil, err
+	} else if err = json.Unmarshal([]byte(str), &session); err != nil {
+		return nil, err
+	}
+	return model.NewBackend(&App{Context: context.Background()}, session)
+}
+
+func toJSON(val any) string {
+	b, err := json.Marshal(val)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
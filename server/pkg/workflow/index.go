@@ -26,9 +26,23 @@ func Init() error {
 		}
 		go func(t chan ITriggerEvent) {
 			for trigger := range t {
-				if err := CreateJob(trigger.WorkflowID(), trigger.Input()); err != nil {
+				var (
+					jobID    string
+					listener chan JobResult
+					err      error
+				)
+				s, sync := trigger.(ISyncTriggerEvent)
+				if sync {
+					jobID, listener, err = CreateSyncJob(trigger.WorkflowID(), trigger.Input())
+				} else {
+					jobID, err = CreateJob(trigger.WorkflowID(), trigger.Input())
+				}
+				if err != nil {
 					Log.Error("[workflow] action=createJob err=%s", err.Error())
 				}
+				if sync {
+					s.JobCreated(jobID, listener, err)
+				}
 				select {
 				case job_event <- nil:
 				default:
@@ -20,10 +20,12 @@ func ExecuteJob(jobID string, workflow Workflow, input map[string]string) {
 				status = "PENDING"
 			}
 			UpdateJob(jobID, status, workflow.Actions, input)
+			PublishJob(jobID, status, input)
 			return
 		}
 		UpdateJob(jobID, "RUNNING", workflow.Actions, input)
 	}
 	UpdateJob(jobID, "SUCCESS", workflow.Actions, map[string]string{})
+	PublishJob(jobID, "SUCCESS", input)
 	return
 }
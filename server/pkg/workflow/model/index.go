@@ -8,6 +8,10 @@ import (
 
 var db *sql.DB
 
+func init() {
+	common.Hooks.Register.OnKeyringReencrypt(ReencryptTokens)
+}
+
 func InitState() (err error) {
 	db, err = sql.Open("sqlite3", common.GetAbsolutePath(common.DB_PATH, "workflow.sql"))
 	if err != nil {
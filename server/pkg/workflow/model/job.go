@@ -3,6 +3,9 @@ package model
 import (
 	"database/sql"
 	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
 )
@@ -16,29 +19,63 @@ type Job struct {
 	UpdatedAt       string `json:"updated_at"`
 }
 
-func CreateJob(workflowID string, input map[string]string) error {
+type JobResult struct {
+	Status string
+	Output map[string]string
+}
+
+// ISyncTriggerEvent is implemented by trigger events whose emitter wants to
+// follow the job it created, eg: a webhook replying with the job output
+type ISyncTriggerEvent interface {
+	ITriggerEvent
+	JobCreated(jobID string, listener chan JobResult, err error)
+}
+
+var jobListeners sync.Map
+
+func CreateJob(workflowID string, input map[string]string) (string, error) {
+	return createJob(workflowID, input, nil)
+}
+
+// CreateSyncJob creates a job whose result is sent to the returned channel. The listener is in
+// place before the job can be picked up by a worker so even a job done in no time isn't missed
+func CreateSyncJob(workflowID string, input map[string]string) (string, chan JobResult, error) {
+	listener := make(chan JobResult, 1)
+	jobID, err := createJob(workflowID, input, listener)
+	if err != nil {
+		return "", nil, err
+	}
+	return jobID, listener, nil
+}
+
+func createJob(workflowID string, input map[string]string, listener chan JobResult) (string, error) {
 	workflow, err := GetWorkflow(workflowID)
 	if err != nil {
-		return err
+		return "", err
 	}
 	stepsJSON, err := json.Marshal(workflow.Actions)
 	if err != nil {
-		return err
+		return "", err
 	}
 	inputJSON, err := json.Marshal(input)
 	if err != nil {
-		return err
+		return "", err
 	}
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer tx.Rollback()
-	if _, err = tx.Exec(`
+	res, err := tx.Exec(`
 		INSERT INTO jobs (related_workflow, status, steps, input)
 			VALUES (?, 'READY', ?, ?)
-	`, workflowID, string(stepsJSON), string(inputJSON)); err != nil {
-		return err
+	`, workflowID, string(stepsJSON), string(inputJSON))
+	if err != nil {
+		return "", err
+	}
+	jobID, err := res.LastInsertId()
+	if err != nil {
+		return "", err
 	}
 	if _, err = tx.Exec(`DELETE FROM jobs WHERE related_workflow = ? AND id NOT IN (
 		SELECT id FROM jobs
@@ -46,9 +83,17 @@ func CreateJob(workflowID string, input map[string]string) error {
 			ORDER BY created_at DESC
 			LIMIT 1000
 	)`, workflowID, workflowID); err != nil {
-		return err
+		return "", err
+	}
+	id := strconv.FormatInt(jobID, 10)
+	if listener != nil {
+		jobListeners.Store(id, listener)
 	}
-	return tx.Commit()
+	if err = tx.Commit(); err != nil {
+		jobListeners.Delete(id)
+		return "", err
+	}
+	return id, nil
 }
 
 func NextJob() (string, Workflow, map[string]string, error) {
@@ -114,3 +159,24 @@ func UpdateJob(jobID string, status string, steps []Step, input map[string]strin
 		Log.Error("[workflow] from=job on=updateJob err=%s", err.Error())
 	}
 }
+
+func PublishJob(jobID string, status string, output map[string]string) {
+	l, ok := jobListeners.LoadAndDelete(jobID)
+	if !ok {
+		return
+	}
+	select {
+	case l.(chan JobResult) <- JobResult{Status: status, Output: output}:
+	default:
+	}
+}
+
+func WaitJob(jobID string, listener chan JobResult, timeout time.Duration) (JobResult, error) {
+	defer jobListeners.Delete(jobID)
+	select {
+	case result := <-listener:
+		return result, nil
+	case <-time.After(timeout):
+		return JobResult{}, ErrTimeout
+	}
+}
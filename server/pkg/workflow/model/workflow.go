@@ -135,6 +135,59 @@ func GetWorkflow(id string) (Workflow, error) {
 	return w, nil
 }
 
+// ReencryptTokens encrypts with the active key the session tokens the steps of a workflow run
+// with, so they keep working once the key they were made with is retired
+func ReencryptTokens() error {
+	if db == nil {
+		return nil
+	}
+	workflows, err := AllWorkflows()
+	if err != nil {
+		return err
+	}
+	for _, w := range workflows {
+		changed := reencryptToken(&w.Trigger)
+		for i := range w.Actions {
+			changed = reencryptToken(&w.Actions[i]) || changed
+		}
+		if changed == false {
+			continue
+		}
+		triggerJSON, err := json.Marshal(w.Trigger)
+		if err != nil {
+			return err
+		}
+		actionsJSON, err := json.Marshal(w.Actions)
+		if err != nil {
+			return err
+		}
+		if _, err = db.Exec(
+			`UPDATE workflows SET trigger = ?, actions = ? WHERE id = ?`,
+			string(triggerJSON), string(actionsJSON), w.ID,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func reencryptToken(step *Step) bool {
+	token := step.Params["token"]
+	if token == "" {
+		return false
+	}
+	str, err := DecryptString(SECRET_KEY_DERIVATE_FOR_USER, token)
+	if err != nil {
+		Log.Warning("[workflow] action=reencrypt step=%s err=%s", step.Name, err.Error())
+		return false
+	}
+	if token, err = EncryptString(SECRET_KEY_DERIVATE_FOR_USER, str); err != nil {
+		return false
+	}
+	step.Params["token"] = token
+	return true
+}
+
 func DeleteWorkflow(id string) error {
 	result, err := db.Exec(`DELETE FROM workflows WHERE id = ?`, id)
 	if err != nil {
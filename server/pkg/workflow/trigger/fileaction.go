@@ -58,6 +58,11 @@ func (this hookAuthorisation) Touch(ctx *App, path string) error {
 	return nil
 }
 
+func (this hookAuthorisation) Chmod(ctx *App, path string) error {
+	processFileAction(ctx, map[string]string{"event": "chmod", "path": path})
+	return nil
+}
+
 type FileEventTrigger struct{}
 
 func (this *FileEventTrigger) Manifest() WorkflowSpecs {
@@ -70,7 +75,7 @@ func (this *FileEventTrigger) Manifest() WorkflowSpecs {
 				{
 					Name:       "event",
 					Type:       "text",
-					Datalist:   []string{"ls", "cat", "mkdir", "mv", "rm", "touch", "save", "stat"},
+					Datalist:   []string{"ls", "cat", "mkdir", "mv", "rm", "touch", "save", "stat", "chmod"},
 					MultiValue: true,
 				},
 				{
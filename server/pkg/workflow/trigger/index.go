@@ -3,6 +3,7 @@ package trigger
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
 	. "github.com/mickael-kerjean/filestash/server/pkg/workflow/model"
@@ -21,6 +22,39 @@ func (this *TriggerEvent) WorkflowID() string {
 	return this.ID
 }
 
+type SyncTriggerEvent struct {
+	TriggerEvent
+	created  chan string
+	listener chan JobResult
+	err      error
+}
+
+func NewSyncTriggerEvent(workflowID string, params map[string]string) *SyncTriggerEvent {
+	return &SyncTriggerEvent{
+		TriggerEvent: TriggerEvent{ID: workflowID, Params: params},
+		created:      make(chan string, 1),
+	}
+}
+
+func (this *SyncTriggerEvent) JobCreated(jobID string, listener chan JobResult, err error) {
+	this.err = err
+	this.listener = listener
+	this.created <- jobID
+}
+
+func (this *SyncTriggerEvent) Wait(timeout time.Duration) (JobResult, error) {
+	deadline := time.Now().Add(timeout)
+	select {
+	case jobID := <-this.created:
+		if this.err != nil {
+			return JobResult{}, this.err
+		}
+		return WaitJob(jobID, this.listener, time.Until(deadline))
+	case <-time.After(timeout):
+		return JobResult{}, ErrTimeout
+	}
+}
+
 func TriggerEvents(event chan ITriggerEvent, triggerID string, callback func(Workflow) (map[string]string, bool)) error {
 	workflows, err := FindWorkflows(triggerID)
 	if err != nil {
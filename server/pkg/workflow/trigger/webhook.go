@@ -3,8 +3,10 @@ package trigger
 import (
 	"bytes"
 	"html/template"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
 	. "github.com/mickael-kerjean/filestash/server/pkg/workflow/model"
@@ -13,9 +15,11 @@ import (
 )
 
 var (
-	webhook_event = make(chan ITriggerEvent, 5)
-	webhook_name  = "webhook"
-	webhookTmpl   = template.Must(template.New("webhook").Parse(`
+	webhook_event        = make(chan ITriggerEvent, 5)
+	webhook_name         = "webhook"
+	webhook_max_body     = int64(5 << 20)
+	webhook_sync_timeout = 60 * time.Second
+	webhookTmpl          = template.Must(template.New("webhook").Parse(`
 		<h1>Triggers</h1>
 		<ul>
 			{{range .}}<li><a href="?id={{.ID}}">{{.Name}}</a></li>{{end}}
@@ -28,26 +32,44 @@ func init() {
 	Hooks.Register.WorkflowTrigger(&WebhookTrigger{})
 }
 
-func webhookCallback(r *http.Request, id string) func(w Workflow) (map[string]string, bool) {
+func webhookCallback(r *http.Request, body []byte, id string) func(w Workflow) (map[string]string, bool) {
 	return func(w Workflow) (map[string]string, bool) {
-		headers := map[string]any{}
-		for k, v := range r.Header {
-			headers[k] = strings.Join(v, ", ")
+		if id != "" && id != w.ID {
+			return nil, false
+		} else if err := webhookVerify(w.Trigger.Params, r, body); err != nil {
+			Log.Debug("[workflow] trigger=webhook workflow=%s step=verify err=%s", w.ID, err.Error())
+			return nil, false
 		}
-		query := map[string]any{}
-		for k, v := range r.URL.Query() {
-			query[k] = strings.Join(v, ", ")
-		}
-		out := map[string]string{
-			"method":  r.Method,
-			"headers": toJSON(headers),
-			"query":   toJSON(query),
+		return webhookInput(w.Trigger.Params, r, body), true
+	}
+}
+
+func webhookInput(params map[string]string, r *http.Request, body []byte) map[string]string {
+	headers := map[string]any{}
+	for k, v := range r.Header {
+		if k == "Authorization" {
+			continue
 		}
-		if id == "" {
-			return out, true
+		headers[k] = strings.Join(v, ", ")
+	}
+	query := map[string]any{}
+	for k, v := range r.URL.Query() {
+		query[k] = strings.Join(v, ", ")
+	}
+	out := map[string]string{
+		"method":  r.Method,
+		"headers": toJSON(headers),
+		"query":   toJSON(query),
+		"body":    string(body),
+	}
+	for k, v := range webhookExtract(params["fields"], body) {
+		if _, reserved := out[k]; reserved {
+			Log.Debug("[workflow] trigger=webhook step=extract field=%s err=reserved", k)
+			continue
 		}
-		return out, id == w.ID
+		out[k] = v
 	}
+	return out
 }
 
 type WebhookTrigger struct{}
@@ -65,6 +87,42 @@ func (this *WebhookTrigger) Manifest() WorkflowSpecs {
 					ReadOnly: true,
 					Value:    "/api/workflow/webhook?web",
 				},
+				{
+					Name:        "signature",
+					Type:        "select",
+					Opts:        []string{"none", "github", "stripe", "standard"},
+					Value:       "none",
+					Description: "Scheme used by the caller to sign the payload with the secret",
+				},
+				{
+					Name:        "secret",
+					Type:        "password",
+					Description: "Shared secret used to verify the signature of the request",
+				},
+				{
+					Name:        "token",
+					Type:        "password",
+					Description: "When set, requests must provide an 'Authorization: Bearer <token>' header",
+				},
+				{
+					Name:        "tolerance",
+					Type:        "number",
+					Placeholder: "Default: 300",
+					Description: "Maximum age in seconds of a signed request before it is rejected as a replay",
+				},
+				{
+					Name:        "fields",
+					Type:        "long_text",
+					Placeholder: "eg: repository: repository.full_name",
+					Description: "JSON fields of the body made available to the actions, one 'name: path' per line",
+				},
+				{
+					Name:        "mode",
+					Type:        "select",
+					Opts:        []string{"async", "sync"},
+					Value:       "async",
+					Description: "In sync mode, the request waits for the job to complete and returns its output",
+				},
 			},
 		},
 		Order: 5,
@@ -90,7 +148,31 @@ func (this *WebhookTrigger) Init() (chan ITriggerEvent, error) {
 				return
 			}
 
-			if err := TriggerEvents(webhook_event, webhook_name, webhookCallback(r, r.URL.Query().Get("id"))); err != nil {
+			body, err := io.ReadAll(io.LimitReader(r.Body, webhook_max_body+1))
+			if err != nil {
+				SendErrorResult(w, err)
+				return
+			} else if int64(len(body)) > webhook_max_body {
+				SendErrorResult(w, NewError("Payload too large", http.StatusRequestEntityTooLarge))
+				return
+			}
+			if id := r.URL.Query().Get("id"); id != "" {
+				workflow, err := GetWorkflow(id)
+				if err != nil {
+					SendErrorResult(w, err)
+					return
+				} else if workflow.Trigger.Name != webhook_name || !workflow.Published {
+					SendErrorResult(w, ErrNotFound)
+					return
+				} else if err = webhookVerify(workflow.Trigger.Params, r, body); err != nil {
+					SendErrorResult(w, err)
+					return
+				} else if workflow.Trigger.Params["mode"] == "sync" {
+					webhookSync(w, r, workflow, body)
+					return
+				}
+			}
+			if err := TriggerEvents(webhook_event, webhook_name, webhookCallback(r, body, r.URL.Query().Get("id"))); err != nil {
 				SendErrorResult(w, err)
 				return
 			}
@@ -100,3 +182,28 @@ func (this *WebhookTrigger) Init() (chan ITriggerEvent, error) {
 	})
 	return webhook_event, nil
 }
+
+func webhookSync(w http.ResponseWriter, r *http.Request, workflow Workflow, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	event := NewSyncTriggerEvent(workflow.ID, webhookInput(workflow.Trigger.Params, r, body))
+	select {
+	case webhook_event <- event:
+	default:
+		SendErrorResult(w, NewError("Workflow is busy", http.StatusServiceUnavailable))
+		return
+	}
+	result, err := event.Wait(webhook_sync_timeout)
+	if err != nil {
+		SendErrorResult(w, err)
+		return
+	}
+	switch result.Status {
+	case "SUCCESS":
+		SendSuccessResult(w, result.Output)
+	case "PENDING":
+		w.WriteHeader(http.StatusAccepted)
+		SendSuccessResult(w, result.Output)
+	default:
+		SendErrorResult(w, NewError("Workflow has failed", http.StatusBadGateway))
+	}
+}
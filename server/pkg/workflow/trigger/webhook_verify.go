@@ -0,0 +1,146 @@
+package trigger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/tidwall/gjson"
+)
+
+var ErrWebhookSignature = NewError("Invalid signature", http.StatusUnauthorized)
+
+func webhookVerify(params map[string]string, r *http.Request, body []byte) error {
+	if token := params["token"]; token != "" {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			return ErrNotAuthorized
+		}
+	}
+	secret := params["secret"]
+	switch params["signature"] {
+	case "", "none":
+		return nil
+	case "github":
+		// X-Hub-Signature-256: sha256=<hex(hmac(secret, body))>
+		sig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok || secret == "" {
+			return ErrWebhookSignature
+		}
+		return webhookCompareHex(sig, webhookHMAC([]byte(secret), body))
+	case "stripe":
+		// Stripe-Signature: t=<timestamp>,v1=<hex(hmac(secret, timestamp.body))>
+		var timestamp string
+		var sigs []string
+		for _, part := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if k == "t" {
+				timestamp = v
+			} else if k == "v1" {
+				sigs = append(sigs, v)
+			}
+		}
+		if secret == "" || timestamp == "" {
+			return ErrWebhookSignature
+		} else if err := webhookFresh(timestamp, params["tolerance"]); err != nil {
+			return err
+		}
+		expected := webhookHMAC([]byte(secret), append([]byte(timestamp+"."), body...))
+		for _, sig := range sigs {
+			if webhookCompareHex(sig, expected) == nil {
+				return nil
+			}
+		}
+		return ErrWebhookSignature
+	case "standard":
+		// see https://www.standardwebhooks.com:
+		// webhook-signature: v1,<base64(hmac(secret, id.timestamp.body))>
+		id := r.Header.Get("Webhook-Id")
+		timestamp := r.Header.Get("Webhook-Timestamp")
+		if secret == "" || id == "" || timestamp == "" {
+			return ErrWebhookSignature
+		} else if err := webhookFresh(timestamp, params["tolerance"]); err != nil {
+			return err
+		}
+		key := []byte(secret)
+		if s, ok := strings.CutPrefix(secret, "whsec_"); ok {
+			k, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return ErrWebhookSignature
+			}
+			key = k
+		}
+		expected := webhookHMAC(key, append([]byte(id+"."+timestamp+"."), body...))
+		for _, sig := range strings.Fields(r.Header.Get("Webhook-Signature")) {
+			v, ok := strings.CutPrefix(sig, "v1,")
+			if !ok {
+				continue
+			}
+			if b, err := base64.StdEncoding.DecodeString(v); err == nil && hmac.Equal(b, expected) {
+				return nil
+			}
+		}
+		return ErrWebhookSignature
+	}
+	return ErrNotSupported
+}
+
+func webhookHMAC(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func webhookCompareHex(sig string, expected []byte) error {
+	b, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(b, expected) {
+		return ErrWebhookSignature
+	}
+	return nil
+}
+
+func webhookFresh(timestamp string, tolerance string) error {
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookSignature
+	}
+	maxAge := 300
+	if n, err := strconv.Atoi(tolerance); err == nil && n > 0 {
+		maxAge = n
+	}
+	if d := time.Since(time.Unix(t, 0)); d > time.Duration(maxAge)*time.Second || d < -time.Duration(maxAge)*time.Second {
+		return NewError("Request has expired", http.StatusUnauthorized)
+	}
+	return nil
+}
+
+func webhookExtract(fields string, body []byte) map[string]string {
+	out := map[string]string{}
+	if fields == "" || !gjson.ValidBytes(body) {
+		return out
+	}
+	for _, line := range strings.Split(fields, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, path, ok := strings.Cut(line, ":")
+		if !ok {
+			path = name
+		}
+		name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+		if name == "" || path == "" {
+			continue
+		}
+		out[name] = gjson.GetBytes(body, path).String()
+	}
+	return out
+}
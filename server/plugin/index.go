@@ -11,11 +11,15 @@ import (
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_authenticate_wordpress"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_artifactory"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_backblaze"
+	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_cache"
+	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_crypt"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_dav"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_dropbox"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_ftp"
+	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_gcs"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_gdrive"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_git"
+	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_imap"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_ldap"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_local"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_mysql"
@@ -23,13 +27,17 @@ import (
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_nop"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_perkeep"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_psql"
+	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_restic"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_s3"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_samba"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_sftp"
+	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_sqlite"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_storj"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_tmp"
+	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_union"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_url"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_backend_webdav"
+	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_config_sql"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_editor_wopi"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_console"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp"
@@ -38,6 +46,8 @@ import (
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_image_c"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_license"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_metadata_sqlite"
+	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_metrics_health"
+	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_quota"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_search_stateless"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_security_scanner"
 	_ "github.com/mickael-kerjean/filestash/server/plugin/plg_security_svg"
@@ -44,3 +44,8 @@ func (this AuthM) Touch(ctx *App, path string) error {
 	Log.Stdout("TOUCH %+v", ctx.Session)
 	return ErrNotAllowed
 }
+
+func (this AuthM) Chmod(ctx *App, path string) error {
+	Log.Stdout("CHMOD %+v", ctx.Session)
+	return ErrNotAllowed
+}
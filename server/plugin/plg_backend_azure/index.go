@@ -132,6 +132,24 @@ func (this AzureBlob) Cat(path string) (io.ReadCloser, error) {
 	}, nil
 }
 
+func (this AzureBlob) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	ap := this.path(path)
+	rng := azblob.HTTPRange{Offset: offset}
+	if length >= 0 {
+		rng.Count = length
+	}
+	resp, err := this.client.DownloadStream(
+		this.ctx,
+		ap.containerName,
+		ap.blobName,
+		&azblob.DownloadStreamOptions{Range: rng},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
 type azureFilecat struct {
 	offset int64
 	ctx    context.Context
@@ -197,8 +197,46 @@ func (this Backblaze) Cat(path string) (io.ReadCloser, error) {
 	return res.Body, nil
 }
 
+func (this Backblaze) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	res, err := this.request(
+		"GET",
+		this.DownloadUrl+"/file"+path+"?Authorization="+this.Token,
+		nil, func(req *http.Request) {
+			req.Header.Set("Range", HTTPRange(offset, length))
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return HTTPRangeBody(res, offset, length)
+}
+
 func (this Backblaze) Stat(path string) (os.FileInfo, error) {
-	return nil, ErrNotImplemented
+	if IsDirectory(path) {
+		return File{FName: filepath.Base(path), FType: "directory"}, nil
+	}
+	// a HEAD on the download url is the cheapest way to know about a file
+	res, err := this.request(
+		"HEAD",
+		this.DownloadUrl+"/file"+path+"?Authorization="+this.Token,
+		nil, nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	} else if res.StatusCode >= 400 {
+		return nil, NewError(HTTPFriendlyStatus(res.StatusCode), res.StatusCode)
+	}
+	uploadTime, _ := strconv.ParseInt(res.Header.Get("X-Bz-Upload-Timestamp"), 10, 64)
+	return File{
+		FName: filepath.Base(path),
+		FType: "file",
+		FSize: res.ContentLength,
+		FTime: uploadTime / 1000,
+	}, nil
 }
 
 func (this Backblaze) Mkdir(path string) error {
@@ -0,0 +1,72 @@
+package plg_backend_cache
+
+import (
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.Onload(func() {
+		PluginTTL()
+		PluginStale()
+		PluginMaxSize()
+		PluginMaxFileSize()
+	})
+}
+
+var PluginTTL = func() time.Duration {
+	return time.Duration(Config.Get("features.cache.ttl").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "ttl"
+		f.Type = "number"
+		f.Description = "Seconds during which listings and file information are served from the cache without asking the remote"
+		f.Placeholder = "Default: 60"
+		f.Default = 60
+		return f
+	}).Int()) * time.Second
+}
+
+var PluginStale = func() time.Duration {
+	return time.Duration(Config.Get("features.cache.stale").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "stale"
+		f.Type = "number"
+		f.Description = "Seconds after the ttl during which an outdated listing is still shown while it gets refreshed in the background"
+		f.Placeholder = "Default: 600"
+		f.Default = 600
+		return f
+	}).Int()) * time.Second
+}
+
+var PluginMaxSize = func() int64 {
+	return int64(Config.Get("features.cache.max_size").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "max_size"
+		f.Type = "number"
+		f.Description = "Size in MB of the disk cache for the content of the files. The least recently used files are removed first"
+		f.Placeholder = "Default: 1024"
+		f.Default = 1024
+		return f
+	}).Int()) * 1024 * 1024
+}
+
+var PluginMaxFileSize = func() int64 {
+	return int64(Config.Get("features.cache.max_file_size").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "max_file_size"
+		f.Type = "number"
+		f.Description = "Size in MB above which a file isn't kept in the disk cache"
+		f.Placeholder = "Default: 100"
+		f.Default = 100
+		return f
+	}).Int()) * 1024 * 1024
+}
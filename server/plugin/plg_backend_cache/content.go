@@ -0,0 +1,187 @@
+package plg_backend_cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * content keeps a copy of the files on disk, under TMP_PATH, and removes the least recently used
+ * ones once the cache gets over its size limit. A copy is only valid for the version of the file
+ * it was made from, the version being its size and modification time as given by the remote. The
+ * index lives in memory so whatever is left on disk from a previous run is removed on startup
+ */
+var content = &contentCache{
+	entries: map[string]*list.Element{},
+	lru:     list.New(),
+}
+
+func init() {
+	Hooks.Register.Onload(func() {
+		content.dir = GetAbsolutePath(TMP_PATH, "cache")
+		os.RemoveAll(content.dir)
+		if err := os.MkdirAll(content.dir, os.ModePerm); err != nil {
+			Log.Warning("plg_backend_cache::init action=mkdir err=%s", err.Error())
+		}
+	})
+}
+
+type contentCache struct {
+	mu        sync.Mutex
+	dir       string
+	entries   map[string]*list.Element
+	lru       *list.List
+	size      int64
+	evictions atomic.Int64
+}
+
+type contentEntry struct {
+	key     string
+	conn    string
+	path    string
+	version string
+	size    int64
+}
+
+func (this *contentCache) file(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(this.dir, hex.EncodeToString(h[:]))
+}
+
+func (this *contentCache) open(conn string, path string, version string) (*os.File, bool) {
+	key := conn + "|" + path
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	el, ok := this.entries[key]
+	if !ok {
+		return nil, false
+	} else if el.Value.(*contentEntry).version != version {
+		this.remove(el)
+		return nil, false
+	}
+	f, err := os.Open(this.file(key))
+	if err != nil {
+		this.remove(el)
+		return nil, false
+	}
+	this.lru.MoveToFront(el)
+	return f, true
+}
+
+// store moves a fully downloaded file in the cache
+func (this *contentCache) store(conn string, path string, version string, tmp string, size int64) {
+	key := conn + "|" + path
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if el, ok := this.entries[key]; ok {
+		this.remove(el)
+	}
+	if err := os.Rename(tmp, this.file(key)); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	this.entries[key] = this.lru.PushFront(&contentEntry{key: key, conn: conn, path: path, version: version, size: size})
+	this.size += size
+	for this.size > PluginMaxSize() && this.lru.Len() > 0 {
+		this.remove(this.lru.Back())
+		this.evictions.Add(1)
+	}
+}
+
+// invalidate drops the copy of a file, or of everything under a folder
+func (this *contentCache) invalidate(conn string, path string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for _, el := range this.entries {
+		e := el.Value.(*contentEntry)
+		if e.conn != conn {
+			continue
+		} else if e.path == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(e.path, path)) {
+			this.remove(el)
+		}
+	}
+}
+
+func (this *contentCache) remove(el *list.Element) {
+	e := el.Value.(*contentEntry)
+	this.lru.Remove(el)
+	delete(this.entries, e.key)
+	this.size -= e.size
+	os.Remove(this.file(e.key))
+}
+
+func (this *contentCache) stats() (int, int64) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.lru.Len(), this.size
+}
+
+/*
+ * teeReader gives the content of a file as it comes from the remote while writing a copy of it
+ * on disk. The copy only makes it in the cache if the file was read until the end
+ */
+type teeReader struct {
+	reader   io.ReadCloser
+	tmp      *os.File
+	size     int64
+	complete bool
+	store    func(tmp string, size int64)
+}
+
+func newTeeReader(r io.ReadCloser, store func(tmp string, size int64)) io.ReadCloser {
+	if content.dir == "" {
+		return r
+	}
+	tmp, err := os.CreateTemp(content.dir, "tmp_*")
+	if err != nil {
+		return r
+	}
+	return &teeReader{reader: r, tmp: tmp, store: store}
+}
+
+func (this *teeReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	if n > 0 && this.tmp != nil {
+		this.size += int64(n)
+		if this.size > PluginMaxFileSize() {
+			this.abort()
+		} else if _, werr := this.tmp.Write(p[:n]); werr != nil {
+			this.abort()
+		}
+	}
+	if err == io.EOF {
+		this.complete = true
+	}
+	return n, err
+}
+
+func (this *teeReader) abort() {
+	this.tmp.Close()
+	os.Remove(this.tmp.Name())
+	this.tmp = nil
+}
+
+func (this *teeReader) Close() error {
+	err := this.reader.Close()
+	if this.tmp == nil {
+		return err
+	} else if this.complete == false {
+		this.abort()
+		return err
+	}
+	if cerr := this.tmp.Close(); cerr != nil {
+		os.Remove(this.tmp.Name())
+		return err
+	}
+	this.store(this.tmp.Name(), this.size)
+	return err
+}
@@ -0,0 +1,271 @@
+package plg_backend_cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * The cache sits in front of a slow remote, like FTP or a far away WebDAV server, to avoid going
+ * over the network for what was just seen. Admins enable it on a connection of the config with
+ * `"cache": true`, model.NewBackend then gives a Cache that only connects to the remote when it
+ * can't answer. Listings and file information are kept in memory and the content of the files on
+ * disk. Anything written through the cache invalidates what it knows about the path, what's
+ * changed on the remote by someone else is seen once the ttl is over.
+ */
+type Cache struct {
+	app     *App
+	params  map[string]string
+	conn    string
+	connect func(app *App) (IBackend, error)
+	source  *source
+}
+
+type source struct {
+	backend IBackend
+	err     error
+	once    sync.Once
+}
+
+func init() {
+	Hooks.Register.BackendCache(newCache)
+}
+
+func newCache(params map[string]string, app *App, connect func(app *App) (IBackend, error)) (IBackend, error) {
+	return &Cache{
+		app:     app,
+		params:  params,
+		conn:    connID(params),
+		connect: connect,
+		source:  &source{},
+	}, nil
+}
+
+func (this Cache) Init(params map[string]string, app *App) (IBackend, error) {
+	return newCache(params, app, this.connect)
+}
+
+// connID identifies the connection in the cache. Unlike GenerateID it covers every parameter,
+// credentials included, as a cache hit is served without ever asking the remote who we are
+func connID(params map[string]string) string {
+	b, _ := json.Marshal(params)
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func (this Cache) LoginForm() Form {
+	return Form{}
+}
+
+// remote connects to the source the first time it's needed, a cache hit doesn't need it at all
+func (this Cache) remote() (IBackend, error) {
+	s := this.source
+	s.once.Do(func() {
+		if s.backend, s.err = this.connect(this.app); s.err != nil {
+			Log.Debug("plg_backend_cache::remote err=%s", s.err.Error())
+		}
+	})
+	return s.backend, s.err
+}
+
+func (this Cache) Ls(path string) ([]os.FileInfo, error) {
+	key := metaKey(this.conn, "ls", path)
+	if e, age := meta.get(key); e != nil {
+		if age < PluginTTL() {
+			stats.ls.hit.Add(1)
+			return append([]os.FileInfo{}, e.files...), nil
+		} else if age < PluginTTL()+PluginStale() {
+			stats.ls.stale.Add(1)
+			meta.refresh(key, func() { this.revalidate(path) })
+			return append([]os.FileInfo{}, e.files...), nil
+		}
+	}
+	stats.ls.miss.Add(1)
+	b, err := this.remote()
+	if err != nil {
+		return nil, err
+	}
+	files, err := b.Ls(path)
+	if err != nil {
+		return nil, err
+	}
+	this.remember(path, files)
+	return append([]os.FileInfo{}, files...), nil
+}
+
+// revalidate lists a folder in the background, the request which got the stale listing is long
+// gone by then so it's done from a connection of its own
+func (this Cache) revalidate(path string) {
+	b, err := this.connect(&App{Context: context.Background(), Session: this.app.Session})
+	if err != nil {
+		Log.Debug("plg_backend_cache::revalidate action=connect path=%s err=%s", path, err.Error())
+		return
+	}
+	files, err := b.Ls(path)
+	if err != nil {
+		Log.Debug("plg_backend_cache::revalidate action=ls path=%s err=%s", path, err.Error())
+		return
+	}
+	this.remember(path, files)
+}
+
+// remember keeps a listing and the information about every file in it
+func (this Cache) remember(path string, files []os.FileInfo) {
+	meta.set(&metaEntry{key: metaKey(this.conn, "ls", path), files: files})
+	for _, f := range files {
+		p := path + f.Name()
+		if f.IsDir() {
+			p += "/"
+		}
+		meta.set(&metaEntry{key: metaKey(this.conn, "stat", p), info: f})
+	}
+}
+
+func (this Cache) Stat(path string) (os.FileInfo, error) {
+	key := metaKey(this.conn, "stat", path)
+	if e, age := meta.get(key); e != nil && age < PluginTTL() {
+		stats.stat.hit.Add(1)
+		return e.info, nil
+	}
+	stats.stat.miss.Add(1)
+	b, err := this.remote()
+	if err != nil {
+		return nil, err
+	}
+	info, err := b.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	meta.set(&metaEntry{key: key, info: info})
+	return info, nil
+}
+
+// version tells apart the successive contents of a file, it's empty when we can't tell
+func (this Cache) version(path string) string {
+	info, err := this.Stat(path)
+	if err != nil || info.IsDir() {
+		return ""
+	} else if info.ModTime().IsZero() || info.ModTime().Unix() <= 0 || info.Size() < 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+}
+
+func (this Cache) Cat(path string) (io.ReadCloser, error) {
+	version := this.version(path)
+	if version == "" {
+		stats.cat.bypass.Add(1)
+		b, err := this.remote()
+		if err != nil {
+			return nil, err
+		}
+		return b.Cat(path)
+	}
+	if f, ok := content.open(this.conn, path, version); ok {
+		stats.cat.hit.Add(1)
+		return f, nil
+	}
+	stats.cat.miss.Add(1)
+	b, err := this.remote()
+	if err != nil {
+		return nil, err
+	}
+	r, err := b.Cat(path)
+	if err != nil {
+		return nil, err
+	}
+	return newTeeReader(r, func(tmp string, size int64) {
+		content.store(this.conn, path, version, tmp, size)
+	}), nil
+}
+
+func (this Cache) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	if version := this.version(path); version != "" {
+		if f, ok := content.open(this.conn, path, version); ok {
+			stats.cat.hit.Add(1)
+			return NewSectionReadCloser(f, offset, length), nil
+		}
+	}
+	stats.cat.bypass.Add(1)
+	b, err := this.remote()
+	if err != nil {
+		return nil, err
+	} else if obj, ok := b.(IBackendRange); ok {
+		return obj.CatRange(path, offset, length)
+	}
+	r, err := b.Cat(path)
+	if err != nil {
+		return nil, err
+	} else if _, err = io.CopyN(io.Discard, r, offset); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return r, nil
+	}
+	return readCloser{io.LimitReader(r, length), r}, nil
+}
+
+func (this Cache) Mkdir(path string) error {
+	return this.write(func(b IBackend) error { return b.Mkdir(path) }, path)
+}
+
+func (this Cache) Rm(path string) error {
+	return this.write(func(b IBackend) error { return b.Rm(path) }, path)
+}
+
+func (this Cache) Mv(from string, to string) error {
+	return this.write(func(b IBackend) error { return b.Mv(from, to) }, from, to)
+}
+
+func (this Cache) Save(path string, file io.Reader) error {
+	return this.write(func(b IBackend) error { return b.Save(path, file) }, path)
+}
+
+func (this Cache) Touch(path string) error {
+	return this.write(func(b IBackend) error { return b.Touch(path) }, path)
+}
+
+// write goes to the remote and forgets what we knew about the paths it changes, even if it
+// failed as it might have done part of the job
+func (this Cache) write(fn func(IBackend) error, paths ...string) error {
+	b, err := this.remote()
+	if err != nil {
+		return err
+	}
+	err = fn(b)
+	for _, path := range paths {
+		meta.invalidate(this.conn, path)
+		content.invalidate(this.conn, path)
+	}
+	return err
+}
+
+func (this Cache) Meta(path string) Metadata {
+	key := metaKey(this.conn, "meta", path)
+	if e, age := meta.get(key); e != nil && age < PluginTTL() {
+		return e.perms
+	}
+	perms := Metadata{}
+	b, err := this.remote()
+	if err != nil {
+		return perms
+	} else if obj, ok := b.(interface{ Meta(path string) Metadata }); ok {
+		perms = obj.Meta(path)
+	}
+	meta.set(&metaEntry{key: key, perms: perms})
+	return perms
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
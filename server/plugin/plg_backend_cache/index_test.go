@@ -0,0 +1,45 @@
+package plg_backend_cache
+
+import (
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestConnID(t *testing.T) {
+	newTestCache := func(params map[string]string) *Cache {
+		b, err := newCache(params, nil, func(app *App) (IBackend, error) { return nil, ErrNotImplemented })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.(*Cache)
+	}
+	alice := newTestCache(map[string]string{"type": "ftp", "hostname": "example.com", "username": "alice", "password": "secret"})
+	if c := newTestCache(map[string]string{"type": "ftp", "hostname": "example.com", "username": "alice", "password": "secret"}); c.conn != alice.conn {
+		t.Fatal("the same connection should share its cache")
+	}
+	if c := newTestCache(map[string]string{"type": "ftp", "hostname": "example.com", "username": "alice", "password": "wrong"}); c.conn == alice.conn {
+		t.Fatal("a wrong password shouldn't be served from the cache of the right one")
+	}
+	if c := newTestCache(map[string]string{"type": "sftp", "hostname": "example.com", "username": "alice", "password": "secret"}); c.conn == alice.conn {
+		t.Fatal("a different type of connection is a different connection")
+	}
+}
+
+func TestConnectOnDemand(t *testing.T) {
+	calls := 0
+	b, _ := newCache(map[string]string{"type": "ftp"}, &App{}, func(app *App) (IBackend, error) {
+		calls += 1
+		return nil, ErrNotReachable
+	})
+	if calls != 0 {
+		t.Fatal("the remote shouldn't be reached before it's needed")
+	}
+	if _, err := b.Ls("/"); err != ErrNotReachable {
+		t.Fatalf("unexpected error %v", err)
+	}
+	b.Stat("/file")
+	if calls != 1 {
+		t.Fatalf("the remote should be connected to once, got %d", calls)
+	}
+}
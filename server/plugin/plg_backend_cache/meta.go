@@ -0,0 +1,128 @@
+package plg_backend_cache
+
+import (
+	"container/list"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+const META_MAX_ENTRIES = 50000
+
+/*
+ * metaCache keeps listings and file information in memory. Keys start with a hash of all the
+ * parameters of the connection, credentials included, so what a user sees never leaks to someone
+ * connected with other credentials
+ */
+var meta = &metaCache{
+	entries:    map[string]*list.Element{},
+	lru:        list.New(),
+	refreshing: map[string]bool{},
+}
+
+type metaCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+	refreshing map[string]bool
+}
+
+type metaEntry struct {
+	key     string
+	files   []os.FileInfo
+	info    os.FileInfo
+	perms   Metadata
+	fetched time.Time
+}
+
+func metaKey(conn string, kind string, path string) string {
+	return conn + "|" + kind + "|" + path
+}
+
+func (this *metaCache) get(key string) (*metaEntry, time.Duration) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	el, ok := this.entries[key]
+	if !ok {
+		return nil, 0
+	}
+	this.lru.MoveToFront(el)
+	e := el.Value.(*metaEntry)
+	return e, time.Since(e.fetched)
+}
+
+func (this *metaCache) set(e *metaEntry) {
+	e.fetched = time.Now()
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if el, ok := this.entries[e.key]; ok {
+		el.Value = e
+		this.lru.MoveToFront(el)
+		return
+	}
+	this.entries[e.key] = this.lru.PushFront(e)
+	for this.lru.Len() > META_MAX_ENTRIES {
+		last := this.lru.Back()
+		this.lru.Remove(last)
+		delete(this.entries, last.Value.(*metaEntry).key)
+	}
+}
+
+// invalidate forgets about a path, the listing of its parent and everything under it
+func (this *metaCache) invalidate(conn string, path string) {
+	parent := path
+	if strings.HasSuffix(parent, "/") {
+		parent = strings.TrimSuffix(parent, "/")
+	}
+	parent = parent[:strings.LastIndex(parent, "/")+1]
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	drop := func(key string) {
+		if el, ok := this.entries[key]; ok {
+			this.lru.Remove(el)
+			delete(this.entries, key)
+		}
+	}
+	drop(metaKey(conn, "ls", parent))
+	drop(metaKey(conn, "stat", strings.TrimSuffix(path, "/")))
+	drop(metaKey(conn, "stat", path))
+	if strings.HasSuffix(path, "/") == false {
+		return
+	}
+	for _, kind := range []string{"ls", "stat"} {
+		prefix := metaKey(conn, kind, path)
+		for key := range this.entries {
+			if strings.HasPrefix(key, prefix) {
+				drop(key)
+			}
+		}
+	}
+}
+
+// refresh makes sure only one background refresh of a listing runs at once
+func (this *metaCache) refresh(key string, fn func()) {
+	this.mu.Lock()
+	if this.refreshing[key] {
+		this.mu.Unlock()
+		return
+	}
+	this.refreshing[key] = true
+	this.mu.Unlock()
+	go func() {
+		defer func() {
+			this.mu.Lock()
+			delete(this.refreshing, key)
+			this.mu.Unlock()
+		}()
+		fn()
+	}()
+}
+
+func (this *metaCache) size() int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.lru.Len()
+}
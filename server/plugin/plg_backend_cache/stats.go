@@ -0,0 +1,61 @@
+package plg_backend_cache
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	Hooks.Register.HttpEndpoint(func(r *mux.Router) error {
+		r.HandleFunc(WithBase("/admin/api/cache"), middleware.NewMiddlewareChain(
+			StatsHandler,
+			[]Middleware{middleware.AdminOnly},
+		)).Methods("GET")
+		return nil
+	})
+}
+
+type counter struct {
+	hit    atomic.Int64
+	miss   atomic.Int64
+	stale  atomic.Int64
+	bypass atomic.Int64
+}
+
+func (this *counter) json() map[string]int64 {
+	return map[string]int64{
+		"hit":    this.hit.Load(),
+		"miss":   this.miss.Load(),
+		"stale":  this.stale.Load(),
+		"bypass": this.bypass.Load(),
+	}
+}
+
+var stats = struct {
+	ls   counter
+	stat counter
+	cat  counter
+}{}
+
+func StatsHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	files, size := content.stats()
+	SendSuccessResult(res, map[string]interface{}{
+		"ls":   stats.ls.json(),
+		"stat": stats.stat.json(),
+		"cat":  stats.cat.json(),
+		"metadata": map[string]int64{
+			"entries": int64(meta.size()),
+		},
+		"content": map[string]int64{
+			"files":     int64(files),
+			"size":      size,
+			"max_size":  PluginMaxSize(),
+			"evictions": content.evictions.Load(),
+		},
+	})
+}
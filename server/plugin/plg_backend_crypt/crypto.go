@@ -0,0 +1,148 @@
+package plg_backend_crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KEY_SIZE      = 32
+	NAME_IV_SIZE  = 16
+	DEFAULT_SALT  = "filestash"
+	SCRYPT_N      = 1 << 15
+	SCRYPT_R      = 8
+	SCRYPT_P      = 1
+	INFO_CONTENT  = "filestash crypt content"
+	INFO_NAME_ENC = "filestash crypt name encryption"
+	INFO_NAME_MAC = "filestash crypt name authentication"
+)
+
+var (
+	ErrDecrypt    = NewError("Can't decrypt, the key is wrong or the file is corrupted", 500)
+	ErrInvalidKey = NewError("The key should be 32 bytes encoded in hex or base64", 400)
+	nameEncoding  = base32.HexEncoding.WithPadding(base32.NoPadding)
+)
+
+// scrypt is slow on purpose and the backend is initialised on every request
+var keyCache AppCache
+
+func init() {
+	keyCache = NewAppCache(10, 5)
+}
+
+type keys struct {
+	content []byte
+	nameEnc []byte
+	nameMac []byte
+}
+
+func newKeys(params map[string]string) (*keys, error) {
+	var master []byte
+	if k := strings.TrimSpace(params["key"]); k != "" {
+		for _, decode := range []func(string) ([]byte, error){
+			hex.DecodeString,
+			base64.StdEncoding.DecodeString,
+			base64.RawStdEncoding.DecodeString,
+			base64.URLEncoding.DecodeString,
+			base64.RawURLEncoding.DecodeString,
+		} {
+			if b, err := decode(k); err == nil && len(b) == KEY_SIZE {
+				master = b
+				break
+			}
+		}
+		if master == nil {
+			return nil, ErrInvalidKey
+		}
+	} else if params["passphrase"] != "" {
+		salt := params["salt"]
+		if salt == "" {
+			salt = DEFAULT_SALT
+		}
+		cacheKey := map[string]string{"passphrase": params["passphrase"], "salt": salt}
+		if c := keyCache.Get(cacheKey); c != nil {
+			return c.(*keys), nil
+		}
+		b, err := scrypt.Key([]byte(params["passphrase"]), []byte(salt), SCRYPT_N, SCRYPT_R, SCRYPT_P, KEY_SIZE)
+		if err != nil {
+			return nil, err
+		}
+		k, err := deriveKeys(b)
+		if err != nil {
+			return nil, err
+		}
+		keyCache.Set(cacheKey, k)
+		return k, nil
+	} else {
+		return nil, ErrNotValid
+	}
+	return deriveKeys(master)
+}
+
+func deriveKeys(master []byte) (*keys, error) {
+	k := &keys{}
+	var err error
+	if k.content, err = hkdf.Key(sha256.New, master, nil, INFO_CONTENT, KEY_SIZE); err != nil {
+		return nil, err
+	} else if k.nameEnc, err = hkdf.Key(sha256.New, master, nil, INFO_NAME_ENC, KEY_SIZE); err != nil {
+		return nil, err
+	} else if k.nameMac, err = hkdf.Key(sha256.New, master, nil, INFO_NAME_MAC, KEY_SIZE); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+/*
+ * Names need to always encrypt the same way or we couldn't find a file from its path. The iv is
+ * the HMAC of the name which makes it a synthetic iv: the same name gives the same ciphertext and
+ * anything tampered with doesn't match its iv anymore
+ */
+func (this *keys) encryptName(name string) string {
+	mac := hmac.New(sha256.New, this.nameMac)
+	mac.Write([]byte(name))
+	iv := mac.Sum(nil)[:NAME_IV_SIZE]
+	block, _ := aes.NewCipher(this.nameEnc)
+	out := make([]byte, NAME_IV_SIZE+len(name))
+	copy(out, iv)
+	cipher.NewCTR(block, iv).XORKeyStream(out[NAME_IV_SIZE:], []byte(name))
+	return strings.ToLower(nameEncoding.EncodeToString(out))
+}
+
+func (this *keys) decryptName(name string) (string, error) {
+	b, err := nameEncoding.DecodeString(strings.ToUpper(name))
+	if err != nil || len(b) < NAME_IV_SIZE {
+		return "", ErrDecrypt
+	}
+	iv := b[:NAME_IV_SIZE]
+	block, _ := aes.NewCipher(this.nameEnc)
+	out := make([]byte, len(b)-NAME_IV_SIZE)
+	cipher.NewCTR(block, iv).XORKeyStream(out, b[NAME_IV_SIZE:])
+	mac := hmac.New(sha256.New, this.nameMac)
+	mac.Write(out)
+	if hmac.Equal(mac.Sum(nil)[:NAME_IV_SIZE], iv) == false {
+		return "", ErrDecrypt
+	}
+	return string(out), nil
+}
+
+// encryptPath encrypts every part of a path on its own so the tree keeps the same shape
+func (this *keys) encryptPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i := range parts {
+		if parts[i] != "" {
+			parts[i] = this.encryptName(parts[i])
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,237 @@
+package plg_backend_crypt
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+/*
+ * The crypt backend sits in front of another backend and encrypts everything before it leaves
+ * the server: the content of the files and their names. The storage provider only sees the shape
+ * of the tree, the number of files and roughly how big they are. The source is configured like
+ * the source of the sqlite backend: its type in `source` and its parameters prefixed by `source_`
+ */
+type Crypt struct {
+	backend IBackend
+	keys    *keys
+}
+
+func init() {
+	Backend.Register("crypt", Crypt{})
+}
+
+func (this Crypt) Init(params map[string]string, app *App) (IBackend, error) {
+	if params["source"] == "" {
+		return nil, ErrNotValid
+	}
+	k, err := newKeys(params)
+	if err != nil {
+		Log.Debug("plg_backend_crypt::init action=keys err=%s", err.Error())
+		return nil, err
+	}
+	backend, err := model.NewBackend(app, model.SourceParams(params))
+	if err != nil {
+		Log.Debug("plg_backend_crypt::init action=source err=%s", err.Error())
+		return nil, err
+	}
+	return &Crypt{backend: backend, keys: k}, nil
+}
+
+func (this Crypt) LoginForm() Form {
+	return Form{
+		Elmnts: []FormElement{
+			FormElement{
+				Name:  "type",
+				Type:  "hidden",
+				Value: "crypt",
+			},
+			FormElement{
+				Name:        "source",
+				Type:        "text",
+				Placeholder: "Source backend",
+				Description: "Where the encrypted files are stored, eg: s3. Its parameters are the ones prefixed with 'source_'",
+			},
+			FormElement{
+				Name:        "passphrase",
+				Type:        "password",
+				Placeholder: "Passphrase",
+			},
+			FormElement{
+				Name:        "advanced",
+				Type:        "enable",
+				Placeholder: "Advanced",
+				Target:      []string{"crypt_key", "crypt_salt"},
+			},
+			FormElement{
+				Id:          "crypt_key",
+				Name:        "key",
+				Type:        "password",
+				Placeholder: "Key",
+				Description: "32 bytes encoded in hex or base64, used instead of the passphrase",
+			},
+			FormElement{
+				Id:          "crypt_salt",
+				Name:        "salt",
+				Type:        "text",
+				Placeholder: "Salt",
+				Description: "Salt used to derive the key from the passphrase. Default: '" + DEFAULT_SALT + "'",
+			},
+		},
+	}
+}
+
+func (this Crypt) Ls(path string) ([]os.FileInfo, error) {
+	files, err := this.backend.Ls(this.keys.encryptPath(path))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]os.FileInfo, 0, len(files))
+	for _, f := range files {
+		name, err := this.keys.decryptName(f.Name())
+		if err != nil {
+			Log.Debug("plg_backend_crypt::ls action=skip path=%s name=%s", path, f.Name())
+			continue
+		}
+		out = append(out, this.toPlain(name, f))
+	}
+	return out, nil
+}
+
+func (this Crypt) Stat(path string) (os.FileInfo, error) {
+	f, err := this.backend.Stat(this.keys.encryptPath(path))
+	if err != nil {
+		return nil, err
+	}
+	return this.toPlain(filepath.Base(path), f), nil
+}
+
+func (this Crypt) Cat(path string) (io.ReadCloser, error) {
+	r, err := this.backend.Cat(this.keys.encryptPath(path))
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := readHeader(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return newDecryptReader(this.keys.content, nonce, r, 0, false)
+}
+
+/*
+ * CatRange only fetches the chunks that cover the requested range, plus the header of the file
+ * to get its nonce. Source backends that can't do range reads are read from the start
+ */
+func (this Crypt) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	p := this.keys.encryptPath(path)
+	if length == 0 {
+		return NewReadCloserFromBytes([]byte{}), nil
+	}
+	r, err := this.catRange(p, 0, int64(HEADER_SIZE))
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := readHeader(r)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	first := offset / CHUNK_SIZE
+	sealedLength := int64(-1)
+	if length > 0 {
+		sealedLength = ((offset+length-1)/CHUNK_SIZE - first + 1) * SEALED_SIZE
+	}
+	if r, err = this.catRange(p, int64(HEADER_SIZE)+first*SEALED_SIZE, sealedLength); err != nil {
+		return nil, err
+	}
+	d, err := newDecryptReader(this.keys.content, nonce, r, uint64(first), length > 0)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if _, err = io.CopyN(io.Discard, d, offset-first*CHUNK_SIZE); err != nil {
+		d.Close()
+		if err == io.EOF {
+			return NewReadCloserFromBytes([]byte{}), nil
+		}
+		return nil, err
+	}
+	if length < 0 {
+		return d, nil
+	}
+	return readCloser{io.LimitReader(d, length), d}, nil
+}
+
+func (this Crypt) catRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	if obj, ok := this.backend.(IBackendRange); ok {
+		return obj.CatRange(path, offset, length)
+	}
+	r, err := this.backend.Cat(path)
+	if err != nil {
+		return nil, err
+	} else if _, err = io.CopyN(io.Discard, r, offset); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return r, nil
+	}
+	return readCloser{io.LimitReader(r, length), r}, nil
+}
+
+func (this Crypt) Mkdir(path string) error {
+	return this.backend.Mkdir(this.keys.encryptPath(path))
+}
+
+func (this Crypt) Rm(path string) error {
+	return this.backend.Rm(this.keys.encryptPath(path))
+}
+
+func (this Crypt) Mv(from string, to string) error {
+	return this.backend.Mv(this.keys.encryptPath(from), this.keys.encryptPath(to))
+}
+
+func (this Crypt) Save(path string, file io.Reader) error {
+	r, err := newEncryptReader(this.keys.content, file)
+	if err != nil {
+		return err
+	}
+	return this.backend.Save(this.keys.encryptPath(path), r)
+}
+
+// Touch can't leave an empty file as even an empty file has a header and a sealed chunk
+func (this Crypt) Touch(path string) error {
+	return this.Save(path, strings.NewReader(""))
+}
+
+func (this Crypt) Meta(path string) Metadata {
+	if obj, ok := this.backend.(interface{ Meta(path string) Metadata }); ok {
+		return obj.Meta(this.keys.encryptPath(path))
+	}
+	return Metadata{}
+}
+
+func (this Crypt) toPlain(name string, f os.FileInfo) File {
+	file := File{
+		FName: name,
+		FType: "file",
+		FTime: f.ModTime().Unix(),
+		FSize: f.Size(),
+	}
+	if f.IsDir() {
+		file.FType = "directory"
+	} else if file.FSize > 0 {
+		file.FSize = plainSize(file.FSize)
+	}
+	return file
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
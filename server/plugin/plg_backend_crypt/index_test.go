@@ -0,0 +1,301 @@
+package plg_backend_crypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+const TEST_KEY = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestVectors(t *testing.T) {
+	k, err := newKeys(map[string]string{"key": TEST_KEY})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(k.content); got != "93ad72a7355bffe2fb36af34839e9fe2ba0258a60d88b3b5d56e20e006897e72" {
+		t.Fatalf("unexpected content key %s", got)
+	}
+	if got := k.encryptName("hello.txt"); got != "er55vccnhovbt5j5eei7nse8f927plf3ln6odlet" {
+		t.Fatalf("unexpected name %s", got)
+	}
+	if name, err := k.decryptName("er55vccnhovbt5j5eei7nse8f927plf3ln6odlet"); err != nil || name != "hello.txt" {
+		t.Fatalf("unexpected name=%q err=%v", name, err)
+	}
+
+	file, _ := hex.DecodeString("4653435259505431" +
+		"404142434445464748494a4b4c4d4e4f5051525354555657" +
+		"62db5c76072d81d45691012adfacdf1e1837434199c0f88de8")
+	r := NewReadCloserFromBytes(file)
+	nonce, err := readHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDecryptReader(k.content, nonce, r, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(d); err != nil || string(b) != "filestash" {
+		t.Fatalf("unexpected content=%q err=%v", b, err)
+	}
+
+	k, err = newKeys(map[string]string{"passphrase": "correct horse battery staple"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(k.content); got != "044db3e7968a68d25af0f53545f6043b260ba59341b1847d3380623ae9fa1ef5" {
+		t.Fatalf("unexpected content key from passphrase %s", got)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	if _, err := newKeys(map[string]string{"key": "tooshort"}); err != ErrInvalidKey {
+		t.Fatalf("a short key should be rejected, got %v", err)
+	}
+	if _, err := newKeys(map[string]string{}); err != ErrNotValid {
+		t.Fatalf("a key or a passphrase is needed, got %v", err)
+	}
+	k, _ := newKeys(map[string]string{"key": TEST_KEY})
+	name := k.encryptName("hello.txt")
+	tampered := []byte(name)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := k.decryptName(string(tampered)); err != ErrDecrypt {
+		t.Fatalf("a tampered name should be rejected, got %v", err)
+	}
+	if p := k.encryptPath("/a/b/"); p != "/"+k.encryptName("a")+"/"+k.encryptName("b")+"/" {
+		t.Fatalf("unexpected path %s", p)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, CHUNK_SIZE - 1, CHUNK_SIZE, CHUNK_SIZE + 1, 3 * CHUNK_SIZE} {
+		backend := newTestBackend(t, false)
+		content := randomBytes(size)
+		if err := backend.Save("/dir/file.bin", bytes.NewReader(content)); err != nil {
+			t.Fatalf("size=%d save err=%s", size, err.Error())
+		}
+		r, err := backend.Cat("/dir/file.bin")
+		if got := readAll(t, r, err); bytes.Equal(got, content) == false {
+			t.Fatalf("size=%d content doesn't match", size)
+		}
+		f, err := backend.Stat("/dir/file.bin")
+		if err != nil {
+			t.Fatal(err)
+		} else if f.Size() != int64(size) {
+			t.Fatalf("size=%d stat gives %d", size, f.Size())
+		}
+		files, err := backend.Ls("/dir/")
+		if err != nil || len(files) != 1 || files[0].Name() != "file.bin" || files[0].Size() != int64(size) {
+			t.Fatalf("size=%d unexpected listing %v err=%v", size, files, err)
+		}
+	}
+}
+
+func TestTampering(t *testing.T) {
+	content := randomBytes(2*CHUNK_SIZE + 10)
+	tests := map[string]func([]byte) []byte{
+		"drop last chunk": func(b []byte) []byte { return b[:HEADER_SIZE+2*SEALED_SIZE] },
+		"cut in a chunk":  func(b []byte) []byte { return b[:len(b)-5] },
+		"flip a bit":      func(b []byte) []byte { b[HEADER_SIZE+SEALED_SIZE+3] ^= 1; return b },
+		"swap chunks": func(b []byte) []byte {
+			out := append([]byte{}, b[:HEADER_SIZE]...)
+			out = append(out, b[HEADER_SIZE+SEALED_SIZE:HEADER_SIZE+2*SEALED_SIZE]...)
+			out = append(out, b[HEADER_SIZE:HEADER_SIZE+SEALED_SIZE]...)
+			return append(out, b[HEADER_SIZE+2*SEALED_SIZE:]...)
+		},
+		"bad magic": func(b []byte) []byte { b[0] = 'X'; return b },
+	}
+	for name, tamper := range tests {
+		backend := newTestBackend(t, false)
+		if err := backend.Save("/file", bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		mem := backend.backend.(*memBackend)
+		p := backend.keys.encryptPath("/file")
+		mem.files[p] = tamper(mem.files[p])
+		r, err := backend.Cat("/file")
+		if err == nil {
+			_, err = io.ReadAll(r)
+			r.Close()
+		}
+		if err != ErrDecrypt {
+			t.Errorf("%s: expected ErrDecrypt, got %v", name, err)
+		}
+	}
+}
+
+func TestCatRange(t *testing.T) {
+	size := int64(3*CHUNK_SIZE + 100)
+	content := randomBytes(int(size))
+	tests := []struct {
+		offset int64
+		length int64
+	}{
+		{0, 10},
+		{0, -1},
+		{5, 0},
+		{CHUNK_SIZE - 3, 6},
+		{CHUNK_SIZE, CHUNK_SIZE},
+		{CHUNK_SIZE + 1, -1},
+		{2*CHUNK_SIZE - 1, CHUNK_SIZE + 2},
+		{size - 10, 10},
+		{size - 10, 100},
+		{size - 1, -1},
+		{size, 10},
+		{size + 100, -1},
+	}
+	for _, withRange := range []bool{true, false} {
+		backend := newTestBackend(t, withRange)
+		if err := backend.Save("/file", bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			start := min(test.offset, size)
+			end := size
+			if test.length >= 0 {
+				end = min(test.offset+test.length, size)
+			}
+			r, err := backend.CatRange("/file", test.offset, test.length)
+			if got := readAll(t, r, err); bytes.Equal(got, content[start:end]) == false {
+				t.Errorf("range=%t offset=%d length=%d got %d bytes, want %d", withRange, test.offset, test.length, len(got), end-start)
+			}
+		}
+	}
+}
+
+func TestPlainSize(t *testing.T) {
+	tests := map[int64]int64{
+		0:                                  0,
+		int64(HEADER_SIZE + TAG_SIZE):      0,
+		int64(HEADER_SIZE + TAG_SIZE + 1):  1,
+		int64(HEADER_SIZE + SEALED_SIZE):   CHUNK_SIZE,
+		int64(HEADER_SIZE + 2*SEALED_SIZE): 2 * CHUNK_SIZE,
+		int64(HEADER_SIZE+SEALED_SIZE+TAG_SIZE) + 1: CHUNK_SIZE + 1,
+	}
+	for in, expected := range tests {
+		if got := plainSize(in); got != expected {
+			t.Errorf("plainSize(%d) got %d want %d", in, got, expected)
+		}
+	}
+}
+
+func newTestBackend(t *testing.T, withRange bool) *Crypt {
+	k, err := newKeys(map[string]string{"key": TEST_KEY})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := &memBackend{files: map[string][]byte{}}
+	if withRange {
+		return &Crypt{backend: memRangeBackend{mem}, keys: k}
+	}
+	return &Crypt{backend: mem, keys: k}
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func readAll(t *testing.T, r io.ReadCloser, err error) []byte {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// memBackend is a storage that keeps everything in memory and can't do range reads
+type memBackend struct {
+	files map[string][]byte
+}
+
+func (this *memBackend) Init(params map[string]string, app *App) (IBackend, error) {
+	return this, nil
+}
+
+func (this *memBackend) Ls(path string) ([]os.FileInfo, error) {
+	files := []os.FileInfo{}
+	for p, b := range this.files {
+		if filepath.Dir(p)+"/" == path {
+			files = append(files, File{FName: filepath.Base(p), FType: "file", FSize: int64(len(b))})
+		}
+	}
+	return files, nil
+}
+
+func (this *memBackend) Stat(path string) (os.FileInfo, error) {
+	b, ok := this.files[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return File{FName: filepath.Base(path), FType: "file", FSize: int64(len(b))}, nil
+}
+
+func (this *memBackend) Cat(path string) (io.ReadCloser, error) {
+	b, ok := this.files[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return NewReadCloserFromBytes(b), nil
+}
+
+func (this *memBackend) Mkdir(path string) error {
+	return nil
+}
+
+func (this *memBackend) Rm(path string) error {
+	delete(this.files, path)
+	return nil
+}
+
+func (this *memBackend) Mv(from string, to string) error {
+	this.files[to] = this.files[from]
+	delete(this.files, from)
+	return nil
+}
+
+func (this *memBackend) Save(path string, file io.Reader) error {
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	this.files[path] = b
+	return nil
+}
+
+func (this *memBackend) Touch(path string) error {
+	return this.Save(path, strings.NewReader(""))
+}
+
+func (this *memBackend) LoginForm() Form {
+	return Form{}
+}
+
+type memRangeBackend struct {
+	*memBackend
+}
+
+func (this memRangeBackend) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	b, ok := this.files[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	b = b[min(offset, int64(len(b))):]
+	if length >= 0 {
+		b = b[:min(length, int64(len(b)))]
+	}
+	return NewReadCloserFromBytes(b), nil
+}
@@ -0,0 +1,191 @@
+package plg_backend_crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/*
+ * The content is cut in chunks that are sealed on their own so we can decrypt any part of a file
+ * without going through what comes before. See the README for a description of the format.
+ */
+const (
+	CHUNK_SIZE  = 64 * 1024
+	TAG_SIZE    = chacha20poly1305.Overhead
+	SEALED_SIZE = CHUNK_SIZE + TAG_SIZE
+	HEADER_SIZE = len(MAGIC) + chacha20poly1305.NonceSizeX
+	MAGIC       = "FSCRYPT1"
+)
+
+func chunkNonce(base []byte, counter uint64) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+	c := binary.BigEndian.Uint64(nonce[len(nonce)-8:]) ^ counter
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], c)
+	return nonce
+}
+
+// chunkAD marks the last chunk so a file can't be truncated without us noticing
+func chunkAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// plainSize is the size of a file once decrypted
+func plainSize(size int64) int64 {
+	if size < int64(HEADER_SIZE+TAG_SIZE) {
+		return max(size, 0)
+	}
+	size -= int64(HEADER_SIZE)
+	out := (size / SEALED_SIZE) * CHUNK_SIZE
+	if rem := size % SEALED_SIZE; rem > TAG_SIZE {
+		out += rem - TAG_SIZE
+	}
+	return out
+}
+
+type encryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint64
+	plain   []byte
+	sealed  []byte
+	out     []byte
+	done    bool
+}
+
+func newEncryptReader(key []byte, r io.Reader) (io.Reader, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		src:    bufio.NewReaderSize(r, CHUNK_SIZE),
+		aead:   aead,
+		nonce:  nonce,
+		plain:  make([]byte, CHUNK_SIZE),
+		sealed: make([]byte, 0, SEALED_SIZE),
+		out:    append([]byte(MAGIC), nonce...),
+	}, nil
+}
+
+func (this *encryptReader) Read(p []byte) (int, error) {
+	for len(this.out) == 0 {
+		if this.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(this.src, this.plain)
+		final := false
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			final = true
+		} else if err != nil {
+			return 0, err
+		} else if _, err = this.src.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return 0, err
+		}
+		this.out = this.aead.Seal(this.sealed[:0], chunkNonce(this.nonce, this.counter), this.plain[:n], chunkAD(final))
+		this.counter += 1
+		this.done = final
+	}
+	n := copy(p, this.out)
+	this.out = this.out[n:]
+	return n, nil
+}
+
+func readHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, HEADER_SIZE)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrDecrypt
+	} else if bytes.Equal(header[:len(MAGIC)], []byte(MAGIC)) == false {
+		return nil, ErrDecrypt
+	}
+	return header[len(MAGIC):], nil
+}
+
+type decryptReader struct {
+	src     *bufio.Reader
+	closer  io.Closer
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint64
+	sealed  []byte
+	plain   []byte
+	out     []byte
+	done    bool
+	partial bool // a range read stops wherever it was asked to, not at the end of the file
+}
+
+func newDecryptReader(key []byte, nonce []byte, r io.ReadCloser, counter uint64, partial bool) (io.ReadCloser, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		src:     bufio.NewReaderSize(r, SEALED_SIZE),
+		closer:  r,
+		aead:    aead,
+		nonce:   nonce,
+		counter: counter,
+		sealed:  make([]byte, SEALED_SIZE),
+		plain:   make([]byte, 0, CHUNK_SIZE),
+		partial: partial,
+	}, nil
+}
+
+func (this *decryptReader) Read(p []byte) (int, error) {
+	for len(this.out) == 0 {
+		if this.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(this.src, this.sealed)
+		final := false
+		if err == io.EOF {
+			if this.partial {
+				this.done = true
+				continue
+			}
+			return 0, ErrDecrypt
+		} else if err == io.ErrUnexpectedEOF {
+			final = true
+		} else if err != nil {
+			return 0, err
+		} else if _, err = this.src.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return 0, err
+		}
+		nonce := chunkNonce(this.nonce, this.counter)
+		out, err := this.aead.Open(this.plain[:0], nonce, this.sealed[:n], chunkAD(final))
+		if err != nil && this.partial && final && n == SEALED_SIZE {
+			out, err = this.aead.Open(this.plain[:0], nonce, this.sealed[:n], chunkAD(false))
+		}
+		if err != nil {
+			return 0, ErrDecrypt
+		}
+		this.out = out
+		this.counter += 1
+		this.done = final
+	}
+	n := copy(p, this.out)
+	this.out = this.out[n:]
+	return n, nil
+}
+
+func (this *decryptReader) Close() error {
+	return this.closer.Close()
+}
@@ -112,7 +112,54 @@ func (this Dav) Ls(path string) ([]os.FileInfo, error) {
 }
 
 func (this Dav) Stat(path string) (os.FileInfo, error) {
-	return nil, ErrNotImplemented
+	var uri string
+	var err error
+	var res *http.Response
+
+	p := strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/"), "/")
+	if path == "/" {
+		return File{FName: "/", FType: "directory", FSize: -1}, nil
+	} else if len(p) == 1 {
+		if _, err = this.getCollectionURI(path); err != nil {
+			return nil, err
+		}
+		return File{FName: p[0], FType: "directory", FSize: -1}, nil
+	}
+	if uri, err = this.getResourceURI(path); err != nil {
+		return nil, err
+	}
+	if res, err = this.request("PROPFIND", uri, strings.NewReader(`<?xml version="1.0" encoding="utf-8" ?>
+         <propfind xmlns="DAV:">
+           <prop>
+             <getcontentlength />
+             <getlastmodified />
+           </prop>
+         </propfind>`), func(req *http.Request) {
+		req.Header.Add("Depth", "0")
+		req.Header.Add("Content-Type", "application/xml")
+	}); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var r struct {
+		Responses []struct {
+			Size string `xml:"propstat>prop>getcontentlength"`
+			Time string `xml:"propstat>prop>getlastmodified"`
+		} `xml:"response"`
+	}
+	if err = xml.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	} else if len(r.Responses) == 0 {
+		return nil, ErrNotFound
+	}
+	file := File{FName: p[1], FType: "file", FSize: -1}
+	if size, err := strconv.ParseInt(strings.TrimSpace(r.Responses[0].Size), 10, 64); err == nil {
+		file.FSize = size
+	}
+	if t, err := http.ParseTime(strings.TrimSpace(r.Responses[0].Time)); err == nil {
+		file.FTime = t.Unix()
+	}
+	return file, nil
 }
 
 func (this Dav) Cat(path string) (io.ReadCloser, error) {
@@ -129,6 +176,22 @@ func (this Dav) Cat(path string) (io.ReadCloser, error) {
 	return res.Body, nil
 }
 
+func (this Dav) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	var uri string
+	var err error
+	var res *http.Response
+
+	if uri, err = this.getResourceURI(path); err != nil {
+		return nil, err
+	}
+	if res, err = this.request("GET", uri, nil, func(req *http.Request) {
+		req.Header.Set("Range", HTTPRange(offset, length))
+	}); err != nil {
+		return nil, err
+	}
+	return HTTPRangeBody(res, offset, length)
+}
+
 func (this Dav) Mkdir(path string) error {
 	var uri string
 	var err error
@@ -0,0 +1,131 @@
+package plg_backend_gcs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const GCS_SCOPE = "https://www.googleapis.com/auth/devstorage.read_write"
+
+var token_cache AppCache
+
+func init() {
+	token_cache = NewAppCache(60, 10)
+}
+
+// tokenSource gives the oauth tokens of a service account, or of the default credentials of the
+// machine when none is given. Tokens are reused across requests until they expire
+func tokenSource(credentials string) (oauth2.TokenSource, string, error) {
+	key := map[string]string{"credentials": credentials}
+	if obj := token_cache.Get(key); obj != nil {
+		c := obj.(*google.Credentials)
+		return c.TokenSource, c.ProjectID, nil
+	}
+	var c *google.Credentials
+	var err error
+	if credentials == "" {
+		c, err = google.FindDefaultCredentials(context.Background(), GCS_SCOPE)
+	} else {
+		var sa struct {
+			Type string `json:"type"`
+		}
+		if err = json.Unmarshal([]byte(credentials), &sa); err != nil || sa.Type == "" {
+			return nil, "", NewError("Invalid service account key", 400)
+		}
+		c, err = google.CredentialsFromJSON(context.Background(), []byte(credentials), GCS_SCOPE)
+	}
+	if err != nil {
+		Log.Debug("plg_backend_gcs::auth err=%s", err.Error())
+		return nil, "", ErrAuthenticationFailed
+	}
+	c.TokenSource = oauth2.ReuseTokenSource(nil, c.TokenSource)
+	token_cache.Set(key, c)
+	return c.TokenSource, c.ProjectID, nil
+}
+
+/*
+ * signHMAC signs a request of the XML API with an HMAC key, following the V4 signing process:
+ * https://cloud.google.com/storage/docs/authentication/signatures
+ * The payload isn't part of the signature so uploads can be streamed.
+ */
+func signHMAC(req *http.Request, accessKey string, secret string, now time.Time) {
+	now = now.UTC()
+	datetime := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Goog-Date", datetime)
+	req.Header.Set("X-Goog-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-goog-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	canonicalQuery := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			canonicalQuery = append(canonicalQuery, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := date + "/auto/storage/goog4_request"
+	h := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "GOOG4-HMAC-SHA256\n" + datetime + "\n" + scope + "\n" + hex.EncodeToString(h[:])
+
+	key := []byte("GOOG4" + secret)
+	for _, part := range []string{date, "auto", "storage", "goog4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", "GOOG4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func uriEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
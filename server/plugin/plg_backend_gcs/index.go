@@ -0,0 +1,491 @@
+package plg_backend_gcs
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"golang.org/x/oauth2"
+)
+
+/*
+ * Google Cloud Storage through its XML API, which is the one taking both kind of credentials:
+ * - a service account key or, when nothing is given, the default credentials of the machine
+ * - an HMAC key, what's otherwise used with S3 clients
+ * The endpoint can be changed to use a local fake GCS server.
+ */
+type GCS struct {
+	endpoint  string
+	project   string
+	token     oauth2.TokenSource
+	accessKey string
+	secretKey string
+	ctx       context.Context
+}
+
+func init() {
+	Backend.Register("gcs", GCS{})
+}
+
+func (this GCS) Init(params map[string]string, app *App) (IBackend, error) {
+	backend := &GCS{
+		endpoint: strings.TrimSuffix(params["endpoint"], "/"),
+		project:  params["project_id"],
+		ctx:      app.Context,
+	}
+	if backend.endpoint == "" {
+		backend.endpoint = "https://storage.googleapis.com"
+	}
+	if backend.ctx == nil {
+		backend.ctx = context.Background()
+	}
+	if params["access_key_id"] != "" || params["secret_access_key"] != "" {
+		backend.accessKey = params["access_key_id"]
+		backend.secretKey = params["secret_access_key"]
+	} else if params["credentials"] != "" || params["endpoint"] == "" {
+		token, project, err := tokenSource(params["credentials"])
+		if err != nil {
+			return nil, err
+		}
+		backend.token = token
+		if backend.project == "" {
+			backend.project = project
+		}
+	}
+	return backend, nil
+}
+
+func (this GCS) LoginForm() Form {
+	return Form{
+		Elmnts: []FormElement{
+			FormElement{
+				Name:  "type",
+				Type:  "hidden",
+				Value: "gcs",
+			},
+			FormElement{
+				Name:        "credentials",
+				Type:        "long_password",
+				Placeholder: "Service Account Key (JSON)",
+			},
+			FormElement{
+				Name:        "advanced",
+				Type:        "enable",
+				Placeholder: "Advanced",
+				Target: []string{
+					"gcs_access_key_id", "gcs_secret_access_key", "gcs_project_id",
+					"gcs_endpoint", "gcs_path",
+				},
+			},
+			FormElement{
+				Id:          "gcs_access_key_id",
+				Name:        "access_key_id",
+				Type:        "text",
+				Placeholder: "HMAC Access Key",
+			},
+			FormElement{
+				Id:          "gcs_secret_access_key",
+				Name:        "secret_access_key",
+				Type:        "password",
+				Placeholder: "HMAC Secret",
+			},
+			FormElement{
+				Id:          "gcs_project_id",
+				Name:        "project_id",
+				Type:        "text",
+				Placeholder: "Project ID",
+			},
+			FormElement{
+				Id:          "gcs_endpoint",
+				Name:        "endpoint",
+				Type:        "text",
+				Placeholder: "Endpoint",
+			},
+			FormElement{
+				Id:          "gcs_path",
+				Name:        "path",
+				Type:        "text",
+				Placeholder: "Path",
+			},
+		},
+	}
+}
+
+func (this GCS) Meta(path string) Metadata {
+	if path == "/" {
+		return Metadata{
+			CanCreateFile: NewBool(false),
+			CanRename:     NewBool(false),
+			CanMove:       NewBool(false),
+			CanUpload:     NewBool(false),
+		}
+	}
+	return Metadata{}
+}
+
+func (this GCS) Ls(path string) ([]os.FileInfo, error) {
+	p := this.path(path)
+	files := make([]os.FileInfo, 0)
+	if p.bucket == "" {
+		buckets, err := this.buckets()
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range buckets {
+			files = append(files, File{
+				FName: b.Name,
+				FType: "directory",
+				FTime: b.CreationDate.Unix(),
+			})
+		}
+		return files, nil
+	}
+	err := this.list(p.bucket, p.path, "/", func(res listResult) error {
+		for _, object := range res.Contents {
+			if object.Key == p.path {
+				continue
+			}
+			files = append(files, File{
+				FName: filepath.Base(object.Key),
+				FType: "file",
+				FTime: object.LastModified.Unix(),
+				FSize: object.Size,
+			})
+		}
+		for _, prefix := range res.CommonPrefixes {
+			files = append(files, File{
+				FName: filepath.Base(prefix.Prefix),
+				FType: "directory",
+			})
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (this GCS) Stat(path string) (os.FileInfo, error) {
+	p := this.path(path)
+	if p.bucket == "" {
+		return File{FName: "/", FType: "directory"}, nil
+	} else if p.path == "" {
+		if err := this.close(this.request("GET", p.bucket, "", url.Values{"max-keys": {"1"}}, nil, nil)); err != nil {
+			return nil, err
+		}
+		return File{FName: p.bucket, FType: "directory"}, nil
+	} else if IsDirectory(path) {
+		return File{FName: filepath.Base(path), FType: "directory"}, nil
+	}
+	res, err := this.request("HEAD", p.bucket, p.path, nil, nil, nil)
+	if err == ErrNotFound {
+		// objects are flat, a folder is a prefix at least one object starts with
+		found := false
+		err = this.list(p.bucket, p.path+"/", "/", func(res listResult) error {
+			found = len(res.Contents) > 0 || len(res.CommonPrefixes) > 0
+			return io.EOF
+		})
+		if err != nil && err != io.EOF {
+			return nil, err
+		} else if found == false {
+			return nil, ErrNotFound
+		}
+		return File{FName: filepath.Base(path), FType: "directory"}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	mtime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return File{
+		FName: filepath.Base(path),
+		FType: "file",
+		FSize: res.ContentLength,
+		FTime: mtime.Unix(),
+	}, nil
+}
+
+func (this GCS) Cat(path string) (io.ReadCloser, error) {
+	p := this.path(path)
+	res, err := this.request("GET", p.bucket, p.path, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (this GCS) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	p := this.path(path)
+	res, err := this.request("GET", p.bucket, p.path, nil, nil, func(req *http.Request) {
+		req.Header.Set("Range", HTTPRange(offset, length))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return HTTPRangeBody(res, offset, length)
+}
+
+func (this GCS) Mkdir(path string) error {
+	p := this.path(path)
+	if p.bucket == "" {
+		return ErrNotValid
+	} else if p.path == "" {
+		return this.close(this.request("PUT", p.bucket, "", nil, nil, func(req *http.Request) {
+			if this.project != "" {
+				req.Header.Set("X-Goog-Project-Id", this.project)
+			}
+		}))
+	}
+	return this.put(p.bucket, EnforceDirectory(p.path))
+}
+
+func (this GCS) Rm(path string) error {
+	p := this.path(path)
+	if p.bucket == "" {
+		return ErrNotValid
+	} else if IsDirectory(path) == false {
+		return this.close(this.request("DELETE", p.bucket, p.path, nil, nil, nil))
+	}
+	err := this.list(p.bucket, p.path, "", func(res listResult) error {
+		for _, object := range res.Contents {
+			if err := this.close(this.request("DELETE", p.bucket, object.Key, nil, nil, nil)); err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	} else if p.path == "" {
+		return this.close(this.request("DELETE", p.bucket, "", nil, nil, nil))
+	}
+	return nil
+}
+
+func (this GCS) Mv(from string, to string) error {
+	f := this.path(from)
+	t := this.path(to)
+	if from == to {
+		return nil
+	} else if f.path == "" || t.path == "" {
+		return ErrNotImplemented
+	} else if IsDirectory(from) == false {
+		return this.move(f.bucket, f.path, t.bucket, t.path)
+	}
+	return this.list(f.bucket, f.path, "", func(res listResult) error {
+		for _, object := range res.Contents {
+			if err := this.move(f.bucket, object.Key, t.bucket, t.path+strings.TrimPrefix(object.Key, f.path)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// move copies an object on the server side before removing the original
+func (this GCS) move(fromBucket string, fromKey string, toBucket string, toKey string) error {
+	source := "/" + fromBucket + "/" + escape(fromKey)
+	if err := this.close(this.request("PUT", toBucket, toKey, nil, nil, func(req *http.Request) {
+		req.Header.Set("X-Goog-Copy-Source", source)
+	})); err != nil {
+		return err
+	}
+	return this.close(this.request("DELETE", fromBucket, fromKey, nil, nil, nil))
+}
+
+func (this GCS) Touch(path string) error {
+	p := this.path(path)
+	if p.bucket == "" || p.path == "" {
+		return ErrNotValid
+	}
+	return this.put(p.bucket, p.path)
+}
+
+func (this GCS) put(bucket string, key string) error {
+	return this.close(this.request("PUT", bucket, key, nil, http.NoBody, func(req *http.Request) {
+		req.ContentLength = 0
+		req.Header.Set("Content-Type", GetMimeType(key))
+	}))
+}
+
+type bucketResult struct {
+	Name         string    `xml:"Name"`
+	CreationDate time.Time `xml:"CreationDate"`
+}
+
+func (this GCS) buckets() ([]bucketResult, error) {
+	res, err := this.request("GET", "", "", nil, nil, func(req *http.Request) {
+		if this.project != "" {
+			req.Header.Set("X-Goog-Project-Id", this.project)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var result struct {
+		Buckets []bucketResult `xml:"Buckets>Bucket"`
+	}
+	if err = xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Buckets, nil
+}
+
+type listResult struct {
+	IsTruncated bool   `xml:"IsTruncated"`
+	NextMarker  string `xml:"NextMarker"`
+	Contents    []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// list goes through every page of a listing, a folder when a delimiter is given and everything
+// under the prefix otherwise
+func (this GCS) list(bucket string, prefix string, delimiter string, fn func(listResult) error) error {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	for {
+		res, err := this.request("GET", bucket, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		var result listResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return err
+		} else if err = fn(result); err != nil {
+			return err
+		} else if result.IsTruncated == false || result.NextMarker == "" {
+			return nil
+		}
+		query.Set("marker", result.NextMarker)
+	}
+}
+
+func (this GCS) request(method string, bucket string, key string, query url.Values, body io.Reader, fn func(req *http.Request)) (*http.Response, error) {
+	u := this.endpoint + "/"
+	if bucket != "" {
+		u += url.PathEscape(bucket)
+		if key != "" {
+			u += "/" + escape(key)
+		}
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(this.ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Filestash "+APP_VERSION+"."+BUILD_DATE)
+	if fn != nil {
+		fn(req)
+	}
+	if err = this.authorize(req); err != nil {
+		return nil, err
+	}
+	res, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return res, this.error(res)
+}
+
+func (this GCS) authorize(req *http.Request) error {
+	if this.accessKey != "" {
+		signHMAC(req, this.accessKey, this.secretKey, time.Now())
+	} else if this.token != nil {
+		token, err := this.token.Token()
+		if err != nil {
+			Log.Debug("plg_backend_gcs::authorize err=%s", err.Error())
+			return ErrAuthenticationFailed
+		}
+		token.SetAuthHeader(req)
+	}
+	return nil
+}
+
+// error turns the status of a response into one of our errors, closing the body when it's one
+func (this GCS) error(res *http.Response) error {
+	if res.StatusCode < 400 {
+		return nil
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrAuthenticationFailed
+	case http.StatusForbidden:
+		return ErrNotAllowed
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrConflict
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return ErrCongestion
+	}
+	var gcsErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(res.Body, 64*1024)).Decode(&gcsErr); err == nil && gcsErr.Message != "" {
+		return NewError(gcsErr.Message, res.StatusCode)
+	}
+	return NewError(HTTPFriendlyStatus(res.StatusCode), res.StatusCode)
+}
+
+func (this GCS) close(res *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, res.Body)
+	return res.Body.Close()
+}
+
+type GCSPath struct {
+	bucket string
+	path   string
+}
+
+func (this GCS) path(p string) GCSPath {
+	sp := strings.Split(p, "/")
+	bucket := ""
+	if len(sp) > 1 {
+		bucket = sp[1]
+	}
+	path := ""
+	if len(sp) > 2 {
+		path = strings.Join(sp[2:], "/")
+	}
+	return GCSPath{bucket, path}
+}
+
+func escape(key string) string {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+func parseInt(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
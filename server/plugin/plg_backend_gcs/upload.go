@@ -0,0 +1,154 @@
+package plg_backend_gcs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// UPLOAD_CHUNK is what's sent per request of a resumable upload, GCS wants a multiple of 256KiB
+const (
+	UPLOAD_CHUNK   = 8 * 1024 * 1024
+	UPLOAD_RETRIES = 3
+)
+
+/*
+ * Save streams the file through a resumable upload: the upload is opened first, then the content
+ * goes in chunks, each chunk being sent again from where GCS says it stopped if it didn't make it
+ * entirely. Only a chunk is kept in memory, whatever the size of the file
+ */
+func (this GCS) Save(path string, file io.Reader) error {
+	p := this.path(path)
+	if p.bucket == "" || p.path == "" {
+		return ErrNotValid
+	}
+	res, err := this.request("POST", p.bucket, p.path, nil, http.NoBody, func(req *http.Request) {
+		req.ContentLength = 0
+		req.Header.Set("Content-Type", GetMimeType(path))
+		req.Header.Set("X-Goog-Resumable", "start")
+	})
+	if err != nil {
+		return err
+	}
+	this.close(res, nil)
+	session := res.Header.Get("Location")
+	if session == "" {
+		return NewError("No upload session", 502)
+	}
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	buf := make([]byte, UPLOAD_CHUNK)
+	var offset int64
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			this.cancel(session)
+			return err
+		}
+		last := n < UPLOAD_CHUNK
+		if last == false {
+			if _, err := reader.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		if err = this.chunk(session, buf[:n], offset, last); err != nil {
+			this.cancel(session)
+			return err
+		}
+		offset += int64(n)
+		if last {
+			return nil
+		}
+	}
+}
+
+// chunk sends a part of the file, the last one telling GCS the total size
+func (this GCS) chunk(session string, data []byte, offset int64, last bool) error {
+	sent := 0
+	for try := 0; try < UPLOAD_RETRIES; try++ {
+		start := offset + int64(sent)
+		total := "*"
+		if last {
+			total = fmt.Sprintf("%d", offset+int64(len(data)))
+		}
+		contentRange := fmt.Sprintf("bytes %d-%d/%s", start, offset+int64(len(data))-1, total)
+		if sent == len(data) {
+			contentRange = "bytes */" + total
+		}
+		req, err := http.NewRequestWithContext(this.ctx, "PUT", session, bytes.NewReader(data[sent:]))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = int64(len(data) - sent)
+		req.Header.Set("Content-Range", contentRange)
+		res, err := HTTPClient.Do(req)
+		if err != nil {
+			Log.Debug("plg_backend_gcs::chunk offset=%d err=%s", start, err.Error())
+			if committed, ok := this.committed(session); ok && committed >= offset {
+				sent = int(min(committed-offset, int64(len(data))))
+			}
+			continue
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK || res.StatusCode == http.StatusCreated {
+			return nil
+		} else if res.StatusCode != http.StatusPermanentRedirect {
+			return this.error(res)
+		}
+		committed := rangeEnd(res.Header.Get("Range"))
+		if committed >= offset+int64(len(data)) && last == false {
+			return nil
+		} else if committed < offset {
+			return NewError("Upload went out of sync", 502)
+		}
+		sent = int(committed - offset)
+	}
+	return NewError("Upload failed", 502)
+}
+
+// committed asks GCS how much of the file it has, after a chunk failed midway
+func (this GCS) committed(session string) (int64, bool) {
+	req, err := http.NewRequestWithContext(this.ctx, "PUT", session, http.NoBody)
+	if err != nil {
+		return 0, false
+	}
+	req.ContentLength = 0
+	req.Header.Set("Content-Range", "bytes */*")
+	res, err := HTTPClient.Do(req)
+	if err != nil {
+		return 0, false
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusPermanentRedirect {
+		return 0, false
+	}
+	return rangeEnd(res.Header.Get("Range")), true
+}
+
+func (this GCS) cancel(session string) {
+	req, err := http.NewRequestWithContext(this.ctx, "DELETE", session, nil)
+	if err != nil {
+		return
+	}
+	if res, err := HTTPClient.Do(req); err == nil {
+		res.Body.Close()
+	}
+}
+
+// rangeEnd gives how many bytes a "Range: bytes=0-N" header says were received
+func rangeEnd(header string) int64 {
+	if header == "" {
+		return 0
+	}
+	parts := strings.SplitN(strings.TrimPrefix(header, "bytes="), "-", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+	return parseInt(parts[1]) + 1
+}
@@ -0,0 +1,78 @@
+package plg_backend_git
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	. "github.com/mickael-kerjean/filestash/server/ctrl"
+	. "github.com/mickael-kerjean/filestash/server/middleware"
+	"github.com/mickael-kerjean/filestash/server/model"
+
+	"github.com/gorilla/mux"
+)
+
+const LOG_MAX = 1000
+
+func init() {
+	Hooks.Register.HttpEndpoint(func(r *mux.Router) error {
+		r.HandleFunc(WithBase("/api/git/log"), NewMiddlewareChain(
+			LogHandler,
+			[]Middleware{ApiHeaders, SecureHeaders, SessionStart, LoggedInOnly},
+		)).Methods("GET")
+		return nil
+	})
+}
+
+// LogHandler gives the commits touching a path, each with the path where the file can be
+// downloaded as it was at that commit
+func LogHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if model.CanRead(ctx) == false {
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	path, err := PathBuilder(ctx, req.URL.Query().Get("path"))
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if IsDirectory(path) {
+			err = auth.Ls(ctx, path)
+		} else {
+			err = auth.Cat(ctx, path)
+		}
+		if err != nil {
+			Log.Info("plg_backend_git::log auth '%s'", err.Error())
+			SendErrorResult(res, ErrNotAuthorized)
+			return
+		}
+	}
+	g, ok := ctx.Backend.(interface {
+		Log(path string, limit int) ([]Commit, error)
+	})
+	if ok == false {
+		SendErrorResult(res, NewError("Not a git repository", 400))
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(req.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = min(l, LOG_MAX)
+	}
+	commits, err := g.Log(path, limit)
+	if err != nil {
+		Log.Debug("plg_backend_git::log path=%s err=%s", path, err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	sessionPath := strings.TrimSuffix(ctx.Session["path"], "/")
+	for i := range commits {
+		if strings.HasPrefix(commits[i].Path, sessionPath+"/") {
+			commits[i].Path = strings.TrimPrefix(commits[i].Path, sessionPath)
+		} else {
+			commits[i].Path = ""
+		}
+	}
+	SendSuccessResult(res, commits)
+}
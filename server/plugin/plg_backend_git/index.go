@@ -46,6 +46,7 @@ type GitParams struct {
 	committerName  string
 	committerEmail string
 	basePath       string
+	history        bool
 }
 
 func (git Git) Init(params map[string]string, app *App) (IBackend, error) {
@@ -66,6 +67,7 @@ func (git Git) Init(params map[string]string, app *App) (IBackend, error) {
 				params["committerName"],
 				params["committerEmail"],
 				"",
+				params["history"] == "true",
 			},
 		},
 	}
@@ -134,7 +136,7 @@ func (g Git) LoginForm() Form {
 				Target: []string{
 					"git_path", "git_passphrase", "git_commit",
 					"git_branch", "git_author_email", "git_author_name",
-					"git_committer_email", "git_committer_name",
+					"git_committer_email", "git_committer_name", "git_history",
 				},
 			},
 			{
@@ -185,12 +187,22 @@ func (g Git) LoginForm() Form {
 				Type:        "text",
 				Placeholder: "Committer name",
 			},
+			{
+				Id:          "git_history",
+				Name:        "history",
+				Type:        "boolean",
+				Placeholder: "History",
+				Description: "Clone the whole repository to browse its branches, tags and past commits",
+			},
 		},
 	}
 }
 
 func (g Git) Ls(path string) ([]os.FileInfo, error) {
 	g.git.refresh()
+	if isHistory(path) {
+		return g.historyLs(path)
+	}
 	p, err := g.path(path)
 	if err != nil {
 		return nil, NewError(err.Error(), 403)
@@ -204,11 +216,17 @@ func (g Git) Ls(path string) ([]os.FileInfo, error) {
 		f.Close()
 		return nil, err
 	}
+	if path == "/" && g.git.repo != nil && g.git.params.history {
+		files = append(files, historyRoot()...)
+	}
 	return files, f.Close()
 }
 
 func (g Git) Stat(path string) (os.FileInfo, error) {
 	g.git.refresh()
+	if isHistory(path) {
+		return g.historyStat(path)
+	}
 	p, err := g.path(path)
 	if err != nil {
 		return nil, NewError(err.Error(), 403)
@@ -226,6 +244,9 @@ func (g Git) Stat(path string) (os.FileInfo, error) {
 }
 
 func (g Git) Cat(path string) (io.ReadCloser, error) {
+	if isHistory(path) {
+		return g.historyCat(path)
+	}
 	p, err := g.path(path)
 	if err != nil {
 		return nil, NewError(err.Error(), 403)
@@ -234,6 +255,9 @@ func (g Git) Cat(path string) (io.ReadCloser, error) {
 }
 
 func (g Git) Mkdir(path string) error {
+	if isHistory(path) {
+		return ErrNotAllowed
+	}
 	p, err := g.path(path)
 	if err != nil {
 		return NewError(err.Error(), 403)
@@ -242,6 +266,9 @@ func (g Git) Mkdir(path string) error {
 }
 
 func (g Git) Rm(path string) error {
+	if isHistory(path) {
+		return ErrNotAllowed
+	}
 	p, err := g.path(path)
 	if err != nil {
 		return NewError(err.Error(), 403)
@@ -257,6 +284,9 @@ func (g Git) Rm(path string) error {
 }
 
 func (g Git) Mv(from string, to string) error {
+	if isHistory(from) || isHistory(to) {
+		return ErrNotAllowed
+	}
 	fpath, err := g.path(from)
 	if err != nil {
 		return NewError(err.Error(), 403)
@@ -277,6 +307,9 @@ func (g Git) Mv(from string, to string) error {
 }
 
 func (g Git) Touch(path string) error {
+	if isHistory(path) {
+		return ErrNotAllowed
+	}
 	p, err := g.path(path)
 	if err != nil {
 		return NewError(err.Error(), 403)
@@ -295,6 +328,9 @@ func (g Git) Touch(path string) error {
 }
 
 func (g Git) Save(path string, file io.Reader) error {
+	if isHistory(path) {
+		return ErrNotAllowed
+	}
 	p, err := g.path(path)
 	if err != nil {
 		return NewError(err.Error(), 403)
@@ -345,13 +381,18 @@ func (g *GitLib) open(params *GitParams, path string) (*git.Repository, error) {
 		if err != nil {
 			return nil, err
 		}
-		g, err := git.PlainClone(path, &git.CloneOptions{
+		// only the tip of the branch is needed unless the history is browsed
+		opts := &git.CloneOptions{
 			URL:           g.params.repo,
-			Depth:         1,
 			ReferenceName: plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", g.params.branch)),
-			SingleBranch:  true,
 			Auth:          auth,
-		})
+			Depth:         1,
+			SingleBranch:  true,
+		}
+		if g.params.history {
+			opts.Depth, opts.SingleBranch = 0, false
+		}
+		g, err := git.PlainClone(path, opts)
 		if err == transport.ErrEmptyRemoteRepository {
 			return g, nil
 		}
@@ -0,0 +1,367 @@
+package plg_backend_git
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * Past versions of the repository are exposed as read-only folders which are read straight from
+ * the object store, without touching the working tree:
+ *   /.refs/branches/<branch>/   the tip of a branch
+ *   /.refs/tags/<tag>/          what a tag points to
+ *   /.history/<commit>/         any commit, the folder lists the most recent ones
+ * A branch named like "feature/login" is seen as a "login" folder under a "feature" folder.
+ * Those are only there when the connection asks for the history, the repository being otherwise
+ * cloned with only the tip of its branch.
+ */
+const (
+	REFS_DIR    = "/.refs/"
+	HISTORY_DIR = "/.history/"
+	HISTORY_MAX = 200
+)
+
+func isHistory(path string) bool {
+	return strings.HasPrefix(path+"/", REFS_DIR) || strings.HasPrefix(path+"/", HISTORY_DIR)
+}
+
+func historyRoot() []os.FileInfo {
+	return []os.FileInfo{
+		File{FName: strings.Trim(REFS_DIR, "/"), FType: "directory"},
+		File{FName: strings.Trim(HISTORY_DIR, "/"), FType: "directory"},
+	}
+}
+
+// revision is where a path of the virtual tree points to. When commit is nil, the path is one of
+// the folders leading to it and names are what it contains
+type revision struct {
+	commit *object.Commit
+	path   string
+	names  []string
+}
+
+func (g *GitLib) resolve(path string) (*revision, error) {
+	if g.repo == nil || g.params.history == false {
+		return nil, ErrNotFound
+	}
+	if strings.HasPrefix(path+"/", HISTORY_DIR) {
+		segments := split(strings.TrimPrefix(path+"/", HISTORY_DIR))
+		if len(segments) == 0 {
+			names, err := g.recent()
+			return &revision{names: names}, err
+		}
+		hash, err := g.repo.ResolveRevision(plumbing.Revision(segments[0]))
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		commit, err := g.repo.CommitObject(*hash)
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		return &revision{commit: commit, path: strings.Join(segments[1:], "/")}, nil
+	}
+
+	segments := split(strings.TrimPrefix(path+"/", REFS_DIR))
+	if len(segments) == 0 {
+		return &revision{names: []string{"branches", "tags"}}, nil
+	}
+	var refs map[string]plumbing.Hash
+	var err error
+	switch segments[0] {
+	case "branches":
+		refs, err = g.branches()
+	case "tags":
+		refs, err = g.tags()
+	default:
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	segments = segments[1:]
+	for i := 1; i <= len(segments); i++ {
+		hash, ok := refs[strings.Join(segments[:i], "/")]
+		if ok == false {
+			continue
+		}
+		commit, err := g.commit(hash)
+		if err != nil {
+			return nil, err
+		}
+		return &revision{commit: commit, path: strings.Join(segments[i:], "/")}, nil
+	}
+	prefix := strings.Join(segments, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	seen := map[string]bool{}
+	names := []string{}
+	for name := range refs {
+		if strings.HasPrefix(name, prefix) == false {
+			continue
+		}
+		name = strings.SplitN(strings.TrimPrefix(name, prefix), "/", 2)[0]
+		if seen[name] == false {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, ErrNotFound
+	}
+	sort.Strings(names)
+	return &revision{names: names}, nil
+}
+
+func (g *GitLib) branches() (map[string]plumbing.Hash, error) {
+	refs, err := g.repo.References()
+	if err != nil {
+		return nil, err
+	}
+	branches := map[string]plumbing.Hash{}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		} else if ref.Name().IsRemote() {
+			name := strings.TrimPrefix(ref.Name().String(), "refs/remotes/origin/")
+			if name != ref.Name().String() && name != "HEAD" {
+				branches[name] = ref.Hash()
+			}
+		} else if ref.Name().IsBranch() {
+			if _, ok := branches[ref.Name().Short()]; !ok {
+				branches[ref.Name().Short()] = ref.Hash()
+			}
+		}
+		return nil
+	})
+	return branches, err
+}
+
+func (g *GitLib) tags() (map[string]plumbing.Hash, error) {
+	refs, err := g.repo.Tags()
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]plumbing.Hash{}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		tags[ref.Name().Short()] = ref.Hash()
+		return nil
+	})
+	return tags, err
+}
+
+// commit gives the commit a reference points to, annotated tags point to a tag object first
+func (g *GitLib) commit(hash plumbing.Hash) (*object.Commit, error) {
+	if tag, err := g.repo.TagObject(hash); err == nil {
+		return tag.Commit()
+	}
+	return g.repo.CommitObject(hash)
+}
+
+func (g *GitLib) recent() ([]string, error) {
+	head, err := g.repo.Head()
+	if err != nil {
+		return []string{}, nil
+	}
+	iter, err := g.repo.Log(&git.LogOptions{From: head.Hash(), Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	names := []string{}
+	for len(names) < HISTORY_MAX {
+		c, err := iter.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, c.Hash.String())
+	}
+	return names, nil
+}
+
+func (g Git) historyLs(path string) ([]os.FileInfo, error) {
+	rev, err := g.git.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []os.FileInfo{}
+	if rev.commit == nil {
+		for _, name := range rev.names {
+			f := File{FName: name, FType: "directory"}
+			if strings.HasPrefix(path, HISTORY_DIR) {
+				if c, err := g.git.repo.CommitObject(plumbing.NewHash(name)); err == nil {
+					f.FTime = c.Committer.When.Unix()
+				}
+			}
+			files = append(files, f)
+		}
+		return files, nil
+	}
+	tree, err := rev.commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if rev.path != "" {
+		if tree, err = tree.Tree(rev.path); err != nil {
+			return nil, ErrNotFound
+		}
+	}
+	for _, entry := range tree.Entries {
+		if entry.Mode == filemode.Submodule {
+			continue
+		}
+		f := File{FName: entry.Name, FType: "file", FTime: rev.commit.Committer.When.Unix()}
+		if entry.Mode == filemode.Dir {
+			f.FType = "directory"
+		} else if size, err := g.git.repo.Storer.EncodedObjectSize(entry.Hash); err == nil {
+			f.FSize = size
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func (g Git) historyStat(path string) (os.FileInfo, error) {
+	rev, err := g.git.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	name := split(path)[len(split(path))-1]
+	if rev.commit == nil || rev.path == "" {
+		f := File{FName: name, FType: "directory"}
+		if rev.commit != nil {
+			f.FTime = rev.commit.Committer.When.Unix()
+		}
+		return f, nil
+	}
+	tree, err := rev.commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := tree.FindEntry(rev.path)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	f := File{FName: name, FType: "file", FTime: rev.commit.Committer.When.Unix()}
+	if entry.Mode == filemode.Dir {
+		f.FType = "directory"
+	} else if size, err := g.git.repo.Storer.EncodedObjectSize(entry.Hash); err == nil {
+		f.FSize = size
+	}
+	return f, nil
+}
+
+func (g Git) historyCat(path string) (io.ReadCloser, error) {
+	rev, err := g.git.resolve(path)
+	if err != nil {
+		return nil, err
+	} else if rev.commit == nil || rev.path == "" {
+		return nil, ErrNotValid
+	}
+	file, err := rev.commit.File(rev.path)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return file.Reader()
+}
+
+type Commit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+	Path    string    `json:"path,omitempty"`
+}
+
+// Log lists the commits which changed a file or anything in a folder, most recent first. For a
+// path of the virtual tree, it's the history leading to that version
+func (g Git) Log(path string, limit int) ([]Commit, error) {
+	if g.git.repo == nil {
+		return nil, ErrNotFound
+	}
+	opts := &git.LogOptions{Order: git.LogOrderCommitterTime}
+	target := strings.Trim(path, "/")
+	if isHistory(path) {
+		rev, err := g.git.resolve(path)
+		if err != nil {
+			return nil, err
+		} else if rev.commit == nil {
+			return nil, ErrNotValid
+		}
+		opts.From = rev.commit.Hash
+		target = strings.Trim(rev.path, "/")
+	} else {
+		g.git.refresh()
+		head, err := g.git.repo.Head()
+		if err != nil {
+			return []Commit{}, nil
+		}
+		opts.From = head.Hash()
+	}
+	if target != "" {
+		opts.PathFilter = func(p string) bool {
+			return p == target || strings.HasPrefix(p, target+"/")
+		}
+	}
+	iter, err := g.git.repo.Log(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	commits := []Commit{}
+	for len(commits) < limit {
+		c, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			Log.Debug("plg_backend_git::log path=%s err=%s", path, err.Error())
+			break
+		}
+		commit := Commit{
+			Hash:    c.Hash.String(),
+			Author:  c.Author.Name,
+			Email:   c.Author.Email,
+			Date:    c.Author.When,
+			Message: strings.TrimSpace(c.Message),
+		}
+		if target != "" && strings.HasSuffix(path, "/") == false {
+			commit.Path = HISTORY_DIR + c.Hash.String() + "/" + target
+		}
+		commits = append(commits, commit)
+	}
+	return commits, nil
+}
+
+func (g Git) Meta(path string) Metadata {
+	if isHistory(path) == false {
+		return Metadata{}
+	}
+	return Metadata{
+		CanCreateDirectory: NewBool(false),
+		CanCreateFile:      NewBool(false),
+		CanRename:          NewBool(false),
+		CanMove:            NewBool(false),
+		CanDelete:          NewBool(false),
+		CanUpload:          NewBool(false),
+	}
+}
+
+func split(path string) []string {
+	segments := []string{}
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
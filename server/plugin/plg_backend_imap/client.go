@@ -0,0 +1,470 @@
+package plg_backend_imap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * client speaks just enough IMAP4rev1 (RFC 3501) for the backend: commands are sent one at a time
+ * and the untagged responses they trigger are given back parsed, a value being either a string,
+ * a []byte for literals, nil for NIL or a list of those
+ */
+type client struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	tag      int
+	caps     map[string]bool
+	delim    string
+	selected string
+	writable bool
+	validity string
+	broken   bool
+}
+
+type response struct {
+	tag    string
+	status string
+	text   string
+	fields []any
+}
+
+// literal is an argument which has to be sent as a literal, like the content of a message
+type literal []byte
+
+// MAX_MESSAGE_SIZE is the largest literal we hold in memory, in both directions
+const MAX_MESSAGE_SIZE = 64 * 1024 * 1024
+
+var ErrMessageTooLarge = NewError("Message is too large", 413)
+
+func dial(hostname string, port string, security string, username string, password string) (*client, error) {
+	if port == "" {
+		port = "993"
+		if security != "" && security != "tls" {
+			port = "143"
+		}
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	addr := net.JoinHostPort(hostname, port)
+	var conn net.Conn
+	var err error
+	if security == "" || security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: hostname})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		Log.Debug("plg_backend_imap::dial addr=%s err=%s", addr, err.Error())
+		return nil, ErrNotReachable
+	}
+	c := &client{conn: conn, r: bufio.NewReader(conn), caps: map[string]bool{}}
+	greeting, err := c.read()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	} else if greeting.status != "OK" && greeting.status != "PREAUTH" {
+		conn.Close()
+		return nil, ErrNotReachable
+	}
+	if security == "starttls" {
+		if _, err = c.execute("STARTTLS"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.conn = tls.Client(conn, &tls.Config{ServerName: hostname})
+		c.r = bufio.NewReader(c.conn)
+	}
+	if greeting.status != "PREAUTH" {
+		if _, err = c.execute("LOGIN", astring(username), astring(password)); err != nil {
+			c.close()
+			return nil, ErrAuthenticationFailed
+		}
+	}
+	res, err := c.execute("CAPABILITY")
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	for _, r := range res {
+		if len(r.fields) > 0 && r.fields[0] == "CAPABILITY" {
+			for _, f := range r.fields[1:] {
+				c.caps[strings.ToUpper(str(f))] = true
+			}
+		}
+	}
+	if res, err = c.execute("LIST", `""`, `""`); err != nil {
+		c.close()
+		return nil, err
+	}
+	c.delim = "/"
+	for _, r := range res {
+		if len(r.fields) > 2 && r.fields[0] == "LIST" && r.fields[2] != nil {
+			c.delim = str(r.fields[2])
+		}
+	}
+	return c, nil
+}
+
+func (c *client) close() error {
+	c.execute("LOGOUT")
+	return c.conn.Close()
+}
+
+// execute runs a command and gives its untagged responses, a NO or BAD being an error
+func (c *client) execute(args ...any) ([]*response, error) {
+	if c.broken {
+		return nil, ErrNotReachable
+	}
+	c.tag += 1
+	tag := fmt.Sprintf("A%d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	res, untagged, err := c.send(tag, args)
+	if err != nil {
+		Log.Debug("plg_backend_imap::execute cmd=%s err=%s", str(args[0]), err.Error())
+		c.broken = true
+		if err == ErrMessageTooLarge {
+			return nil, err
+		}
+		return nil, ErrNotReachable
+	}
+	if res.status != "OK" {
+		Log.Debug("plg_backend_imap::execute cmd=%s status=%s text=%s", str(args[0]), res.status, res.text)
+		if strings.Contains(res.text, "[NONEXISTENT]") || strings.Contains(res.text, "[TRYCREATE]") {
+			return nil, ErrNotFound
+		} else if strings.Contains(res.text, "[ALREADYEXISTS]") {
+			return nil, ErrConflict
+		}
+		return nil, NewError(strings.TrimSpace(res.text), 400)
+	}
+	return untagged, nil
+}
+
+func (c *client) send(tag string, args []any) (*response, []*response, error) {
+	w := bytes.NewBufferString(tag)
+	for _, arg := range args {
+		w.WriteString(" ")
+		l, ok := arg.(literal)
+		if ok == false {
+			w.WriteString(arg.(string))
+			continue
+		}
+		fmt.Fprintf(w, "{%d}\r\n", len(l))
+		if _, err := c.conn.Write(w.Bytes()); err != nil {
+			return nil, nil, err
+		}
+		w.Reset()
+		for {
+			res, err := c.read()
+			if err != nil {
+				return nil, nil, err
+			} else if res.tag == "+" {
+				break
+			} else if res.tag == tag {
+				return res, nil, nil
+			}
+		}
+		w.Write(l)
+	}
+	w.WriteString("\r\n")
+	if _, err := c.conn.Write(w.Bytes()); err != nil {
+		return nil, nil, err
+	}
+	untagged := []*response{}
+	for {
+		res, err := c.read()
+		if err != nil {
+			return nil, nil, err
+		} else if res.tag == tag {
+			return res, untagged, nil
+		} else if res.tag == "*" {
+			untagged = append(untagged, res)
+		}
+	}
+}
+
+// read parses a response: a status with its text or some data
+func (c *client) read() (*response, error) {
+	tag, err := c.atom()
+	if err != nil {
+		return nil, err
+	}
+	res := &response{tag: tag}
+	if tag == "+" {
+		res.text, err = c.line()
+		return res, err
+	}
+	if err = c.space(); err != nil {
+		return nil, err
+	}
+	word, err := c.atom()
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToUpper(word) {
+	case "OK", "NO", "BAD", "BYE", "PREAUTH":
+		res.status = strings.ToUpper(word)
+		res.text, err = c.line()
+		return res, err
+	}
+	res.fields = []any{strings.ToUpper(word)}
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		} else if b == '\r' {
+			_, err = c.r.ReadByte()
+			return res, err
+		} else if b == '\n' {
+			return res, nil
+		} else if b == ' ' {
+			continue
+		}
+		c.r.UnreadByte()
+		v, err := c.value()
+		if err != nil {
+			return nil, err
+		}
+		res.fields = append(res.fields, v)
+	}
+}
+
+func (c *client) value() (any, error) {
+	b, err := c.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch b {
+	case '(':
+		list := []any{}
+		for {
+			b, err := c.r.ReadByte()
+			if err != nil {
+				return nil, err
+			} else if b == ')' {
+				return list, nil
+			} else if b == ' ' {
+				continue
+			}
+			c.r.UnreadByte()
+			v, err := c.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case '"':
+		var s strings.Builder
+		for {
+			b, err := c.r.ReadByte()
+			if err != nil {
+				return nil, err
+			} else if b == '\\' {
+				if b, err = c.r.ReadByte(); err != nil {
+					return nil, err
+				}
+			} else if b == '"' {
+				return s.String(), nil
+			}
+			s.WriteByte(b)
+		}
+	case '{':
+		size, err := c.r.ReadString('}')
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSuffix(size, "}"), "+"), 10, 64)
+		if err != nil {
+			return nil, err
+		} else if n < 0 {
+			return nil, fmt.Errorf("invalid literal size %d", n)
+		} else if n > MAX_MESSAGE_SIZE {
+			return nil, ErrMessageTooLarge
+		} else if _, err = c.line(); err != nil {
+			return nil, err
+		}
+		// the buffer grows with what the server actually sends, not with the size it announced
+		var data bytes.Buffer
+		if _, err = io.CopyN(&data, c.r, n); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return data.Bytes(), nil
+	}
+	c.r.UnreadByte()
+	a, err := c.atom()
+	if err != nil {
+		return nil, err
+	} else if a == "" {
+		return nil, fmt.Errorf("unexpected character %q", b)
+	} else if strings.EqualFold(a, "NIL") {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// atom reads up to the next delimiter, what's between brackets included like in BODY[HEADER]
+func (c *client) atom() (string, error) {
+	var s strings.Builder
+	depth := 0
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if depth == 0 && (b == ' ' || b == '(' || b == ')' || b == '\r' || b == '\n') {
+			c.r.UnreadByte()
+			return s.String(), nil
+		} else if b == '[' {
+			depth += 1
+		} else if b == ']' && depth > 0 {
+			depth -= 1
+		} else if b == '\r' || b == '\n' {
+			c.r.UnreadByte()
+			return s.String(), nil
+		}
+		s.WriteByte(b)
+	}
+}
+
+func (c *client) space() error {
+	b, err := c.r.ReadByte()
+	if err != nil {
+		return err
+	} else if b != ' ' {
+		c.r.UnreadByte()
+	}
+	return nil
+}
+
+func (c *client) line() (string, error) {
+	l, err := c.r.ReadString('\n')
+	return strings.TrimSpace(l), err
+}
+
+// selectMailbox opens a mailbox, read only unless we're about to change something in it
+func (c *client) selectMailbox(mailbox string, writable bool) error {
+	if c.selected == mailbox && (c.writable || writable == false) {
+		return nil
+	}
+	cmd := "EXAMINE"
+	if writable {
+		cmd = "SELECT"
+	}
+	c.selected = ""
+	res, err := c.execute(cmd, mailboxName(mailbox))
+	if err != nil {
+		return err
+	}
+	c.selected = mailbox
+	c.writable = writable
+	c.validity = ""
+	for _, r := range res {
+		if strings.HasPrefix(r.text, "[UIDVALIDITY ") {
+			c.validity = strings.SplitN(strings.TrimPrefix(r.text, "[UIDVALIDITY "), "]", 2)[0]
+		}
+	}
+	return nil
+}
+
+func str(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	}
+	return ""
+}
+
+// astring quotes a string, unless it can't be quoted in which case it's sent as a literal
+func astring(s string) any {
+	for i := 0; i < len(s); i++ {
+		if s[i] > 0x7e || s[i] < 0x20 {
+			return literal(s)
+		}
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func mailboxName(name string) any {
+	return astring(utf7Encode(name))
+}
+
+/*
+ * Mailbox names go over the wire in a modified UTF-7 (RFC 3501 section 5.1.3): printable ASCII as
+ * is, '&' as "&-" and anything else as UTF-16 in base64, with ',' in place of '/', between & and -
+ */
+var utf7 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+func utf7Encode(s string) string {
+	var out strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if r == '&' {
+			out.WriteString("&-")
+			i++
+			continue
+		} else if r >= 0x20 && r <= 0x7e {
+			out.WriteRune(r)
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && (runes[j] < 0x20 || runes[j] > 0x7e) {
+			j++
+		}
+		u := utf16.Encode(runes[i:j])
+		b := make([]byte, 0, len(u)*2)
+		for _, c := range u {
+			b = append(b, byte(c>>8), byte(c))
+		}
+		out.WriteString("&" + utf7.EncodeToString(b) + "-")
+		i = j
+	}
+	return out.String()
+}
+
+func utf7Decode(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '&' {
+			out.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i:], '-')
+		if end == -1 {
+			out.WriteString(s[i:])
+			break
+		} else if end == 1 {
+			out.WriteByte('&')
+			i += 1
+			continue
+		}
+		b, err := utf7.DecodeString(s[i+1 : i+end])
+		if err != nil || len(b)%2 != 0 {
+			out.WriteString(s[i : i+end+1])
+		} else {
+			u := make([]uint16, len(b)/2)
+			for k := range u {
+				u[k] = uint16(b[2*k])<<8 | uint16(b[2*k+1])
+			}
+			out.WriteString(string(utf16.Decode(u)))
+		}
+		i += end
+	}
+	return out.String()
+}
@@ -0,0 +1,591 @@
+package plg_backend_imap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * The imap backend shows a mailbox as files: folders are the mailboxes and each message a folder
+ * named from its date and subject, holding the message itself, its body and its attachments.
+ * Removing a message flags it as deleted, it's up to the mail client to expunge it, and moving it
+ * to another mailbox moves the message on the server.
+ */
+const DEFAULT_MAX_MESSAGES = 1000
+
+var ImapCache AppCache
+var MessageCache AppCache
+
+type Imap struct {
+	session *session
+	conn    string
+	max     int
+}
+
+// session holds the connection of a user, connecting again when it was closed or broken
+type session struct {
+	mu     sync.Mutex
+	client *client
+	params map[string]string
+}
+
+func init() {
+	Backend.Register("imap", Imap{})
+
+	ImapCache = NewAppCache(2, 1)
+	ImapCache.OnEvict(func(key string, value interface{}) {
+		s := value.(*session)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.client != nil {
+			s.client.close()
+			s.client = nil
+		}
+	})
+	MessageCache = NewAppCache(5, 1)
+}
+
+func (this Imap) Init(params map[string]string, app *App) (IBackend, error) {
+	if params["hostname"] == "" {
+		return nil, ErrNotValid
+	}
+	backend := &Imap{conn: GenerateID(params), max: DEFAULT_MAX_MESSAGES}
+	if n, err := strconv.Atoi(params["max_messages"]); err == nil && n > 0 {
+		backend.max = n
+	}
+	if obj := ImapCache.Get(params); obj != nil {
+		backend.session = obj.(*session)
+		return backend, nil
+	}
+	backend.session = &session{params: params}
+	if err := backend.do(func(c *client) error { return nil }); err != nil {
+		return nil, err
+	}
+	ImapCache.Set(params, backend.session)
+	return backend, nil
+}
+
+func (this Imap) LoginForm() Form {
+	return Form{
+		Elmnts: []FormElement{
+			FormElement{
+				Name:  "type",
+				Type:  "hidden",
+				Value: "imap",
+			},
+			FormElement{
+				Name:        "hostname",
+				Type:        "text",
+				Placeholder: "Hostname*",
+			},
+			FormElement{
+				Name:        "username",
+				Type:        "text",
+				Placeholder: "Username",
+			},
+			FormElement{
+				Name:        "password",
+				Type:        "password",
+				Placeholder: "Password",
+			},
+			FormElement{
+				Name:        "advanced",
+				Type:        "enable",
+				Placeholder: "Advanced",
+				Target:      []string{"imap_port", "imap_security", "imap_max_messages", "imap_path"},
+			},
+			FormElement{
+				Id:          "imap_port",
+				Name:        "port",
+				Type:        "number",
+				Placeholder: "Port",
+			},
+			FormElement{
+				Id:          "imap_security",
+				Name:        "security",
+				Type:        "select",
+				Opts:        []string{"tls", "starttls", "none"},
+				Placeholder: "Security",
+			},
+			FormElement{
+				Id:          "imap_max_messages",
+				Name:        "max_messages",
+				Type:        "number",
+				Placeholder: fmt.Sprintf("Messages listed per mailbox, default: %d", DEFAULT_MAX_MESSAGES),
+			},
+			FormElement{
+				Id:          "imap_path",
+				Name:        "path",
+				Type:        "text",
+				Placeholder: "Path",
+			},
+		},
+	}
+}
+
+// do runs something against the server, one thing at a time as IMAP commands go one after the other
+func (this Imap) do(fn func(c *client) error) error {
+	s := this.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil || s.client.broken {
+		if s.client != nil {
+			s.client.conn.Close()
+		}
+		c, err := dial(s.params["hostname"], s.params["port"], s.params["security"], s.params["username"], s.params["password"])
+		if err != nil {
+			s.client = nil
+			return err
+		}
+		s.client = c
+	}
+	return fn(s.client)
+}
+
+// location is what a path points to: a mailbox, a message in it or one of the files of a message
+type location struct {
+	mailbox string
+	uid     uint32
+	file    string
+}
+
+func locate(path string, delim string) (location, error) {
+	segments := []string{}
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	for i := 1; i < len(segments); i++ {
+		uid, ok := uidFromName(segments[i])
+		if ok == false {
+			continue
+		} else if len(segments) > i+2 {
+			return location{}, ErrNotFound
+		}
+		loc := location{mailbox: strings.Join(segments[:i], delim), uid: uid}
+		if len(segments) == i+2 {
+			loc.file = segments[i+1]
+		}
+		return loc, nil
+	}
+	return location{mailbox: strings.Join(segments, delim)}, nil
+}
+
+func (this Imap) Ls(path string) ([]os.FileInfo, error) {
+	files := []os.FileInfo{}
+	err := this.do(func(c *client) error {
+		loc, err := locate(path, c.delim)
+		if err != nil {
+			return err
+		} else if loc.file != "" {
+			return ErrNotValid
+		} else if loc.uid != 0 {
+			m, err := this.message(c, loc.mailbox, loc.uid)
+			if err != nil {
+				return err
+			}
+			for _, f := range m.files {
+				files = append(files, File{
+					FName: f.name,
+					FType: "file",
+					FSize: int64(len(f.data)),
+					FTime: m.time.Unix(),
+				})
+			}
+			return nil
+		}
+
+		prefix := ""
+		if loc.mailbox != "" {
+			prefix = loc.mailbox + c.delim
+		}
+		res, err := c.execute("LIST", `""`, mailboxName(prefix+"%"))
+		if err != nil {
+			return err
+		}
+		selectable := loc.mailbox != ""
+		for _, r := range res {
+			if len(r.fields) < 4 || r.fields[0] != "LIST" {
+				continue
+			}
+			name := utf7Decode(str(r.fields[3]))
+			if hasFlag(r.fields[1], `\NonExistent`) || strings.HasPrefix(name, prefix) == false || name == loc.mailbox {
+				continue
+			}
+			files = append(files, File{
+				FName: strings.TrimPrefix(name, prefix),
+				FType: "directory",
+			})
+		}
+		if selectable == false {
+			return nil
+		}
+		messages, err := this.messages(c, loc.mailbox)
+		if err != nil {
+			if len(files) > 0 {
+				return nil
+			}
+			return err
+		}
+		files = append(files, messages...)
+		return nil
+	})
+	return files, err
+}
+
+// messages lists the most recent messages of a mailbox which aren't flagged as deleted
+func (this Imap) messages(c *client, mailbox string) ([]os.FileInfo, error) {
+	if err := c.selectMailbox(mailbox, false); err != nil {
+		return nil, err
+	}
+	res, err := c.execute("UID", "SEARCH", "UNDELETED")
+	if err != nil {
+		return nil, err
+	}
+	uids := []uint32{}
+	for _, r := range res {
+		if len(r.fields) == 0 || r.fields[0] != "SEARCH" {
+			continue
+		}
+		for _, f := range r.fields[1:] {
+			if uid, err := strconv.ParseUint(str(f), 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	files := []os.FileInfo{}
+	if len(uids) == 0 {
+		return files, nil
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	if len(uids) > this.max {
+		uids = uids[len(uids)-this.max:]
+	}
+	if res, err = c.execute("UID", "FETCH", uidSet(uids), "(UID INTERNALDATE RFC822.SIZE BODY.PEEK[HEADER.FIELDS (SUBJECT)])"); err != nil {
+		return nil, err
+	}
+	for _, r := range res {
+		f := fetchFields(r)
+		if f == nil {
+			continue
+		}
+		uid, err := strconv.ParseUint(str(f["UID"]), 10, 32)
+		if err != nil {
+			continue
+		}
+		t := internalDate(str(f["INTERNALDATE"]))
+		files = append(files, File{
+			FName: folderName(t, subject(bodySection(f)), uint32(uid)),
+			FType: "directory",
+			FTime: t.Unix(),
+		})
+	}
+	return files, nil
+}
+
+// message fetches a message and splits it in files, it's kept around as it's often asked again
+// right after being listed
+func (this Imap) message(c *client, mailbox string, uid uint32) (*message, error) {
+	if err := c.selectMailbox(mailbox, false); err != nil {
+		return nil, err
+	}
+	key := map[string]string{
+		"conn":     this.conn,
+		"mailbox":  mailbox,
+		"validity": c.validity,
+		"uid":      fmt.Sprintf("%d", uid),
+	}
+	if obj := MessageCache.Get(key); obj != nil {
+		return obj.(*message), nil
+	}
+	res, err := c.execute("UID", "FETCH", fmt.Sprintf("%d", uid), "(UID INTERNALDATE BODY.PEEK[])")
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range res {
+		f := fetchFields(r)
+		if f == nil || str(f["UID"]) != fmt.Sprintf("%d", uid) {
+			continue
+		}
+		m := parseMessage(bodySection(f), internalDate(str(f["INTERNALDATE"])))
+		MessageCache.Set(key, m)
+		return m, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (this Imap) Stat(path string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := this.do(func(c *client) error {
+		loc, err := locate(path, c.delim)
+		if err != nil {
+			return err
+		} else if loc.mailbox == "" {
+			info = File{FName: filepath.Base(path), FType: "directory"}
+			return nil
+		} else if loc.uid == 0 {
+			if err = this.mailboxExists(c, loc.mailbox); err != nil {
+				return err
+			}
+			info = File{FName: filepath.Base(path), FType: "directory"}
+			return nil
+		}
+		m, err := this.message(c, loc.mailbox, loc.uid)
+		if err != nil {
+			return err
+		} else if loc.file == "" {
+			info = File{FName: filepath.Base(path), FType: "directory", FTime: m.time.Unix()}
+			return nil
+		} else if f := m.file(loc.file); f != nil {
+			info = File{FName: f.name, FType: "file", FSize: int64(len(f.data)), FTime: m.time.Unix()}
+			return nil
+		}
+		return ErrNotFound
+	})
+	return info, err
+}
+
+// mailboxExists tells apart a mailbox from a path that doesn't point to anything, like the name
+// of a message about to be uploaded
+func (this Imap) mailboxExists(c *client, mailbox string) error {
+	res, err := c.execute("LIST", `""`, mailboxName(mailbox))
+	if err != nil {
+		return err
+	}
+	for _, r := range res {
+		if len(r.fields) < 4 || r.fields[0] != "LIST" {
+			continue
+		} else if utf7Decode(str(r.fields[3])) == mailbox && hasFlag(r.fields[1], `\NonExistent`) == false {
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (this Imap) Cat(path string) (io.ReadCloser, error) {
+	var data []byte
+	err := this.do(func(c *client) error {
+		loc, err := locate(path, c.delim)
+		if err != nil {
+			return err
+		} else if loc.file == "" {
+			return ErrNotValid
+		}
+		m, err := this.message(c, loc.mailbox, loc.uid)
+		if err != nil {
+			return err
+		} else if f := m.file(loc.file); f != nil {
+			data = f.data
+			return nil
+		}
+		return ErrNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewReadCloserFromBytes(data), nil
+}
+
+func (this Imap) Mkdir(path string) error {
+	return this.do(func(c *client) error {
+		loc, err := locate(path, c.delim)
+		if err != nil {
+			return err
+		} else if loc.uid != 0 || loc.mailbox == "" {
+			return ErrNotAllowed
+		}
+		_, err = c.execute("CREATE", mailboxName(loc.mailbox))
+		return err
+	})
+}
+
+func (this Imap) Rm(path string) error {
+	return this.do(func(c *client) error {
+		loc, err := locate(path, c.delim)
+		if err != nil {
+			return err
+		} else if loc.file != "" || loc.mailbox == "" {
+			return ErrNotAllowed
+		} else if loc.uid == 0 {
+			if c.selected == loc.mailbox {
+				c.selected = ""
+			}
+			_, err = c.execute("DELETE", mailboxName(loc.mailbox))
+			return err
+		}
+		if err = c.selectMailbox(loc.mailbox, true); err != nil {
+			return err
+		}
+		_, err = c.execute("UID", "STORE", fmt.Sprintf("%d", loc.uid), "+FLAGS.SILENT", `(\Deleted)`)
+		return err
+	})
+}
+
+func (this Imap) Mv(from string, to string) error {
+	return this.do(func(c *client) error {
+		f, err := locate(from, c.delim)
+		if err != nil {
+			return err
+		} else if f.file != "" || f.mailbox == "" {
+			return ErrNotAllowed
+		}
+		if f.uid == 0 {
+			t, err := locate(to, c.delim)
+			if err != nil {
+				return err
+			} else if t.uid != 0 || t.mailbox == "" {
+				return ErrNotAllowed
+			}
+			if c.selected == f.mailbox {
+				c.selected = ""
+			}
+			_, err = c.execute("RENAME", mailboxName(f.mailbox), mailboxName(t.mailbox))
+			return err
+		}
+
+		// a message goes to the mailbox of the folder it's dropped in, whatever its new name
+		t, err := locate(filepath.Dir(strings.TrimSuffix(to, "/")), c.delim)
+		if err != nil {
+			return err
+		} else if t.uid != 0 || t.mailbox == "" {
+			return ErrNotAllowed
+		} else if t.mailbox == f.mailbox {
+			return ErrNotAllowed
+		}
+		if err = c.selectMailbox(f.mailbox, true); err != nil {
+			return err
+		}
+		uid := fmt.Sprintf("%d", f.uid)
+		if c.caps["MOVE"] {
+			_, err = c.execute("UID", "MOVE", uid, mailboxName(t.mailbox))
+			return err
+		}
+		if _, err = c.execute("UID", "COPY", uid, mailboxName(t.mailbox)); err != nil {
+			return err
+		}
+		_, err = c.execute("UID", "STORE", uid, "+FLAGS.SILENT", `(\Deleted)`)
+		return err
+	})
+}
+
+// Save appends a message to a mailbox, the only kind of file which can be created
+func (this Imap) Save(path string, file io.Reader) error {
+	if strings.HasSuffix(strings.ToLower(path), ".eml") == false {
+		return ErrNotAllowed
+	}
+	data, err := io.ReadAll(io.LimitReader(file, MAX_MESSAGE_SIZE+1))
+	if err != nil {
+		return err
+	} else if len(data) > MAX_MESSAGE_SIZE {
+		return ErrMessageTooLarge
+	}
+	return this.do(func(c *client) error {
+		loc, err := locate(filepath.Dir(path), c.delim)
+		if err != nil {
+			return err
+		} else if loc.uid != 0 || loc.mailbox == "" {
+			return ErrNotAllowed
+		}
+		_, err = c.execute("APPEND", mailboxName(loc.mailbox), literal(data))
+		return err
+	})
+}
+
+func (this Imap) Touch(path string) error {
+	return ErrNotAllowed
+}
+
+func (this Imap) Meta(path string) Metadata {
+	if path == "/" {
+		return Metadata{
+			CanCreateFile: NewBool(false),
+			CanUpload:     NewBool(false),
+		}
+	}
+	for _, s := range strings.Split(path, "/") {
+		if _, ok := uidFromName(s); ok {
+			return Metadata{
+				CanCreateFile:      NewBool(false),
+				CanCreateDirectory: NewBool(false),
+				CanUpload:          NewBool(false),
+				CanRename:          NewBool(false),
+				CanMove:            NewBool(false),
+				CanDelete:          NewBool(false),
+			}
+		}
+	}
+	return Metadata{
+		CanCreateFile: NewBool(false),
+	}
+}
+
+func fetchFields(r *response) map[string]any {
+	if len(r.fields) < 3 || r.fields[1] != "FETCH" {
+		return nil
+	}
+	list, ok := r.fields[2].([]any)
+	if ok == false {
+		return nil
+	}
+	f := map[string]any{}
+	for i := 0; i+1 < len(list); i += 2 {
+		f[strings.ToUpper(str(list[i]))] = list[i+1]
+	}
+	return f
+}
+
+// bodySection gives the content of the BODY[...] item of a fetch, whatever the section is
+func bodySection(f map[string]any) []byte {
+	for key, value := range f {
+		if strings.HasPrefix(key, "BODY[") {
+			return []byte(str(value))
+		}
+	}
+	return nil
+}
+
+func hasFlag(flags any, flag string) bool {
+	list, _ := flags.([]any)
+	for _, f := range list {
+		if strings.EqualFold(str(f), flag) {
+			return true
+		}
+	}
+	return false
+}
+
+func internalDate(s string) time.Time {
+	t, err := time.Parse("_2-Jan-2006 15:04:05 -0700", s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// uidSet writes uids the short way, as ranges: 1:4,7,9:12
+func uidSet(uids []uint32) string {
+	parts := []string{}
+	for i := 0; i < len(uids); {
+		j := i
+		for j+1 < len(uids) && uids[j+1] == uids[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprintf("%d", uids[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d:%d", uids[i], uids[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,219 @@
+package plg_backend_imap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		input  string
+		tag    string
+		status string
+		text   string
+		fields []any
+	}{
+		{
+			input:  "A1 OK LOGIN completed\r\n",
+			tag:    "A1",
+			status: "OK",
+			text:   "LOGIN completed",
+		},
+		{
+			input:  "* OK [UIDVALIDITY 3857529045] UIDs valid\r\n",
+			tag:    "*",
+			status: "OK",
+			text:   "[UIDVALIDITY 3857529045] UIDs valid",
+		},
+		{
+			input: "+ Ready for literal data\r\n",
+			tag:   "+",
+			text:  "Ready for literal data",
+		},
+		{
+			input:  `* LIST (\HasNoChildren) "/" "INBOX"` + "\r\n",
+			tag:    "*",
+			fields: []any{"LIST", []any{`\HasNoChildren`}, "/", "INBOX"},
+		},
+		{
+			input:  `* LIST (\Noselect) NIL "with \"quotes\" and \\"` + "\r\n",
+			tag:    "*",
+			fields: []any{"LIST", []any{`\Noselect`}, nil, `with "quotes" and \`},
+		},
+		{
+			input:  "* SEARCH 1 2 3\r\n",
+			tag:    "*",
+			fields: []any{"SEARCH", "1", "2", "3"},
+		},
+		{
+			input:  "* 3 FETCH (UID 7 BODY[] {0}\r\n)\r\n",
+			tag:    "*",
+			fields: []any{"3", "FETCH", []any{"UID", "7", "BODY[]", []byte{}}},
+		},
+	}
+	for _, test := range tests {
+		res, err := newTestClient(test.input).read()
+		if err != nil {
+			t.Errorf("read(%q) err=%s", test.input, err.Error())
+			continue
+		} else if res.tag != test.tag || res.status != test.status || res.text != test.text {
+			t.Errorf("read(%q) got tag=%q status=%q text=%q", test.input, res.tag, res.status, res.text)
+		} else if test.fields != nil && reflect.DeepEqual(res.fields, test.fields) == false {
+			t.Errorf("read(%q) got fields %#v", test.input, res.fields)
+		}
+	}
+}
+
+func TestReadFetch(t *testing.T) {
+	res, err := newTestClient("* 12 FETCH (UID 42 BODY[HEADER.FIELDS (SUBJECT)] {16}\r\nSubject: hello\r\n)\r\n").read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := fetchFields(res)
+	if f == nil {
+		t.Fatalf("unexpected fields %#v", res.fields)
+	} else if str(f["UID"]) != "42" {
+		t.Fatalf("unexpected uid %#v", f["UID"])
+	} else if string(bodySection(f)) != "Subject: hello\r\n" {
+		t.Fatalf("unexpected body %q", bodySection(f))
+	}
+}
+
+func TestReadLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected error // nil when any error will do
+	}{
+		{input: "* 1 FETCH (BODY[] {-1}\r\n)\r\n"},
+		{input: "* 1 FETCH (BODY[] {abc}\r\n)\r\n"},
+		{input: "* 1 FETCH (BODY[] {9999999999999999999999}\r\n"},
+		{input: fmt.Sprintf("* 1 FETCH (BODY[] {%d}\r\n", MAX_MESSAGE_SIZE+1), expected: ErrMessageTooLarge},
+		{input: "* 1 FETCH (BODY[] {1000000}\r\nshort", expected: io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		_, err := newTestClient(test.input).read()
+		if err == nil {
+			t.Errorf("read(%q) should fail", test.input)
+		} else if test.expected != nil && err != test.expected {
+			t.Errorf("read(%q) got %v want %v", test.input, err, test.expected)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	server, conn := net.Pipe()
+	c := &client{conn: conn, r: bufio.NewReader(conn), caps: map[string]bool{}}
+	done := make(chan string)
+	go func() {
+		r := bufio.NewReader(server)
+		l, _ := r.ReadString('\n')
+		server.Write([]byte("+ go ahead\r\n"))
+		data := make([]byte, 5)
+		io.ReadFull(r, data)
+		r.ReadString('\n')
+		server.Write([]byte("* 1 EXISTS\r\nA1 OK APPEND completed\r\n"))
+		l2, _ := r.ReadString('\n')
+		server.Write([]byte("A2 NO [TRYCREATE] no such mailbox\r\n"))
+		l3, _ := r.ReadString('\n')
+		server.Write([]byte(fmt.Sprintf("* 1 FETCH (BODY[] {%d}\r\n", MAX_MESSAGE_SIZE+1)))
+		done <- l + string(data) + "|" + l2 + "|" + l3
+	}()
+
+	res, err := c.execute("APPEND", `"INBOX"`, literal("hello"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(res) != 1 || res[0].fields[0] != "1" {
+		t.Fatalf("unexpected untagged responses %#v", res)
+	}
+	if _, err = c.execute("SELECT", `"Nope"`); err != ErrNotFound {
+		t.Fatalf("a missing mailbox should be not found, got %v", err)
+	}
+	if _, err = c.execute("FETCH", "1", "BODY[]"); err != ErrMessageTooLarge {
+		t.Fatalf("a large message should be refused, got %v", err)
+	} else if c.broken == false {
+		t.Fatal("the connection can't be used anymore")
+	}
+	sent := <-done
+	if expected := "A1 APPEND \"INBOX\" {5}\r\nhello|A2 SELECT \"Nope\"\r\n|A3 FETCH 1 BODY[]\r\n"; sent != expected {
+		t.Fatalf("unexpected commands %q", sent)
+	}
+}
+
+func TestSaveTooLarge(t *testing.T) {
+	large := io.LimitReader(zeros{}, MAX_MESSAGE_SIZE+1)
+	if err := (Imap{}).Save("/INBOX/new.eml", large); err != ErrMessageTooLarge {
+		t.Fatalf("a message over the limit should be refused, got %v", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	server, conn := net.Pipe()
+	backend := Imap{session: &session{client: &client{conn: conn, r: bufio.NewReader(conn), caps: map[string]bool{}, delim: "/"}}}
+	go func() {
+		r := bufio.NewReader(server)
+		r.ReadString('\n')
+		server.Write([]byte("* LIST (\\HasNoChildren) \"/\" \"INBOX\"\r\nA1 OK LIST completed\r\n"))
+		r.ReadString('\n')
+		server.Write([]byte("A2 OK LIST completed\r\n"))
+		r.ReadString('\n')
+		server.Write([]byte("* LIST (\\NonExistent) \"/\" \"Gone\"\r\nA3 OK LIST completed\r\n"))
+	}()
+
+	if info, err := backend.Stat("/"); err != nil || info.IsDir() == false {
+		t.Fatalf("the root is a folder, got %v", err)
+	}
+	if info, err := backend.Stat("/INBOX/"); err != nil || info.IsDir() == false {
+		t.Fatalf("an existing mailbox is a folder, got %v", err)
+	}
+	if _, err := backend.Stat("/INBOX/new.eml"); err != ErrNotFound {
+		t.Fatalf("a message about to be uploaded doesn't exist yet, got %v", err)
+	}
+	if _, err := backend.Stat("/Gone/"); err != ErrNotFound {
+		t.Fatalf("a mailbox that doesn't exist anymore isn't there, got %v", err)
+	}
+}
+
+func TestUTF7(t *testing.T) {
+	tests := map[string]string{
+		"INBOX":         "INBOX",
+		"Tom & Jerry":   "Tom &- Jerry",
+		"Entwürfe":      "Entw&APw-rfe",
+		"台北":            "&U,BTFw-",
+		"~peter/日本語/台北": "~peter/&ZeVnLIqe-/&U,BTFw-",
+	}
+	for in, expected := range tests {
+		if got := utf7Encode(in); got != expected {
+			t.Errorf("utf7Encode(%q) got %q want %q", in, got, expected)
+		} else if back := utf7Decode(got); back != in {
+			t.Errorf("utf7Decode(%q) got %q want %q", got, back, in)
+		}
+	}
+}
+
+func TestAstring(t *testing.T) {
+	if got := astring(`pass"word\`); got != `"pass\"word\\"` {
+		t.Fatalf("unexpected quoting %v", got)
+	}
+	if got, ok := astring("pässword").(literal); !ok || bytes.Equal(got, []byte("pässword")) == false {
+		t.Fatalf("non ascii should be sent as a literal, got %#v", got)
+	}
+}
+
+func newTestClient(input string) *client {
+	return &client{r: bufio.NewReader(strings.NewReader(input)), caps: map[string]bool{}}
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,243 @@
+package plg_backend_imap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+/*
+ * A message is seen as a folder holding:
+ * - message.eml: the message as it is on the server
+ * - body.txt and/or body.html: what the message says, in UTF-8
+ * - its attachments, under their own name
+ */
+const (
+	EML_NAME  = "message.eml"
+	TEXT_NAME = "body.txt"
+	HTML_NAME = "body.html"
+)
+
+type message struct {
+	files []part
+	time  time.Time
+}
+
+type part struct {
+	name string
+	data []byte
+}
+
+var decoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+var messageName = regexp.MustCompile(` #([0-9]+)$`)
+
+// folderName is what a message is listed as, the uid at the end is how we find it back
+func folderName(t time.Time, subject string, uid uint32) string {
+	subject = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, subject)
+	subject = strings.Join(strings.Fields(subject), " ")
+	if runes := []rune(subject); len(runes) > 80 {
+		subject = strings.TrimSpace(string(runes[:80]))
+	}
+	if subject == "" {
+		subject = "(no subject)"
+	}
+	return fmt.Sprintf("%s %s #%d", t.Format("2006-01-02 1504"), subject, uid)
+}
+
+func uidFromName(name string) (uint32, bool) {
+	m := messageName.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	uid, err := strconv.ParseUint(m[1], 10, 32)
+	return uint32(uid), err == nil
+}
+
+func subject(header []byte) string {
+	msg, err := mail.ReadMessage(io.MultiReader(bytes.NewReader(header), strings.NewReader("\r\n")))
+	if err != nil {
+		return ""
+	}
+	s, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return msg.Header.Get("Subject")
+	}
+	return s
+}
+
+func parseMessage(eml []byte, t time.Time) *message {
+	m := &message{time: t, files: []part{{EML_NAME, eml}}}
+	msg, err := mail.ReadMessage(bytes.NewReader(eml))
+	if err != nil {
+		return m
+	}
+	var text, html []byte
+	attachments := []part{}
+	var walk func(header textproto.MIMEHeader, body io.Reader)
+	walk = func(header textproto.MIMEHeader, body io.Reader) {
+		ct, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+		if err != nil || ct == "" {
+			ct, params = "text/plain", map[string]string{}
+		}
+		if strings.HasPrefix(ct, "multipart/") {
+			mr := multipart.NewReader(body, params["boundary"])
+			for p, err := mr.NextRawPart(); err == nil; p, err = mr.NextRawPart() {
+				walk(p.Header, p)
+			}
+			return
+		}
+		data, err := io.ReadAll(decode(header.Get("Content-Transfer-Encoding"), body))
+		if err != nil {
+			return
+		}
+		disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+		filename := dparams["filename"]
+		if filename == "" {
+			filename = params["name"]
+		}
+		if f, err := decoder.DecodeHeader(filename); err == nil {
+			filename = f
+		}
+		switch {
+		case disposition != "attachment" && filename == "" && ct == "text/plain" && text == nil:
+			text = toUTF8(data, params["charset"])
+		case disposition != "attachment" && filename == "" && ct == "text/html" && html == nil:
+			html = toUTF8(data, params["charset"])
+		default:
+			if filename == "" {
+				filename = "attachment"
+				if ct == "message/rfc822" {
+					filename = "attachment.eml"
+				} else if exts, _ := mime.ExtensionsByType(ct); len(exts) > 0 {
+					filename += exts[0]
+				}
+			}
+			attachments = append(attachments, part{filename, data})
+		}
+	}
+	walk(textproto.MIMEHeader(msg.Header), msg.Body)
+
+	used := map[string]bool{EML_NAME: true}
+	if text != nil {
+		m.files = append(m.files, part{TEXT_NAME, text})
+		used[TEXT_NAME] = true
+	}
+	if html != nil {
+		m.files = append(m.files, part{HTML_NAME, html})
+		used[HTML_NAME] = true
+	}
+	for _, a := range attachments {
+		a.name = uniqueName(cleanName(a.name), used)
+		used[a.name] = true
+		m.files = append(m.files, a)
+	}
+	return m
+}
+
+func (m *message) file(name string) *part {
+	for i := range m.files {
+		if m.files[i].name == name {
+			return &m.files[i]
+		}
+	}
+	return nil
+}
+
+func decode(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// base64Cleaner drops the line breaks and spaces base64 content is wrapped with in a message
+type base64Cleaner struct {
+	r io.Reader
+}
+
+func (this *base64Cleaner) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	j := 0
+	for i := 0; i < n; i++ {
+		if p[i] != '\r' && p[i] != '\n' && p[i] != ' ' && p[i] != '\t' {
+			p[j] = p[i]
+			j++
+		}
+	}
+	return j, err
+}
+
+func toUTF8(data []byte, charset string) []byte {
+	charset = strings.ToLower(charset)
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return data
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return data
+	}
+	out, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+func cleanName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	if name = strings.TrimSpace(name); name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+func uniqueName(name string, used map[string]bool) string {
+	if used[name] == false {
+		return name
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if used[candidate] == false {
+			return candidate
+		}
+	}
+}
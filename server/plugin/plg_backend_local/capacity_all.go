@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package plg_backend_local
+
+import (
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func (this Local) Capacity(path string) (BackendCapacity, error) {
+	return BackendCapacity{}, ErrNotImplemented
+}
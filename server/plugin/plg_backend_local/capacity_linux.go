@@ -0,0 +1,18 @@
+package plg_backend_local
+
+import (
+	"syscall"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func (this Local) Capacity(path string) (BackendCapacity, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return BackendCapacity{}, err
+	}
+	return BackendCapacity{
+		Used:      int64(stat.Blocks-stat.Bfree) * int64(stat.Bsize),
+		Available: int64(stat.Bavail) * int64(stat.Bsize),
+	}, nil
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 )
 
 func init() {
@@ -71,25 +73,24 @@ func (this Local) Ls(path string) ([]os.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	files, err := f.Readdir(-1)
+	infos, err := f.Readdir(-1)
 	if err != nil {
 		f.Close()
 		return nil, err
 	}
+	files := make([]os.FileInfo, len(infos))
+	for i := range infos {
+		files[i] = toFile(filepath.Join(path, infos[i].Name()), infos[i])
+	}
 	return files, f.Close()
 }
 
 func (this Local) Stat(path string) (os.FileInfo, error) {
-	f, err := SafeOsOpenFile(path, os.O_RDONLY, os.ModePerm)
+	info, err := SafeOsLstat(path)
 	if err != nil {
 		return nil, err
 	}
-	finfo, err := f.Stat()
-	if err != nil {
-		f.Close()
-		return nil, err
-	}
-	return finfo, f.Close()
+	return toFile(path, info), nil
 }
 
 func (this Local) Cat(path string) (io.ReadCloser, error) {
@@ -108,6 +109,14 @@ func (this Local) Cat(path string) (io.ReadCloser, error) {
 	return f, nil
 }
 
+func (this Local) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	f, err := this.Cat(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSectionReadCloser(f.(*os.File), offset, length), nil
+}
+
 func (this Local) Mkdir(path string) error {
 	return SafeOsMkdir(path, 0755)
 }
@@ -132,6 +141,10 @@ func (this Local) Save(path string, content io.Reader) error {
 	return f.Close()
 }
 
+func (this Local) Replace(from string, to string) error {
+	return SafeOsRename(from, to)
+}
+
 func (this Local) Touch(path string) error {
 	f, err := SafeOsOpenFile(path, os.O_WRONLY|os.O_CREATE, os.ModePerm)
 	if err != nil {
@@ -143,3 +156,42 @@ func (this Local) Touch(path string) error {
 	}
 	return f.Close()
 }
+
+func (this Local) Chmod(path string, mode os.FileMode) error {
+	return SafeOsChmod(path, mode)
+}
+
+func (this Local) Chown(path string, uid int, gid int) error {
+	return SafeOsLchown(path, uid, gid)
+}
+
+func (this Local) Symlink(target string, path string) error {
+	return SafeOsSymlink(target, path)
+}
+
+func (this Local) Readlink(path string) (string, error) {
+	return SafeOsReadlink(path)
+}
+
+// toFile keeps the permissions and owner of a file, a symlink being shown as what it points to
+func toFile(path string, info os.FileInfo) File {
+	f := File{
+		FName: info.Name(),
+		FType: "file",
+		FSize: info.Size(),
+		FTime: info.ModTime().Unix(),
+		Posix: NewFilePosix(info),
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		path = strings.TrimSuffix(path, "/")
+		f.Posix.Link, _ = os.Readlink(path)
+		if target, err := os.Stat(path); err == nil {
+			info = target
+			f.FSize = target.Size()
+		}
+	}
+	if info.IsDir() {
+		f.FType = "directory"
+	}
+	return f
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/pkg/tabular"
 	"io"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -222,6 +224,9 @@ func (this Mysql) Ls(path string) ([]os.FileInfo, error) {
 			return nil, err
 		}
 
+		for _, f := range tabular.Files() {
+			files = append(files, f)
+		}
 		for rows.Next() {
 			var name_raw sql.RawBytes
 			var date sql.RawBytes
@@ -257,6 +262,9 @@ func (this Mysql) Stat(path string) (os.FileInfo, error) {
 }
 
 func (this Mysql) Cat(path string) (io.ReadCloser, error) {
+	if name := filepath.Base(path); tabular.IsExport(name) {
+		return this.export(path, name)
+	}
 	defer this.db.Close()
 	location, err := NewDBLocation(path)
 	if err != nil {
@@ -460,6 +468,8 @@ func (this Mysql) Rm(path string) error {
 	} else if location.row == "" {
 		_, err := this.db.Exec(fmt.Sprintf("DROP TABLE %s.%s", location.db, location.table))
 		return err
+	} else if tabular.IsExport(filepath.Base(path)) {
+		return ErrNotAllowed
 	}
 	fields, err := FindQuerySelection(this.db, location)
 	if err != nil {
@@ -553,6 +563,10 @@ func (this Mysql) Save(path string, file io.Reader) error {
 	}
 	if location.db == "" || location.table == "" || location.row == "" {
 		return ErrNotValid
+	} else if tabular.IsImport(filepath.Base(path)) {
+		return this.importCSV(location, file)
+	} else if tabular.IsExport(filepath.Base(path)) {
+		return ErrNotAllowed
 	}
 	sqlFields, err := FindQuerySelection(this.db, location)
 	if err != nil {
@@ -592,6 +606,62 @@ func (this Mysql) Save(path string, file io.Reader) error {
 	return nil
 }
 
+// export streams a whole table, the connection stays open until the whole thing has been read
+func (this Mysql) export(path string, name string) (io.ReadCloser, error) {
+	location, err := NewDBLocation(path)
+	if err != nil {
+		this.db.Close()
+		return nil, err
+	} else if location.db == "" || location.table == "" {
+		this.db.Close()
+		return nil, ErrNotValid
+	}
+	rows, err := this.db.Query(fmt.Sprintf("SELECT * FROM %s.%s", location.db, location.table))
+	if err != nil {
+		this.db.Close()
+		return nil, err
+	}
+	return tabular.Export(name, rows, func() { this.db.Close() }), nil
+}
+
+// importCSV inserts every line of a csv in a table, either they all make it or none does
+func (this Mysql) importCSV(location DBLocation, file io.Reader) error {
+	fields, err := FindQuerySelection(this.db, location)
+	if err != nil {
+		return err
+	}
+	columns := make([]tabular.Column, 0, len(fields.All))
+	for _, c := range fields.All {
+		columns = append(columns, tabular.Column{Name: c.Name, Type: c.Type, Nullable: c.Nullable, Default: c.Default})
+	}
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	n, err := tabular.Import(file, columns, func(names []string, values []any) error {
+		placeholders := make([]string, len(names))
+		for i := range names {
+			names[i] = "`" + names[i] + "`"
+			placeholders[i] = "?"
+		}
+		_, err := tx.Exec(fmt.Sprintf(
+			"INSERT INTO %s.%s (%s) VALUES(%s)",
+			location.db,
+			location.table,
+			strings.Join(names, ", "),
+			strings.Join(placeholders, ", "),
+		), values...)
+		return err
+	})
+	if err != nil {
+		Log.Debug("plg_backend_mysql::import table=%s.%s err=%s", location.db, location.table, err.Error())
+		return err
+	}
+	Log.Debug("plg_backend_mysql::import table=%s.%s rows=%d", location.db, location.table, n)
+	return tx.Commit()
+}
+
 func (this Mysql) Meta(path string) Metadata {
 	location, _ := NewDBLocation(path)
 	return Metadata{
@@ -607,8 +677,14 @@ func (this Mysql) Meta(path string) Metadata {
 			}
 			return NewBool(true)
 		}(location),
-		CanRename:       NewBool(false),
-		CanMove:         NewBool(false),
+		CanRename: NewBool(false),
+		CanMove:   NewBool(false),
+		CanUpload: func(l DBLocation) *bool {
+			if l.table == "" || l.db == "" {
+				return NewBool(false)
+			}
+			return NewBool(true)
+		}(location),
 		RefreshOnCreate: NewBool(true),
 		HideExtension:   NewBool(true),
 	}
@@ -668,6 +744,7 @@ type QuerySelection struct {
 	Size     int
 	Key      string
 	Nullable bool
+	Default  bool
 }
 
 type SqlFields struct {
@@ -706,7 +783,7 @@ func FindQuerySelection(db *sql.DB, location DBLocation) (SqlFields, error) {
 	}
 
 	// STEP 1: extract possible values from the available schema
-	rows, err := db.Query("SELECT IS_NULLABLE, DATA_TYPE, COLUMN_TYPE, COLUMN_NAME, COLUMN_KEY FROM information_schema.COLUMNS WHERE table_schema = ? && table_name = ?", location.db, location.table)
+	rows, err := db.Query("SELECT IS_NULLABLE, DATA_TYPE, COLUMN_TYPE, COLUMN_NAME, COLUMN_KEY, (COLUMN_DEFAULT IS NOT NULL OR EXTRA LIKE '%auto_increment%') FROM information_schema.COLUMNS WHERE table_schema = ? && table_name = ?", location.db, location.table)
 	if err != nil {
 		return fields, err
 	}
@@ -716,8 +793,9 @@ func FindQuerySelection(db *sql.DB, location DBLocation) (SqlFields, error) {
 		var column_name string
 		var column_key string
 		var is_nullable string
+		var has_default bool
 
-		if err := rows.Scan(&is_nullable, &data_type, &column_type, &column_name, &column_key); err != nil {
+		if err := rows.Scan(&is_nullable, &data_type, &column_type, &column_name, &column_key, &has_default); err != nil {
 			return fields, err
 		}
 		q := QuerySelection{
@@ -742,6 +820,7 @@ func FindQuerySelection(db *sql.DB, location DBLocation) (SqlFields, error) {
 			}(),
 			RawType: column_type,
 			Key:     column_key,
+			Default: has_default,
 		}
 		fields.All[column_name] = q
 		queryCandidates = append(queryCandidates, q)
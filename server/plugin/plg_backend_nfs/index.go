@@ -194,8 +194,8 @@ func (this NfsShare) Ls(path string) ([]os.FileInfo, error) {
 	for _, dir := range dirs {
 		if dir.FileName == "." || dir.FileName == ".." {
 			continue
-		} else if dir.Attr.Attr.Type != 1 && dir.Attr.Attr.Type != 2 {
-			// don't show anything else than file and folder
+		} else if t := dir.Attr.Attr.Type; t != nfs.NF3Reg && t != nfs.NF3Dir && t != nfs.NF3Lnk {
+			// don't show anything else than file, folder and symlink
 			continue
 		}
 		if len(this.gids) > 0 { // filter out what users don't have access
@@ -212,24 +212,22 @@ func (this NfsShare) Ls(path string) ([]os.FileInfo, error) {
 				continue
 			}
 		}
-		files = append(files, File{
-			FName: dir.FileName,
-			FType: func() string {
-				if dir.Attr.Attr.Type == 1 {
-					return "file"
-				}
-				return "directory"
-			}(),
-			FSize: int64(dir.Attr.Attr.Filesize),
-			FTime: int64(dir.Attr.Attr.Ctime.Seconds),
-		})
+		files = append(files, this.toFile(EnforceDirectory(path)+dir.FileName, dir.FileName, dir.Attr.Attr))
 	}
 	return files, nil
 }
 
 func (this NfsShare) Stat(path string) (os.FileInfo, error) {
 	defer this.Close()
-	return nil, ErrNotImplemented
+	f, _, err := this.v.Lookup(this.nfsPath(path))
+	if err != nil {
+		return nil, err
+	}
+	fattr, ok := f.(*nfs.Fattr)
+	if ok == false || fattr == nil { // happen at the root
+		return File{FName: "/", FType: "directory"}, nil
+	}
+	return this.toFile(path, filepath.Base(this.nfsPath(path)), *fattr), nil
 }
 
 func (this NfsShare) Cat(path string) (io.ReadCloser, error) {
@@ -0,0 +1,148 @@
+package plg_backend_nfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/vmware/go-nfs-client/nfs"
+	"github.com/vmware/go-nfs-client/nfs/rpc"
+	"github.com/vmware/go-nfs-client/nfs/xdr"
+)
+
+// like for Mv, the lib doesn't do SETATTR and SYMLINK so we do it as of RFC1813:
+// https://www.rfc-editor.org/rfc/rfc1813#section-3.3.2 and section-3.3.10
+const (
+	SETATTR3res = 2
+	SYMLINK3res = 10
+)
+
+func (this NfsShare) Chmod(path string, mode os.FileMode) error {
+	defer this.Close()
+	return this.setattr(path, nfs.Sattr3{
+		Mode: nfs.SetMode{SetIt: true, Mode: UnixMode(mode)},
+	})
+}
+
+func (this NfsShare) Chown(path string, uid int, gid int) error {
+	defer this.Close()
+	return this.setattr(path, nfs.Sattr3{
+		UID: nfs.SetUID{SetIt: uid >= 0, UID: uint32(uid)},
+		GID: nfs.SetUID{SetIt: gid >= 0, UID: uint32(gid)},
+	})
+}
+
+func (this NfsShare) Symlink(target string, path string) error {
+	defer this.Close()
+	dir, name := filepath.Split(this.nfsPath(path))
+	_, fh, err := this.v.Lookup(dir)
+	if err != nil {
+		return err
+	}
+	type SymlinkArgs struct {
+		rpc.Header
+		Where  nfs.Diropargs3
+		Attrs  nfs.Sattr3
+		Target string
+	}
+	return this.call(&SymlinkArgs{
+		Header: this.header(SYMLINK3res),
+		Where: nfs.Diropargs3{
+			FH:       fh,
+			Filename: name,
+		},
+		Attrs: nfs.Sattr3{
+			Mode: nfs.SetMode{SetIt: true, Mode: 0777},
+		},
+		Target: target,
+	})
+}
+
+func (this NfsShare) Readlink(path string) (string, error) {
+	defer this.Close()
+	return this.readlink(path)
+}
+
+func (this NfsShare) readlink(path string) (string, error) {
+	f, err := this.v.Open(this.nfsPath(path))
+	if err != nil {
+		return "", err
+	}
+	return f.Readlink()
+}
+
+func (this NfsShare) setattr(path string, attrs nfs.Sattr3) error {
+	_, fh, err := this.v.Lookup(this.nfsPath(path))
+	if err != nil {
+		return err
+	}
+	type SetattrArgs struct {
+		rpc.Header
+		FH    []byte
+		Attrs nfs.Sattr3
+		Guard struct {
+			Check bool         `xdr:"union"`
+			Ctime nfs.NFS3Time `xdr:"unioncase=1"`
+		}
+	}
+	return this.call(&SetattrArgs{
+		Header: this.header(SETATTR3res),
+		FH:     fh,
+		Attrs:  attrs,
+	})
+}
+
+func (this NfsShare) header(proc uint32) rpc.Header {
+	return rpc.Header{
+		Rpcvers: 2,
+		Prog:    nfs.Nfs3Prog,
+		Vers:    nfs.Nfs3Vers,
+		Proc:    proc,
+		Cred:    this.auth,
+		Verf:    rpc.AuthNull,
+	}
+}
+
+func (this NfsShare) call(args interface{}) error {
+	res, err := this.v.Call(args)
+	if err != nil {
+		return err
+	}
+	status, err := xdr.ReadUint32(res)
+	if err != nil {
+		return err
+	}
+	return nfs.NFS3Error(status)
+}
+
+// toFile keeps the permissions and owner of a file, a symlink being shown as what it points to
+// when that's somewhere we can find in the share
+func (this NfsShare) toFile(path string, name string, attr nfs.Fattr) File {
+	f := File{
+		FName: name,
+		FType: "file",
+		FSize: int64(attr.Filesize),
+		FTime: int64(attr.Ctime.Seconds),
+		Posix: &FilePosix{
+			Mode: attr.FileMode & 07777,
+			Uid:  int(attr.UID),
+			Gid:  int(attr.GID),
+		},
+	}
+	if attr.Type == nfs.NF3Lnk {
+		f.Posix.Link, _ = this.readlink(path)
+		if f.Posix.Link != "" && strings.HasPrefix(f.Posix.Link, "/") == false {
+			target, _, err := this.v.Lookup(filepath.Join(filepath.Dir(this.nfsPath(path)), f.Posix.Link))
+			if t, ok := target.(*nfs.Fattr); err == nil && ok && t != nil {
+				attr = *t
+				f.FSize = int64(t.Filesize)
+			}
+		}
+	}
+	if attr.Type == nfs.NF3Dir {
+		f.FType = "directory"
+	}
+	return f
+}
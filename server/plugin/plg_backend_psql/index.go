@@ -137,7 +137,7 @@ func (this PSQL) Meta(path string) Metadata {
 		}(location),
 		CanMove: NewBool(false),
 		CanUpload: func(l LocationRow) *bool {
-			if l.row == "" {
+			if l.table == "" {
 				return NewBool(false)
 			}
 			return NewBool(true)
@@ -6,12 +6,17 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"slices"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/pkg/tabular"
 )
 
 func (this PSQL) Cat(path string) (io.ReadCloser, error) {
+	if name := filepath.Base(path); tabular.IsExport(name) {
+		return this.export(path, name)
+	}
 	defer this.Close()
 	l, err := getPath(path)
 	if err != nil {
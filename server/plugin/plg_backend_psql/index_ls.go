@@ -5,6 +5,7 @@ import (
 	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/pkg/tabular"
 )
 
 func (this PSQL) Ls(path string) ([]os.FileInfo, error) {
@@ -56,6 +57,9 @@ func (this PSQL) Ls(path string) ([]os.FileInfo, error) {
 		}
 		defer rows.Close()
 		out := []os.FileInfo{}
+		for _, f := range tabular.Files() {
+			out = append(out, f)
+		}
 		for rows.Next() {
 			var name string
 			var t *time.Time
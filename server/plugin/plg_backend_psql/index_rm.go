@@ -1,7 +1,10 @@
 package plg_backend_psql
 
 import (
+	"path/filepath"
+
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/pkg/tabular"
 )
 
 func (this PSQL) Rm(path string) error {
@@ -11,6 +14,8 @@ func (this PSQL) Rm(path string) error {
 		return err
 	} else if l.table == "" {
 		return ErrNotFound
+	} else if tabular.IsExport(filepath.Base(path)) {
+		return ErrNotAllowed
 	}
 	_, key, err := processTable(this.ctx, this.db, l.table)
 	if err != nil {
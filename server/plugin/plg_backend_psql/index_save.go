@@ -6,14 +6,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"path/filepath"
 	"slices"
 	"strings"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/pkg/tabular"
 )
 
 func (this PSQL) Save(path string, file io.Reader) error {
 	defer this.Close()
+	if tabular.IsImport(filepath.Base(path)) {
+		return this.importCSV(path, file)
+	} else if tabular.IsExport(filepath.Base(path)) {
+		return ErrNotAllowed
+	}
 	l, err := getPath(path)
 	if err != nil {
 		return err
@@ -0,0 +1,78 @@
+package plg_backend_psql
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/pkg/tabular"
+)
+
+// export streams a whole table, the connection stays open until the whole thing has been read
+func (this PSQL) export(path string, name string) (io.ReadCloser, error) {
+	l, err := getPath(path)
+	if err != nil {
+		this.Close()
+		return nil, err
+	} else if l.table == "" {
+		this.Close()
+		return nil, ErrNotValid
+	}
+	_, key, err := processTable(this.ctx, this.db, l.table)
+	if err != nil {
+		this.Close()
+		return nil, err
+	}
+	rows, err := this.db.QueryContext(this.ctx, `SELECT * FROM "`+l.table+`" ORDER BY "`+key+`"`)
+	if err != nil {
+		Log.Debug("plg_backend_psql::export method=query err=%s", err.Error())
+		this.Close()
+		return nil, err
+	}
+	return tabular.Export(name, rows, func() { this.Close() }), nil
+}
+
+// importCSV inserts every line of a csv in a table, either they all make it or none does
+func (this PSQL) importCSV(path string, file io.Reader) error {
+	l, err := getPath(path)
+	if err != nil {
+		return err
+	} else if l.table == "" {
+		return ErrNotValid
+	}
+	columns, err := _getColumns(this.ctx, this.db, l.table)
+	if err != nil {
+		return err
+	} else if len(columns) == 0 {
+		return ErrNotFound
+	}
+	cols := make([]tabular.Column, len(columns))
+	for i, c := range columns {
+		cols[i] = tabular.Column{Name: c.Name, Type: c.Type, Nullable: c.Nullable, Default: c.Default}
+	}
+	tx, err := this.db.BeginTx(this.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	n, err := tabular.Import(file, cols, func(names []string, values []any) error {
+		placeholders := make([]string, len(names))
+		for i := range names {
+			names[i] = `"` + names[i] + `"`
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+		_, err := tx.ExecContext(
+			this.ctx,
+			`INSERT INTO "`+l.table+`" (`+strings.Join(names, ", ")+`) VALUES (`+strings.Join(placeholders, ", ")+`)`,
+			values...,
+		)
+		return err
+	})
+	if err != nil {
+		Log.Debug("plg_backend_psql::import table=%s err=%s", l.table, err.Error())
+		return err
+	}
+	Log.Debug("plg_backend_psql::import table=%s rows=%d", l.table, n)
+	return tx.Commit()
+}
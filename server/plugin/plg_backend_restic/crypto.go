@@ -0,0 +1,102 @@
+package plg_backend_restic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"golang.org/x/crypto/poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+/*
+ * Everything in a restic repository is encrypted the same way: a 16 bytes IV, the content in
+ * AES-256-CTR and a Poly1305-AES MAC of the ciphertext. The key doing that, the master key, is
+ * itself stored encrypted in the keys folder with a key derived from the password through scrypt
+ * (see the design document of restic: doc/design.rst)
+ */
+const (
+	IV_SIZE  = 16
+	MAC_SIZE = 16
+)
+
+var ErrInvalidMAC = errors.New("invalid mac")
+
+type keys struct {
+	encrypt []byte
+	macK    []byte
+	macR    []byte
+}
+
+type keyFile struct {
+	KDF  string `json:"kdf"`
+	N    int    `json:"N"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+	Data []byte `json:"data"`
+}
+
+type masterKey struct {
+	MAC struct {
+		K []byte `json:"k"`
+		R []byte `json:"r"`
+	} `json:"mac"`
+	Encrypt []byte `json:"encrypt"`
+}
+
+// openKey gives the master key stored in a key file, as long as the password is the right one
+func openKey(content []byte, password string) (*keys, error) {
+	var kf keyFile
+	if err := json.Unmarshal(content, &kf); err != nil {
+		return nil, err
+	} else if kf.KDF != "scrypt" {
+		return nil, ErrNotImplemented
+	}
+	derived, err := scrypt.Key([]byte(password), kf.Salt, kf.N, kf.R, kf.P, 64)
+	if err != nil {
+		return nil, err
+	}
+	user := &keys{encrypt: derived[:32], macK: derived[32:48], macR: derived[48:]}
+	plain, err := user.decrypt(kf.Data)
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+	var mk masterKey
+	if err = json.Unmarshal(plain, &mk); err != nil {
+		return nil, err
+	} else if len(mk.Encrypt) != 32 || len(mk.MAC.K) != 16 || len(mk.MAC.R) != 16 {
+		return nil, ErrNotValid
+	}
+	return &keys{encrypt: mk.Encrypt, macK: mk.MAC.K, macR: mk.MAC.R}, nil
+}
+
+func (this *keys) decrypt(data []byte) ([]byte, error) {
+	if len(data) < IV_SIZE+MAC_SIZE {
+		return nil, ErrInvalidMAC
+	}
+	iv := data[:IV_SIZE]
+	ciphertext := data[IV_SIZE : len(data)-MAC_SIZE]
+	var mac [MAC_SIZE]byte
+	copy(mac[:], data[len(data)-MAC_SIZE:])
+
+	var macKey [32]byte
+	copy(macKey[:16], this.macR)
+	block, err := aes.NewCipher(this.macK)
+	if err != nil {
+		return nil, err
+	}
+	block.Encrypt(macKey[16:], iv)
+	if poly1305.Verify(&mac, ciphertext, &macKey) == false {
+		return nil, ErrInvalidMAC
+	}
+
+	if block, err = aes.NewCipher(this.encrypt); err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(plain, ciphertext)
+	return plain, nil
+}
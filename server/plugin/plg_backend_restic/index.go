@@ -0,0 +1,227 @@
+package plg_backend_restic
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+/*
+ * The restic backend browses the snapshots of a restic repository, read only. Each snapshot is a
+ * folder named after when it was made and on which host, holding the files as they were backed up.
+ * The repository sits on another backend, configured like the source of the crypt backend: its
+ * type in `source` and its parameters prefixed by `source_`. Repositories are opened without
+ * taking a lock, a prune running at the same time may make some files unreadable until it's done.
+ */
+var RepositoryCache AppCache
+var TreeCache AppCache
+
+type Restic struct {
+	source IBackend
+	root   string
+	repo   *repository
+}
+
+func init() {
+	Backend.Register("restic", Restic{})
+	RepositoryCache = NewAppCache(30, 5)
+	TreeCache = NewAppCache(5, 1)
+}
+
+func (this Restic) Init(params map[string]string, app *App) (IBackend, error) {
+	if params["source"] == "" || params["password"] == "" {
+		return nil, ErrNotValid
+	}
+	source, err := model.NewBackend(app, model.SourceParams(params))
+	if err != nil {
+		Log.Debug("plg_backend_restic::init action=source err=%s", err.Error())
+		return nil, err
+	}
+	backend := &Restic{source: source, root: EnforceDirectory(params["repository"])}
+	if backend.root == "" {
+		backend.root = "/"
+	}
+	if obj := RepositoryCache.Get(params); obj != nil {
+		backend.repo = obj.(*repository)
+		return backend, nil
+	}
+	if backend.repo, err = backend.open(params["password"]); err != nil {
+		Log.Debug("plg_backend_restic::init action=open err=%s", err.Error())
+		return nil, err
+	}
+	RepositoryCache.Set(params, backend.repo)
+	return backend, nil
+}
+
+func (this Restic) LoginForm() Form {
+	return Form{
+		Elmnts: []FormElement{
+			FormElement{
+				Name:  "type",
+				Type:  "hidden",
+				Value: "restic",
+			},
+			FormElement{
+				Name:        "source",
+				Type:        "text",
+				Placeholder: "Source backend",
+				Description: "Where the repository is stored, eg: local or sftp. Its parameters are the ones prefixed with 'source_'",
+			},
+			FormElement{
+				Name:        "repository",
+				Type:        "text",
+				Placeholder: "Repository path",
+			},
+			FormElement{
+				Name:        "password",
+				Type:        "password",
+				Placeholder: "Password",
+			},
+		},
+	}
+}
+
+func (this Restic) Ls(path string) ([]os.FileInfo, error) {
+	files := []os.FileInfo{}
+	if path == "/" {
+		list, err := this.listSnapshots()
+		if err != nil {
+			return nil, err
+		}
+		for i := len(list) - 1; i >= 0; i-- {
+			files = append(files, File{
+				FName: list[i].name(),
+				FType: "directory",
+				FTime: list[i].Time.Unix(),
+			})
+		}
+		return files, nil
+	}
+	n, err := this.find(path)
+	if err != nil {
+		return nil, err
+	} else if n.Type != "dir" {
+		return nil, ErrNotValid
+	}
+	nodes, err := this.tree(n.Subtree)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range nodes {
+		if f, ok := toFile(child); ok {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+func (this Restic) Stat(path string) (os.FileInfo, error) {
+	if path == "/" {
+		return File{FName: "/", FType: "directory"}, nil
+	}
+	n, err := this.find(path)
+	if err != nil {
+		return nil, err
+	} else if f, ok := toFile(n); ok {
+		return f, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (this Restic) Cat(path string) (io.ReadCloser, error) {
+	return this.CatRange(path, 0, -1)
+}
+
+func (this Restic) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	n, err := this.find(path)
+	if err != nil {
+		return nil, err
+	} else if n.Type != "file" {
+		return nil, ErrNotValid
+	}
+	return this.newReader(n.Content, offset, length)
+}
+
+func (this Restic) Mkdir(path string) error {
+	return ErrNotAllowed
+}
+
+func (this Restic) Rm(path string) error {
+	return ErrNotAllowed
+}
+
+func (this Restic) Mv(from string, to string) error {
+	return ErrNotAllowed
+}
+
+func (this Restic) Save(path string, file io.Reader) error {
+	return ErrNotAllowed
+}
+
+func (this Restic) Touch(path string) error {
+	return ErrNotAllowed
+}
+
+func (this Restic) Meta(path string) Metadata {
+	return Metadata{
+		CanCreateFile:      NewBool(false),
+		CanCreateDirectory: NewBool(false),
+		CanRename:          NewBool(false),
+		CanMove:            NewBool(false),
+		CanUpload:          NewBool(false),
+		CanDelete:          NewBool(false),
+	}
+}
+
+// find walks down the tree of a snapshot, the snapshot itself being seen as a folder
+func (this Restic) find(path string) (node, error) {
+	segments := []string{}
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if len(segments) == 0 {
+		return node{}, ErrNotFound
+	}
+	s, err := this.findSnapshot(segments[0])
+	if err != nil {
+		return node{}, err
+	}
+	current := node{Name: segments[0], Type: "dir", ModTime: s.Time, Subtree: s.Tree}
+	for _, name := range segments[1:] {
+		if current.Type != "dir" {
+			return node{}, ErrNotFound
+		}
+		nodes, err := this.tree(current.Subtree)
+		if err != nil {
+			return node{}, err
+		}
+		found := false
+		for _, n := range nodes {
+			if n.Name == name {
+				current, found = n, true
+				break
+			}
+		}
+		if found == false {
+			return node{}, ErrNotFound
+		}
+	}
+	return current, nil
+}
+
+// toFile gives what's shown of a node, only files and folders are: symlinks, devices, sockets
+// and such can't be restored through a download anyway
+func toFile(n node) (File, bool) {
+	switch n.Type {
+	case "dir":
+		return File{FName: n.Name, FType: "directory", FTime: n.ModTime.Unix()}, true
+	case "file":
+		return File{FName: n.Name, FType: "file", FSize: int64(n.Size), FTime: n.ModTime.Unix()}, true
+	}
+	return File{}, false
+}
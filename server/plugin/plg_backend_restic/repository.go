@@ -0,0 +1,354 @@
+package plg_backend_restic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * A repository is what we know about a restic repository once opened: its master key, where each
+ * blob lives and the snapshots. The files of a snapshot are made of data blobs and its folders of
+ * tree blobs, blobs being packed together in the files of the data folder. A repository only grows
+ * in between two prune so what's already known is kept and new index and snapshots are loaded as
+ * they show up.
+ */
+type repository struct {
+	id        string
+	version   int
+	keys      *keys
+	index     map[handle]blob
+	indexes   map[string]bool
+	snapshots map[string]*snapshot
+	mu        sync.RWMutex
+}
+
+type handle struct {
+	id   [32]byte
+	tree bool
+}
+
+type blob struct {
+	pack   [32]byte
+	offset uint32
+	length uint32
+	size   uint32
+	packed bool
+}
+
+type snapshot struct {
+	id       string
+	Time     time.Time `json:"time"`
+	Tree     string    `json:"tree"`
+	Paths    []string  `json:"paths"`
+	Hostname string    `json:"hostname"`
+}
+
+type node struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Mode    uint32    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	Size    uint64    `json:"size"`
+	Content []string  `json:"content"`
+	Subtree string    `json:"subtree"`
+}
+
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+func (this Restic) open(password string) (*repository, error) {
+	files, err := this.source.Ls(this.root + "keys/")
+	if err != nil {
+		return nil, err
+	}
+	var k *keys
+	err = ErrAuthenticationFailed
+	for _, f := range files {
+		content, e := this.read(this.root + "keys/" + f.Name())
+		if e != nil {
+			err = e
+			continue
+		} else if k, e = openKey(content, password); e == nil {
+			break
+		} else if e != ErrAuthenticationFailed {
+			err = e
+		}
+	}
+	if k == nil {
+		return nil, err
+	}
+
+	repo := &repository{
+		keys:      k,
+		index:     map[handle]blob{},
+		indexes:   map[string]bool{},
+		snapshots: map[string]*snapshot{},
+	}
+	content, err := this.read(this.root + "config")
+	if err != nil {
+		return nil, err
+	} else if content, err = k.decrypt(content); err != nil {
+		return nil, err
+	}
+	var config struct {
+		Version int    `json:"version"`
+		ID      string `json:"id"`
+	}
+	if err = json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	} else if config.Version < 1 || config.Version > 2 {
+		return nil, NewError("Unsupported repository version", 400)
+	}
+	repo.id, repo.version = config.ID, config.Version
+	return repo, nil
+}
+
+// unpacked reads a file stored on its own, like a snapshot or an index, which from version 2 of
+// the repository format may be compressed
+func (this Restic) unpacked(path string) ([]byte, error) {
+	content, err := this.read(path)
+	if err != nil {
+		return nil, err
+	} else if content, err = this.repo.keys.decrypt(content); err != nil {
+		return nil, err
+	} else if this.repo.version < 2 || len(content) == 0 || content[0] == '{' || content[0] == '[' {
+		return content, nil
+	} else if content[0] == 2 {
+		return zstdDecoder.DecodeAll(content[1:], nil)
+	}
+	return nil, NewError("Unsupported file format", 400)
+}
+
+func (this Restic) read(path string) ([]byte, error) {
+	r, err := this.source.Cat(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// refreshIndex loads the index files we haven't seen yet
+func (this Restic) refreshIndex() error {
+	files, err := this.source.Ls(this.root + "index/")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		this.repo.mu.RLock()
+		known := this.repo.indexes[f.Name()]
+		this.repo.mu.RUnlock()
+		if known {
+			continue
+		}
+		content, err := this.unpacked(this.root + "index/" + f.Name())
+		if err != nil {
+			Log.Debug("plg_backend_restic::index name=%s err=%s", f.Name(), err.Error())
+			continue
+		}
+		var idx struct {
+			Packs []struct {
+				ID    string `json:"id"`
+				Blobs []struct {
+					ID                 string `json:"id"`
+					Type               string `json:"type"`
+					Offset             uint32 `json:"offset"`
+					Length             uint32 `json:"length"`
+					UncompressedLength uint32 `json:"uncompressed_length"`
+				} `json:"blobs"`
+			} `json:"packs"`
+		}
+		if err = json.Unmarshal(content, &idx); err != nil {
+			Log.Debug("plg_backend_restic::index name=%s err=%s", f.Name(), err.Error())
+			continue
+		}
+		this.repo.mu.Lock()
+		for _, p := range idx.Packs {
+			pack, ok := decodeID(p.ID)
+			if ok == false {
+				continue
+			}
+			for _, b := range p.Blobs {
+				id, ok := decodeID(b.ID)
+				if ok == false {
+					continue
+				}
+				entry := blob{pack: pack, offset: b.Offset, length: b.Length, size: b.Length - IV_SIZE - MAC_SIZE}
+				if b.UncompressedLength > 0 {
+					entry.size, entry.packed = b.UncompressedLength, true
+				}
+				this.repo.index[handle{id, b.Type == "tree"}] = entry
+			}
+		}
+		this.repo.indexes[f.Name()] = true
+		this.repo.mu.Unlock()
+	}
+	return nil
+}
+
+func (this Restic) lookup(id string, tree bool) (blob, error) {
+	h, ok := decodeID(id)
+	if ok == false {
+		return blob{}, ErrNotValid
+	}
+	for i := 0; i < 2; i++ {
+		this.repo.mu.RLock()
+		b, ok := this.repo.index[handle{h, tree}]
+		this.repo.mu.RUnlock()
+		if ok {
+			return b, nil
+		} else if i == 0 {
+			if err := this.refreshIndex(); err != nil {
+				return blob{}, err
+			}
+		}
+	}
+	return blob{}, ErrNotFound
+}
+
+// load gives the content of a blob after checking it is what it's supposed to be
+func (this Restic) load(id string, tree bool) ([]byte, error) {
+	b, err := this.lookup(id, tree)
+	if err != nil {
+		return nil, err
+	}
+	pack := hex.EncodeToString(b.pack[:])
+	path := this.root + "data/" + pack[:2] + "/" + pack
+	var r io.ReadCloser
+	if source, ok := this.source.(IBackendRange); ok {
+		r, err = source.CatRange(path, int64(b.offset), int64(b.length))
+	} else if r, err = this.source.Cat(path); err == nil {
+		if _, err = io.CopyN(io.Discard, r, int64(b.offset)); err != nil {
+			r.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, b.length)
+	_, err = io.ReadFull(r, content)
+	r.Close()
+	if err != nil {
+		return nil, err
+	} else if content, err = this.repo.keys.decrypt(content); err != nil {
+		return nil, err
+	} else if b.packed {
+		if content, err = zstdDecoder.DecodeAll(content, make([]byte, 0, b.size)); err != nil {
+			return nil, err
+		}
+	}
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != id {
+		Log.Warning("plg_backend_restic::load id=%s pack=%s err=corrupted blob", id, pack)
+		return nil, NewError("Corrupted blob "+id, 500)
+	}
+	return content, nil
+}
+
+func (this Restic) tree(id string) ([]node, error) {
+	key := map[string]string{"repo": this.repo.id, "tree": id}
+	if obj := TreeCache.Get(key); obj != nil {
+		return obj.([]node), nil
+	}
+	content, err := this.load(id, true)
+	if err != nil {
+		return nil, err
+	}
+	var t struct {
+		Nodes []node `json:"nodes"`
+	}
+	if err = json.Unmarshal(content, &t); err != nil {
+		return nil, err
+	}
+	TreeCache.Set(key, t.Nodes)
+	return t.Nodes, nil
+}
+
+// listSnapshots gives the snapshots from the oldest to the most recent
+func (this Restic) listSnapshots() ([]*snapshot, error) {
+	files, err := this.source.Ls(this.root + "snapshots/")
+	if err != nil {
+		return nil, err
+	}
+	list := []*snapshot{}
+	for _, f := range files {
+		this.repo.mu.RLock()
+		s := this.repo.snapshots[f.Name()]
+		this.repo.mu.RUnlock()
+		if s == nil {
+			content, err := this.unpacked(this.root + "snapshots/" + f.Name())
+			if err != nil {
+				Log.Debug("plg_backend_restic::snapshot id=%s err=%s", f.Name(), err.Error())
+				continue
+			}
+			s = &snapshot{id: f.Name()}
+			if err = json.Unmarshal(content, s); err != nil || len(s.id) < 8 {
+				continue
+			}
+			this.repo.mu.Lock()
+			this.repo.snapshots[s.id] = s
+			this.repo.mu.Unlock()
+		}
+		list = append(list, s)
+	}
+	this.repo.mu.Lock()
+	for id := range this.repo.snapshots {
+		if slices.ContainsFunc(list, func(s *snapshot) bool { return s.id == id }) == false {
+			delete(this.repo.snapshots, id)
+		}
+	}
+	this.repo.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	return list, nil
+}
+
+// name is how a snapshot is listed, the short id at the end is how we find it back
+func (this *snapshot) name() string {
+	host := strings.ReplaceAll(this.Hostname, "/", "_")
+	if host == "" {
+		host = "unknown"
+	}
+	return this.Time.Local().Format("2006-01-02 150405") + " " + host + " " + this.id[:8]
+}
+
+func (this Restic) findSnapshot(name string) (*snapshot, error) {
+	short := name[strings.LastIndex(name, " ")+1:]
+	if len(short) < 8 {
+		return nil, ErrNotFound
+	}
+	this.repo.mu.RLock()
+	for id, s := range this.repo.snapshots {
+		if strings.HasPrefix(id, short) {
+			this.repo.mu.RUnlock()
+			return s, nil
+		}
+	}
+	this.repo.mu.RUnlock()
+	list, err := this.listSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range list {
+		if strings.HasPrefix(s.id, short) {
+			return s, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func decodeID(s string) ([32]byte, bool) {
+	var id [32]byte
+	if len(s) != 64 {
+		return id, false
+	}
+	_, err := hex.Decode(id[:], []byte(s))
+	return id, err == nil
+}
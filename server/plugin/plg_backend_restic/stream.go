@@ -0,0 +1,68 @@
+package plg_backend_restic
+
+import (
+	"io"
+)
+
+/*
+ * A file is the content of its data blobs put one after the other. Blobs are fetched as the file
+ * is read so only one of them, a few MB at most, is in memory at any time. For a range read, the
+ * blobs before the offset are skipped using the sizes of the index, without being fetched.
+ */
+type blobReader struct {
+	backend Restic
+	blobs   []string
+	skip    int64
+	left    int64
+	buf     []byte
+}
+
+func (this Restic) newReader(blobs []string, offset int64, length int64) (io.ReadCloser, error) {
+	for len(blobs) > 0 && offset > 0 {
+		b, err := this.lookup(blobs[0], false)
+		if err != nil {
+			return nil, err
+		} else if offset < int64(b.size) {
+			break
+		}
+		offset -= int64(b.size)
+		blobs = blobs[1:]
+	}
+	return &blobReader{backend: this, blobs: blobs, skip: offset, left: length}, nil
+}
+
+func (this *blobReader) Read(p []byte) (int, error) {
+	if this.left == 0 {
+		return 0, io.EOF
+	}
+	for len(this.buf) == 0 {
+		if len(this.blobs) == 0 {
+			return 0, io.EOF
+		}
+		content, err := this.backend.load(this.blobs[0], false)
+		if err != nil {
+			return 0, err
+		}
+		this.blobs = this.blobs[1:]
+		if this.skip > 0 {
+			content = content[min(this.skip, int64(len(content))):]
+			this.skip = 0
+		}
+		this.buf = content
+	}
+	if this.left > 0 && int64(len(p)) > this.left {
+		p = p[:this.left]
+	}
+	n := copy(p, this.buf)
+	this.buf = this.buf[n:]
+	if this.left > 0 {
+		this.left -= int64(n)
+	}
+	return n, nil
+}
+
+func (this *blobReader) Close() error {
+	this.buf = nil
+	this.blobs = nil
+	return nil
+}
@@ -0,0 +1,43 @@
+package plg_backend_s3
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// Capacity relies on the storage metrics AWS publishes about a bucket once a day. There's no
+// such thing as running out of space on S3 so only the usage is known
+func (this S3Backend) Capacity(path string) (BackendCapacity, error) {
+	p := this.path(path)
+	if p.bucket == "" || this.params["endpoint"] != "" {
+		return BackendCapacity{}, ErrNotImplemented
+	}
+	res, err := cloudwatch.New(this.createSession(p.bucket)).GetMetricStatisticsWithContext(this.Context, &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/S3"),
+		MetricName: aws.String("BucketSizeBytes"),
+		Dimensions: []*cloudwatch.Dimension{
+			{Name: aws.String("BucketName"), Value: aws.String(p.bucket)},
+			{Name: aws.String("StorageType"), Value: aws.String("StandardStorage")},
+		},
+		StartTime:  aws.Time(time.Now().Add(-72 * time.Hour)),
+		EndTime:    aws.Time(time.Now()),
+		Period:     aws.Int64(86400),
+		Statistics: []*string{aws.String(cloudwatch.StatisticAverage)},
+	})
+	if err != nil {
+		return BackendCapacity{}, err
+	}
+	var latest *cloudwatch.Datapoint
+	for _, d := range res.Datapoints {
+		if latest == nil || d.Timestamp.After(*latest.Timestamp) {
+			latest = d
+		}
+	}
+	if latest == nil || latest.Average == nil {
+		return BackendCapacity{}, ErrNotFound
+	}
+	return BackendCapacity{Used: int64(*latest.Average), Available: -1}, nil
+}
@@ -0,0 +1,59 @@
+package plg_backend_s3
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// Checksum uses what S3 stores with the object: the sha1 or sha256 checksum when the object was
+// uploaded with one, and the ETag which is the md5 of the content unless the object was uploaded
+// in many parts or is encrypted with a customer key. Composite checksums of multipart uploads
+// aren't the hash of the content and are ignored
+func (this S3Backend) Checksum(path string, algo string) (string, error) {
+	p := this.path(path)
+	if p.bucket == "" || p.path == "" {
+		return "", ErrNotImplemented
+	}
+	input := &s3.HeadObjectInput{
+		Bucket:       aws.String(p.bucket),
+		Key:          aws.String(p.path),
+		ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+	}
+	if this.params["encryption_key"] != "" {
+		input.SSECustomerAlgorithm = aws.String("AES256")
+		input.SSECustomerKey = aws.String(this.params["encryption_key"])
+	}
+	obj, err := s3.New(this.createSession(p.bucket)).HeadObjectWithContext(this.Context, input)
+	if err != nil {
+		return "", err
+	}
+	var value *string
+	switch algo {
+	case CHECKSUM_SHA1:
+		value = obj.ChecksumSHA1
+	case CHECKSUM_SHA256:
+		value = obj.ChecksumSHA256
+	case CHECKSUM_MD5:
+		if obj.ETag == nil || this.params["encryption_key"] != "" || obj.SSEKMSKeyId != nil {
+			return "", ErrNotImplemented
+		}
+		etag := strings.Trim(*obj.ETag, `"`)
+		if strings.Contains(etag, "-") {
+			return "", ErrNotImplemented
+		}
+		return etag, nil
+	}
+	if value == nil || strings.Contains(*value, "-") {
+		return "", ErrNotImplemented
+	}
+	b, err := base64.StdEncoding.DecodeString(*value)
+	if err != nil {
+		return "", ErrNotImplemented
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -302,11 +302,20 @@ func (this S3Backend) Stat(path string) (os.FileInfo, error) {
 }
 
 func (this S3Backend) Cat(path string) (io.ReadCloser, error) {
+	return this.cat(path, nil)
+}
+
+func (this S3Backend) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	return this.cat(path, aws.String(HTTPRange(offset, length)))
+}
+
+func (this S3Backend) cat(path string, rng *string) (io.ReadCloser, error) {
 	p := this.path(path)
 	client := s3.New(this.createSession(p.bucket))
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(p.bucket),
 		Key:    aws.String(p.path),
+		Range:  rng,
 	}
 	if this.params["encryption_key"] != "" {
 		input.SSECustomerAlgorithm = aws.String("AES256")
@@ -0,0 +1,44 @@
+package plg_backend_sftp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+var checksumCommands = map[string]string{
+	CHECKSUM_MD5:    "md5sum",
+	CHECKSUM_SHA1:   "sha1sum",
+	CHECKSUM_SHA256: "sha256sum",
+	CHECKSUM_BLAKE3: "b3sum",
+}
+
+// Checksum hashes the file on the remote host so the content doesn't have to travel through the
+// network. Servers that don't let us run commands, like the ones restricted to sftp, or which
+// don't have the tool installed are taken care of by the fallback reading the file
+func (b Sftp) Checksum(path string, algo string) (string, error) {
+	cmd, ok := checksumCommands[algo]
+	if !ok {
+		return "", ErrNotImplemented
+	}
+	session, err := b.SSHClient.NewSession()
+	if err != nil {
+		return "", ErrNotImplemented
+	}
+	defer session.Close()
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err = session.Run(cmd + " -- '" + strings.ReplaceAll(path, "'", `'\''`) + "'"); err != nil {
+		Log.Debug("plg_backend_sftp::checksum cmd=%s err=%s", cmd, err.Error())
+		return "", ErrNotImplemented
+	}
+	fields := strings.Fields(stdout.String())
+	if len(fields) == 0 {
+		return "", ErrNotImplemented
+	} else if _, err = hex.DecodeString(strings.TrimPrefix(fields[0], `\`)); err != nil {
+		return "", ErrNotImplemented
+	}
+	return strings.TrimPrefix(fields[0], `\`), nil
+}
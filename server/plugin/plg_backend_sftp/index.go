@@ -265,8 +265,15 @@ func (b Sftp) Home() (string, error) {
 }
 
 func (b Sftp) Ls(path string) ([]os.FileInfo, error) {
-	files, err := b.SFTPClient.ReadDir(path)
-	return files, b.err(err)
+	infos, err := b.SFTPClient.ReadDir(path)
+	if err != nil {
+		return nil, b.err(err)
+	}
+	files := make([]os.FileInfo, len(infos))
+	for i := range infos {
+		files[i] = b.toFile(path+infos[i].Name(), infos[i])
+	}
+	return files, nil
 }
 
 func (b Sftp) Cat(path string) (io.ReadCloser, error) {
@@ -277,6 +284,14 @@ func (b Sftp) Cat(path string) (io.ReadCloser, error) {
 	return remoteFile, nil
 }
 
+func (b Sftp) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	remoteFile, err := b.SFTPClient.OpenFile(path, os.O_RDONLY)
+	if err != nil {
+		return nil, b.err(err)
+	}
+	return NewSectionReadCloser(remoteFile, offset, length), nil
+}
+
 func (b Sftp) Mkdir(path string) error {
 	err := b.SFTPClient.Mkdir(path)
 	return b.err(err)
@@ -340,8 +355,71 @@ func (b Sftp) Save(path string, file io.Reader) error {
 }
 
 func (b Sftp) Stat(path string) (os.FileInfo, error) {
-	f, err := b.SFTPClient.Stat(path)
-	return f, b.err(err)
+	f, err := b.SFTPClient.Lstat(strings.TrimSuffix(path, "/"))
+	if err != nil {
+		return nil, b.err(err)
+	}
+	return b.toFile(path, f), nil
+}
+
+func (b Sftp) Chmod(path string, mode os.FileMode) error {
+	return b.err(b.SFTPClient.Chmod(path, mode))
+}
+
+// Chown sets both owner and group as SFTP can't change one without the other
+func (b Sftp) Chown(path string, uid int, gid int) error {
+	if uid < 0 || gid < 0 {
+		f, err := b.SFTPClient.Stat(path)
+		if err != nil {
+			return b.err(err)
+		}
+		st, ok := f.Sys().(*sftp.FileStat)
+		if ok == false {
+			return ErrNotImplemented
+		}
+		if uid < 0 {
+			uid = int(st.UID)
+		}
+		if gid < 0 {
+			gid = int(st.GID)
+		}
+	}
+	return b.err(b.SFTPClient.Chown(path, uid, gid))
+}
+
+func (b Sftp) Symlink(target string, path string) error {
+	return b.err(b.SFTPClient.Symlink(target, strings.TrimSuffix(path, "/")))
+}
+
+func (b Sftp) Readlink(path string) (string, error) {
+	target, err := b.SFTPClient.ReadLink(strings.TrimSuffix(path, "/"))
+	return target, b.err(err)
+}
+
+// toFile keeps the permissions and owner of a file, a symlink being shown as what it points to
+func (b Sftp) toFile(path string, info os.FileInfo) File {
+	f := File{
+		FName: info.Name(),
+		FType: "file",
+		FSize: info.Size(),
+		FTime: info.ModTime().Unix(),
+		Posix: &FilePosix{Mode: UnixMode(info.Mode()), Uid: -1, Gid: -1},
+	}
+	if st, ok := info.Sys().(*sftp.FileStat); ok {
+		f.Posix.Mode, f.Posix.Uid, f.Posix.Gid = st.Mode&07777, int(st.UID), int(st.GID)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		path = strings.TrimSuffix(path, "/")
+		f.Posix.Link, _ = b.SFTPClient.ReadLink(path)
+		if target, err := b.SFTPClient.Stat(path); err == nil {
+			info = target
+			f.FSize = target.Size()
+		}
+	}
+	if info.IsDir() {
+		f.FType = "directory"
+	}
+	return f
 }
 
 func (b Sftp) Close() error {
@@ -0,0 +1,171 @@
+package plg_backend_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	_ "github.com/mickael-kerjean/filestash/server/pkg/sqlite"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+ * The sqlite backend shows a database file the same way the psql backend does: tables are
+ * folders and rows are forms. The file is either a path on the server, which needs the admin
+ * password, or, when a source is given, a file from another backend that is fetched locally and
+ * sent back after every change.
+ */
+type SQLite struct {
+	db     *sql.DB
+	ctx    context.Context
+	source *sqliteSource
+}
+
+func init() {
+	Backend.Register("sqlite", SQLite{})
+}
+
+func (this SQLite) Init(params map[string]string, app *App) (IBackend, error) {
+	path := params["database"]
+	if path == "" {
+		return nil, ErrNotValid
+	}
+	backend := &SQLite{ctx: app.Context}
+	if params["source"] != "" {
+		source, err := openSource(params, app)
+		if err != nil {
+			Log.Debug("plg_backend_sqlite::init action=source err=%s", err.Error())
+			return nil, err
+		}
+		backend.source = source
+		path = source.local
+	} else if isAdmin(params["password"]) == false {
+		// a file on the server can be anything the server can read, only the admin gets to open it
+		return nil, ErrAuthenticationFailed
+	} else if _, err := os.Stat(path); err != nil {
+		return nil, ErrNotFound
+	}
+	mode := "rw"
+	if params["readonly"] == "true" {
+		mode = "ro"
+	}
+	db, err := sql.Open("sqlite3", "file:"+strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(path)+"?mode="+mode)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // pragmas are set per connection
+	if _, err = db.ExecContext(app.Context, "SELECT COUNT(*) FROM sqlite_master"); err != nil {
+		Log.Debug("plg_backend_sqlite::init err=%s", err.Error())
+		db.Close()
+		return nil, NewError("Not a sqlite database", 400)
+	}
+	db.ExecContext(app.Context, "PRAGMA foreign_keys = ON")
+	backend.db = db
+	return backend, nil
+}
+
+func (this SQLite) LoginForm() Form {
+	return Form{
+		Elmnts: []FormElement{
+			FormElement{
+				Name:  "type",
+				Type:  "hidden",
+				Value: "sqlite",
+			},
+			FormElement{
+				Name:        "database",
+				Type:        "text",
+				Placeholder: "Path to the database file",
+			},
+			FormElement{
+				Name:        "password",
+				Type:        "password",
+				Placeholder: "Admin Password",
+				Description: "Needed to open a file on the server, not when it comes from a source backend",
+			},
+			FormElement{
+				Name:        "advanced",
+				Type:        "enable",
+				Placeholder: "Advanced",
+				Target:      []string{"sqlite_source", "sqlite_readonly"},
+			},
+			FormElement{
+				Id:          "sqlite_source",
+				Name:        "source",
+				Type:        "text",
+				Placeholder: "Source backend",
+				Description: "Fetch the file from another backend, eg: s3. Its parameters are the ones prefixed with 'source_'",
+			},
+			FormElement{
+				Id:          "sqlite_readonly",
+				Name:        "readonly",
+				Type:        "boolean",
+				Placeholder: "Read only",
+			},
+		},
+	}
+}
+
+func isAdmin(password string) bool {
+	if password == "" {
+		return false
+	} else if password == Config.Get("general.secret_key").String() {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(Config.Get("auth.admin").String()), []byte(password)) == nil
+}
+
+func (this SQLite) Touch(path string) error {
+	defer this.Close()
+	if !strings.HasSuffix(path, ".form") {
+		return NewError("Create a form file instead. eg: xxxx.form", 403)
+	}
+	return nil
+}
+
+func (this SQLite) Mkdir(path string) error {
+	defer this.Close()
+	return ErrNotValid
+}
+
+func (this SQLite) Mv(from string, to string) error {
+	defer this.Close()
+	return ErrNotValid
+}
+
+func (this SQLite) Meta(path string) Metadata {
+	location, _ := getPath(path)
+	return Metadata{
+		CanCreateDirectory: NewBool(false),
+		CanCreateFile: func(l LocationRow) *bool {
+			if l.table == "" {
+				return NewBool(false)
+			}
+			return NewBool(true)
+		}(location),
+		CanRename: NewBool(false),
+		CanDelete: func(l LocationRow) *bool {
+			if l.table == "" {
+				return NewBool(false)
+			}
+			return NewBool(true)
+		}(location),
+		CanMove: NewBool(false),
+		CanUpload: func(l LocationRow) *bool {
+			if l.table == "" {
+				return NewBool(false)
+			}
+			return NewBool(true)
+		}(location),
+		RefreshOnCreate: NewBool(true),
+		HideExtension:   NewBool(true),
+	}
+}
+
+func (this SQLite) Close() error {
+	this.db.Close()
+	return nil
+}
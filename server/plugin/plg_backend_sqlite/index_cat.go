@@ -0,0 +1,111 @@
+package plg_backend_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/pkg/tabular"
+)
+
+func (this SQLite) Cat(path string) (io.ReadCloser, error) {
+	if name := filepath.Base(path); tabular.IsExport(name) {
+		return this.export(path, name)
+	}
+	defer this.Close()
+	l, err := getPath(path)
+	if err != nil {
+		return nil, err
+	}
+	columns, key, err := processTable(this.ctx, this.db, l.table)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := this.db.QueryContext(this.ctx, `
+		SELECT *
+			FROM `+quote(l.table)+`
+			WHERE `+quote(key)+` = ?
+    `, l.row)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	c, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	} else if len(columns) != len(c) {
+		Log.Error("plg_backend_sqlite::index_cat columns is not of the expected size columns[%d]=%v c[%d]=%v", len(columns), columns, len(c), c)
+		return nil, ErrNotValid
+	}
+	i := 0
+	col := make([]interface{}, len(c))
+	for rows.Next() {
+		if i != 0 {
+			return nil, ErrNotValid
+		}
+		pcol := make([]any, len(c))
+		for i, _ := range pcol {
+			pcol[i] = &col[i]
+		}
+		if err := rows.Scan(pcol...); err != nil {
+			return nil, err
+		}
+		i += 1
+	}
+	tableSQL := _tableSQL(this.ctx, this.db, l.table)
+	forms := make([]FormElement, len(c))
+	for i, _ := range columns {
+		forms[i] = createFormElement(col[i], columns[i])
+		if columns[i].PrimaryKey && forms[i].Value != nil {
+			forms[i].ReadOnly = true
+		} else if columns[i].ForeignKey != nil {
+			link := *columns[i].ForeignKey
+			if values, err := _findRelationValues(this.ctx, this.db, link); err == nil && len(values) > 0 {
+				forms[i].Type = "select"
+				forms[i].Opts = values
+			}
+			forms[i].Description = _createDescription(link)
+		} else if values := _findEnumValues(tableSQL, columns[i]); len(values) > 0 {
+			forms[i].Type = "select"
+			forms[i].Opts = values
+		}
+	}
+	b, err := Form{Elmnts: forms}.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return NewReadCloserFromBytes(b), nil
+}
+
+func (this SQLite) Stat(path string) (os.FileInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+func _createDescription(link LocationColumn) string {
+	return fmt.Sprintf("points to [<%s> → <%s>](/files/%s/)", link.table, link.column, link.table)
+}
+
+func _findRelationValues(ctx context.Context, db *sql.DB, link LocationColumn) ([]string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT DISTINCT %s FROM %s ORDER BY %s LIMIT 5000`,
+		quote(link.column), quote(link.table), quote(link.column),
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var value any
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		} else if value != nil {
+			values = append(values, fmt.Sprintf("%v", convertFromDB(value, Column{})))
+		}
+	}
+	return values, nil
+}
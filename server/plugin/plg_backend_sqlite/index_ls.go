@@ -0,0 +1,90 @@
+package plg_backend_sqlite
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/pkg/tabular"
+)
+
+func (this SQLite) Ls(path string) ([]os.FileInfo, error) {
+	defer this.Close()
+	l, err := getPath(path)
+	if err != nil {
+		Log.Debug("plg_backend_sqlite::ls method=getPath err=%s", err.Error())
+		return nil, err
+	}
+	if l.table == "" {
+		rows, err := this.db.QueryContext(this.ctx, `
+            SELECT name FROM sqlite_master
+                WHERE type = 'table'
+                AND name NOT LIKE 'sqlite_%'
+                ORDER BY name
+        `)
+		if err != nil {
+			Log.Debug("plg_backend_sqlite::ls method=query err=%s", err.Error())
+			return nil, err
+		}
+		defer rows.Close()
+		out := []os.FileInfo{}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				Log.Debug("plg_backend_sqlite::ls method=scan err=%s", err.Error())
+				return nil, err
+			}
+			out = append(out, File{
+				FName: name,
+				FType: "directory",
+			})
+		}
+		return out, nil
+	} else if l.row == "" {
+		columns, key, err := processTable(this.ctx, this.db, l.table)
+		if err != nil {
+			return nil, err
+		}
+		query := `SELECT ` + quote(key) + `, NULL FROM ` + quote(l.table) + ` LIMIT 500000`
+		for _, c := range columns {
+			if isDatetime(c) {
+				query = `SELECT ` + quote(key) + `, ` + quote(c.Name) + ` FROM ` + quote(l.table) + ` LIMIT 500000`
+				break
+			}
+		}
+		rows, err := this.db.QueryContext(this.ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		out := []os.FileInfo{}
+		for _, f := range tabular.Files() {
+			out = append(out, f)
+		}
+		for rows.Next() {
+			var name, t any
+			if err = rows.Scan(&name, &t); err != nil {
+				return nil, err
+			}
+			out = append(out, File{
+				FName: fmt.Sprintf("%v", convertFromDB(name, Column{})) + ".form",
+				FType: "file",
+				FTime: func() int64 {
+					switch v := t.(type) {
+					case time.Time:
+						return v.Unix()
+					case string:
+						if tm, err := parseTime(v); err == nil {
+							return tm.Unix()
+						}
+					}
+					return 0
+				}(),
+				FSize: -1,
+			})
+		}
+		return out, nil
+	}
+	return []os.FileInfo{}, ErrNotValid
+}
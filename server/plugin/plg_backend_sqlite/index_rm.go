@@ -0,0 +1,32 @@
+package plg_backend_sqlite
+
+import (
+	"path/filepath"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/pkg/tabular"
+)
+
+func (this SQLite) Rm(path string) error {
+	defer this.Close()
+	l, err := getPath(path)
+	if err != nil {
+		return err
+	} else if l.table == "" {
+		return ErrNotFound
+	} else if tabular.IsExport(filepath.Base(path)) {
+		return ErrNotAllowed
+	}
+	_, key, err := processTable(this.ctx, this.db, l.table)
+	if err != nil {
+		return err
+	}
+	if _, err = this.db.ExecContext(
+		this.ctx,
+		`DELETE FROM `+quote(l.table)+` WHERE `+quote(key)+` = ?`,
+		l.row,
+	); err != nil {
+		return err
+	}
+	return this.source.push()
+}
@@ -0,0 +1,126 @@
+package plg_backend_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestGetPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		table string
+		row   string
+		err   bool
+	}{
+		{path: "/", table: "", row: ""},
+		{path: "/users", table: "users", row: ""},
+		{path: "/users/", table: "users", row: ""},
+		{path: "/users/42.form", table: "users", row: "42"},
+		{path: "users/42.form", err: true},
+		{path: "/users/42/extra", err: true},
+		{path: `/us"ers/42.form`, err: true},
+	}
+	for _, test := range tests {
+		l, err := getPath(test.path)
+		if test.err {
+			if err == nil {
+				t.Errorf("getPath(%q) should fail", test.path)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("getPath(%q) err=%s", test.path, err.Error())
+			continue
+		}
+		if l.table != test.table || l.row != test.row {
+			t.Errorf("getPath(%q) got table=%q row=%q", test.path, l.table, l.row)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		"users":            `"users"`,
+		"my table":         `"my table"`,
+		`we"ird`:           `"we""ird"`,
+		`x"; DROP TABLE y`: `"x""; DROP TABLE y"`,
+	}
+	for in, expected := range tests {
+		if got := quote(in); got != expected {
+			t.Errorf("quote(%q) got %s want %s", in, got, expected)
+		}
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	backend := newTestBackend(t, `CREATE TABLE people (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		age INTEGER
+	)`)
+
+	if err := backend.importCSV("/people", strings.NewReader("name,age\nalice,30\nbob,\n")); err != nil {
+		t.Fatalf("import err=%s", err.Error())
+	}
+	if n := countRows(t, backend.db, "people"); n != 2 {
+		t.Fatalf("expected 2 rows, got %d", n)
+	}
+
+	// a line that can't be inserted cancels the whole import
+	if err := backend.importCSV("/people", strings.NewReader("name,age\ncarol,40\ndave,notanumber\n")); err == nil {
+		t.Fatal("import of an invalid csv should fail")
+	}
+	if n := countRows(t, backend.db, "people"); n != 2 {
+		t.Fatalf("a failed import should leave the table untouched, got %d rows", n)
+	}
+
+	if err := backend.importCSV("/people", strings.NewReader("name,unknown\nerin,1\n")); err == nil {
+		t.Fatal("import with an unknown column should fail")
+	}
+	if err := backend.importCSV("/", strings.NewReader("name\nfrank\n")); err != ErrNotValid {
+		t.Fatal("import outside of a table should fail")
+	}
+}
+
+func TestInitNeedsAdmin(t *testing.T) {
+	backend := newTestBackend(t, `CREATE TABLE t (id INTEGER PRIMARY KEY)`)
+	var file string
+	backend.db.QueryRow("SELECT file FROM pragma_database_list WHERE name='main'").Scan(&file)
+	backend.db.Close()
+
+	app := &App{Context: context.Background()}
+	if _, err := (SQLite{}).Init(map[string]string{"database": file}, app); err != ErrAuthenticationFailed {
+		t.Fatalf("opening a file on the server without the admin password should fail, got %v", err)
+	}
+	if _, err := (SQLite{}).Init(map[string]string{"database": "/does/not/exist.db"}, app); err != ErrAuthenticationFailed {
+		t.Fatalf("without the admin password, we shouldn't tell if a file exists, got %v", err)
+	}
+	if _, err := (SQLite{}).Init(map[string]string{"path": file}, app); err != ErrNotValid {
+		t.Fatalf("the path is the chroot of the session, not the database, got %v", err)
+	}
+}
+
+func newTestBackend(t *testing.T, schema string) *SQLite {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if _, err = db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	return &SQLite{db: db, ctx: context.Background()}
+}
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + quote(table)).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
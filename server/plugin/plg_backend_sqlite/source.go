@@ -0,0 +1,109 @@
+package plg_backend_sqlite
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+/*
+ * A database coming from another backend is downloaded in the cache folder and kept there for as
+ * long as the remote file doesn't change. The source goes through the same checks as any other
+ * connection so it can only be a backend the admin has configured.
+ */
+var sourceCache AppCache
+
+func init() {
+	sourceCache = NewAppCache(10, 5)
+	sourceCache.OnEvict(func(key string, value interface{}) {
+		if s, ok := value.(*sqliteSource); ok {
+			s.mu.Lock()
+			os.Remove(s.local)
+			s.mu.Unlock()
+		}
+	})
+}
+
+type sqliteSource struct {
+	backend IBackend
+	remote  string
+	local   string
+	version string
+	mu      sync.Mutex
+}
+
+func openSource(params map[string]string, app *App) (*sqliteSource, error) {
+	backend, err := model.NewBackend(app, model.SourceParams(params))
+	if err != nil {
+		return nil, err
+	}
+	remote := params["database"]
+	version := sourceVersion(backend, remote)
+	if c := sourceCache.Get(params); c != nil && version != "" && c.(*sqliteSource).version == version {
+		s := c.(*sqliteSource)
+		s.mu.Lock()
+		s.backend = backend
+		s.mu.Unlock()
+		return s, nil
+	}
+
+	s := &sqliteSource{
+		backend: backend,
+		remote:  remote,
+		local:   GetAbsolutePath(TMP_PATH, "sqlite_"+QuickString(12)+".db"),
+		version: version,
+	}
+	r, err := backend.Cat(remote)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	f, err := os.OpenFile(s.local, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(s.local)
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(s.local)
+		return nil, err
+	}
+	sourceCache.Set(params, s)
+	return s, nil
+}
+
+// push sends the local copy back where it came from once it has been changed
+func (this *sqliteSource) push() error {
+	if this == nil {
+		return nil
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	f, err := os.Open(this.local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = this.backend.Save(this.remote, f); err != nil {
+		Log.Warning("plg_backend_sqlite::push path=%s err=%s", this.remote, err.Error())
+		return err
+	}
+	this.version = sourceVersion(this.backend, this.remote)
+	return nil
+}
+
+// sourceVersion tells when the remote file has changed, an empty version meaning we can't know
+func sourceVersion(backend IBackend, path string) string {
+	f, err := backend.Stat(path)
+	if err != nil || f.ModTime().Unix() <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", f.Size(), f.ModTime().UnixNano())
+}
@@ -0,0 +1,23 @@
+package plg_backend_sqlite
+
+type Column struct {
+	Table      string
+	Name       string
+	Type       string
+	Nullable   bool
+	Default    bool
+	PrimaryKey bool
+	Unique     bool
+	ForeignKey *LocationColumn
+}
+
+type LocationRow struct {
+	table string
+	row   string
+}
+
+type LocationColumn struct {
+	table  string
+	column string
+	values []string
+}
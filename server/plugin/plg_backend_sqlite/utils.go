@@ -0,0 +1,281 @@
+package plg_backend_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func getPath(path string) (LocationRow, error) {
+	l := LocationRow{}
+	for i, chunk := range strings.Split(path, "/") {
+		if i == 0 {
+			if chunk != "" {
+				return l, ErrNotValid
+			}
+		} else if i == 1 {
+			if strings.Contains(chunk, `"`) {
+				return l, ErrNotValid
+			}
+			l.table = chunk
+		} else if i == 2 {
+			l.row = strings.TrimSuffix(chunk, ".form")
+		} else {
+			return l, ErrNotValid
+		}
+	}
+	return l, nil
+}
+
+// processTable gives the columns of a table and the one used to name its rows. Tables without
+// a good candidate are named after their rowid, which every table has unless made WITHOUT ROWID
+func processTable(ctx context.Context, db *sql.DB, table string) ([]Column, string, error) {
+	columns, err := _getColumns(ctx, db, table)
+	if err != nil {
+		return nil, "", err
+	} else if len(columns) == 0 {
+		return nil, "", ErrNotFound
+	}
+	key := ""
+	score := 0
+	for _, column := range columns {
+		if c := _calculateScore(column); c > score {
+			key = column.Name
+			score = c
+		}
+	}
+	if key == "" {
+		if strings.Contains(strings.ToUpper(_tableSQL(ctx, db, table)), "WITHOUT ROWID") {
+			return columns, "", ErrNotValid
+		}
+		key = "rowid"
+	}
+	return columns, key, nil
+}
+
+func _getColumns(ctx context.Context, db *sql.DB, table string) ([]Column, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT name, type, "notnull", dflt_value IS NOT NULL, pk
+            FROM pragma_table_info(?)
+            ORDER BY cid
+    `, table)
+	if err != nil {
+		return nil, err
+	}
+	columns := []Column{}
+	for rows.Next() {
+		var c Column
+		var notnull, pk int
+		if err := rows.Scan(&c.Name, &c.Type, &notnull, &c.Default, &pk); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c.Table = table
+		c.Type = strings.ToLower(c.Type)
+		c.Nullable = notnull == 0
+		c.PrimaryKey = pk > 0
+		if c.PrimaryKey && c.Type == "integer" {
+			c.Default = true // alias of the rowid, sqlite picks one when it isn't given
+		}
+		columns = append(columns, c)
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+	unique, err := _findUniqueColumns(ctx, db, table)
+	if err != nil {
+		return nil, err
+	}
+	relations, err := _findRelations(ctx, db, table)
+	if err != nil {
+		return nil, err
+	}
+	for i := range columns {
+		columns[i].Unique = unique[columns[i].Name]
+		if l, ok := relations[columns[i].Name]; ok {
+			columns[i].ForeignKey = &l
+		}
+	}
+	return columns, nil
+}
+
+// _findUniqueColumns gives the columns that are unique on their own
+func _findUniqueColumns(ctx context.Context, db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM pragma_index_list(?) WHERE "unique" = 1`, table)
+	if err != nil {
+		return nil, err
+	}
+	indexes := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+	unique := map[string]bool{}
+	for _, index := range indexes {
+		rows, err := db.QueryContext(ctx, `SELECT name FROM pragma_index_info(?)`, index)
+		if err != nil {
+			return nil, err
+		}
+		names := []string{}
+		for rows.Next() {
+			var name sql.NullString
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			names = append(names, name.String)
+		}
+		rows.Close()
+		if len(names) == 1 && names[0] != "" {
+			unique[names[0]] = true
+		}
+	}
+	return unique, nil
+}
+
+func _findRelations(ctx context.Context, db *sql.DB, table string) (map[string]LocationColumn, error) {
+	rows, err := db.QueryContext(ctx, `SELECT "from", "table", "to" FROM pragma_foreign_key_list(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	relations := map[string]LocationColumn{}
+	for rows.Next() {
+		var from, to sql.NullString
+		var l LocationColumn
+		if err := rows.Scan(&from, &l.table, &to); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		l.column = to.String
+		relations[from.String] = l
+	}
+	rows.Close()
+	for from, l := range relations {
+		if l.column != "" {
+			continue
+		}
+		// a foreign key without a column points to the primary key of the other table
+		var pk string
+		if err := db.QueryRowContext(ctx, `SELECT name FROM pragma_table_info(?) WHERE pk = 1`, l.table).Scan(&pk); err != nil {
+			delete(relations, from)
+			continue
+		}
+		l.column = pk
+		relations[from] = l
+	}
+	return relations, nil
+}
+
+func _tableSQL(ctx context.Context, db *sql.DB, table string) string {
+	var s string
+	if err := db.QueryRowContext(ctx, `SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&s); err != nil {
+		return ""
+	}
+	return s
+}
+
+func _calculateScore(column Column) int {
+	scoreType := 0
+	scoreName := 1
+	if column.PrimaryKey {
+		scoreType = 3
+	} else if column.Unique {
+		scoreType = 2
+	}
+	switch strings.ToLower(column.Name) {
+	case "name":
+		scoreName = 2
+	case "label":
+		scoreName = 2
+	case "email":
+		scoreName = 5
+	}
+	return scoreType * scoreName
+}
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func isDatetime(column Column) bool {
+	return strings.Contains(column.Type, "datetime") || strings.Contains(column.Type, "timestamp")
+}
+
+func convertFromDB(val any, column Column) any {
+	switch tmp := val.(type) {
+	case []byte:
+		return string(tmp)
+	case time.Time:
+		return tmp.UTC().Format("2006-01-02T15:04")
+	case string:
+		if isDatetime(column) {
+			if t, err := parseTime(tmp); err == nil {
+				return t.UTC().Format("2006-01-02T15:04")
+			}
+		}
+	}
+	return val
+}
+
+// dates are stored as text in sqlite, these are the formats its date functions understand
+func parseTime(s string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func createFormElement(val any, column Column) FormElement {
+	f := FormElement{
+		Type: "text",
+	}
+	if isDatetime(column) {
+		f.Type = "datetime"
+	} else if strings.Contains(column.Type, "bool") {
+		f.Type = "boolean"
+	}
+	f.Value = convertFromDB(val, column)
+
+	f.Name = column.Name
+	f.Required = !column.Nullable && !column.Default
+
+	if strings.Contains(strings.ToLower(column.Name), "password") {
+		f.Type = "password"
+	}
+	return f
+}
+
+// _findEnumValues looks for a constraint of the form: CHECK(column IN ('a', 'b')) which is
+// what stands for an enum in sqlite
+func _findEnumValues(tableSQL string, column Column) []string {
+	re, err := regexp.Compile(`(?is)CHECK\s*\(\s*["` + "`" + `\[]?` + regexp.QuoteMeta(column.Name) + `["` + "`" + `\]]?\s+IN\s*\(([^)]*)\)`)
+	if err != nil {
+		return nil
+	}
+	match := re.FindStringSubmatch(tableSQL)
+	if len(match) != 2 {
+		return nil
+	}
+	values := []string{}
+	for _, v := range strings.Split(match[1], ",") {
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+			v = strings.ReplaceAll(v[1:len(v)-1], "''", "'")
+		}
+		values = append(values, v)
+	}
+	return values
+}
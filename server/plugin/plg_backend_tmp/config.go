@@ -0,0 +1,114 @@
+package plg_backend_tmp
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.Onload(func() {
+		StoragePath()
+		StorageQuota()
+		StorageExpiry()
+	})
+	Hooks.Register.OnConfigValidate(func(candidate *Configuration) error {
+		if _, err := parseSize(candidate.Get("features.tmp.quota").String()); err != nil {
+			return NewError("features.tmp.quota isn't a valid size", 400)
+		}
+		return nil
+	})
+}
+
+var StoragePath = func() string {
+	return Config.Get("features.tmp.path").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "path"
+		f.Type = "text"
+		f.Description = "Where the files of the tmp backend are stored, it has to be somewhere that survives a restart"
+		f.Placeholder = "Eg: /srv/filestash/tmp"
+		f.Default = filepath.Join(filepath.Dir(GetAbsolutePath(DB_PATH)), "tmp")
+		return f
+	}).String()
+}
+
+var StorageQuota = func() string {
+	return Config.Get("features.tmp.quota").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "quota"
+		f.Type = "text"
+		f.Description = "How much each user of the tmp backend can store, eg: 500MB. Leave empty for no limit"
+		f.Placeholder = "Eg: 500MB"
+		f.Default = ""
+		return f
+	}).String()
+}
+
+var StorageExpiry = func() int {
+	return Config.Get("features.tmp.expiry").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "expiry"
+		f.Type = "number"
+		f.Description = "Number of hours a file stays in the tmp backend after it was last saved, 0 to keep files forever"
+		f.Placeholder = "Default: 720 hours"
+		f.Default = 720
+		return f
+	}).Int()
+}
+
+// expiryFor gives how long files are kept, a connection can ask for less than what's in the config
+// but never for more. 0 means forever
+func expiryFor(param string) time.Duration {
+	max := time.Duration(StorageExpiry()) * time.Hour
+	if max < 0 {
+		max = 0
+	}
+	hours, err := strconv.Atoi(strings.TrimSpace(param))
+	if err != nil || hours <= 0 {
+		return max
+	} else if d := time.Duration(hours) * time.Hour; max == 0 || d < max {
+		return d
+	}
+	return max
+}
+
+// quotaLimit gives the number of bytes a user can store, -1 meaning there's no limit. A quota we
+// can't make sense of doesn't let anything in rather than lifting the limit
+func quotaLimit() int64 {
+	size, err := parseSize(StorageQuota())
+	if err != nil {
+		Log.Warning("plg_backend_tmp::quota invalid quota '%s'", StorageQuota())
+		return 0
+	}
+	return size
+}
+
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" || s == "UNLIMITED" {
+		return -1, nil
+	}
+	unit := int64(1)
+	for i, suffix := range []string{"KB", "MB", "GB", "TB", "PB"} {
+		if strings.HasSuffix(s, suffix) {
+			unit = int64(1) << (10 * (i + 1))
+			s = strings.TrimSuffix(s, suffix)
+			break
+		}
+	}
+	s = strings.TrimSuffix(strings.TrimSpace(s), "B")
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, ErrNotValid
+	}
+	return int64(n * float64(unit)), nil
+}
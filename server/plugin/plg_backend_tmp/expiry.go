@@ -0,0 +1,168 @@
+package plg_backend_tmp
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * When something expires is kept in an index for each user, stored next to the user folders as
+ * .expiry/<userID>.json and mapping the path of a file or folder to the time it expires. Being on
+ * disk, it survives a restart and the reaper removes what has expired while the server was down as
+ * soon as it's back up. A folder that expires with some content that doesn't is kept until the
+ * content is gone. Something that isn't in the index never expires.
+ */
+var indexLock sync.Mutex
+
+const REAPER_INTERVAL = time.Minute
+
+func init() {
+	Hooks.Register.Onload(func() {
+		go func() {
+			for {
+				reap()
+				time.Sleep(REAPER_INTERVAL)
+			}
+		}()
+	})
+}
+
+func (this TmpStorage) setExpiry(path string, overwrite bool) {
+	if this.expiry == 0 {
+		return
+	}
+	indexLock.Lock()
+	defer indexLock.Unlock()
+	index := loadIndex(this.userID)
+	key := indexKey(path)
+	if _, ok := index[key]; ok && overwrite == false {
+		return
+	}
+	index[key] = time.Now().Add(this.expiry).Unix()
+	saveIndex(this.userID, index)
+}
+
+func (this TmpStorage) forgetExpiry(path string) {
+	indexLock.Lock()
+	defer indexLock.Unlock()
+	index := loadIndex(this.userID)
+	key := indexKey(path)
+	for p := range index {
+		if key == "/" || p == key || strings.HasPrefix(p, key+"/") {
+			delete(index, p)
+		}
+	}
+	saveIndex(this.userID, index)
+}
+
+func (this TmpStorage) moveExpiry(from string, to string) {
+	indexLock.Lock()
+	defer indexLock.Unlock()
+	index := loadIndex(this.userID)
+	from, to = indexKey(from), indexKey(to)
+	moved := map[string]int64{}
+	for p, t := range index {
+		if p == from || strings.HasPrefix(p, from+"/") {
+			delete(index, p)
+			moved[to+strings.TrimPrefix(p, from)] = t
+		}
+	}
+	for p, t := range moved {
+		index[p] = t
+	}
+	saveIndex(this.userID, index)
+}
+
+// reap removes what has expired. Paths are sorted from the longest to the shortest so the content
+// of a folder is looked at before the folder itself
+func reap() {
+	dir := filepath.Join(StoragePath(), ".expiry")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	now := time.Now().Unix()
+	indexLock.Lock()
+	defer indexLock.Unlock()
+	for _, entry := range entries {
+		userID, ok := strings.CutSuffix(entry.Name(), ".json")
+		if ok == false || isValidUserID(userID) == false {
+			continue
+		}
+		index := loadIndex(userID)
+		paths := make([]string, 0, len(index))
+		for p := range index {
+			paths = append(paths, p)
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			return len(paths[i]) > len(paths[j])
+		})
+		for _, p := range paths {
+			if index[p] > now {
+				continue
+			}
+			fullpath := filepath.Join(StoragePath(), userID, p)
+			info, err := os.Lstat(fullpath)
+			if os.IsNotExist(err) {
+				delete(index, p)
+				continue
+			} else if err != nil {
+				Log.Warning("plg_backend_tmp::reap action=stat path=%s err=%s", fullpath, err.Error())
+				continue
+			} else if info.IsDir() {
+				if children, err := os.ReadDir(fullpath); err != nil || len(children) > 0 {
+					continue
+				}
+			}
+			if err = os.Remove(fullpath); err != nil {
+				Log.Warning("plg_backend_tmp::reap action=remove path=%s err=%s", fullpath, err.Error())
+				continue
+			}
+			Log.Debug("plg_backend_tmp::reap userID=%s path=%s", userID, p)
+			delete(index, p)
+		}
+		saveIndex(userID, index)
+	}
+}
+
+func indexKey(path string) string {
+	return filepath.Clean("/" + path)
+}
+
+func loadIndex(userID string) map[string]int64 {
+	index := map[string]int64{}
+	b, err := os.ReadFile(filepath.Join(StoragePath(), ".expiry", userID+".json"))
+	if err != nil {
+		return index
+	}
+	if err = json.Unmarshal(b, &index); err != nil {
+		Log.Warning("plg_backend_tmp::index action=load userID=%s err=%s", userID, err.Error())
+	}
+	return index
+}
+
+func saveIndex(userID string, index map[string]int64) {
+	dir := filepath.Join(StoragePath(), ".expiry")
+	if len(index) == 0 {
+		os.Remove(filepath.Join(dir, userID+".json"))
+		return
+	}
+	b, err := json.Marshal(index)
+	if err == nil {
+		os.MkdirAll(dir, 0755)
+		tmp := filepath.Join(dir, userID+".json.tmp")
+		if err = os.WriteFile(tmp, b, 0644); err == nil {
+			err = os.Rename(tmp, filepath.Join(dir, userID+".json"))
+		}
+	}
+	if err != nil {
+		Log.Warning("plg_backend_tmp::index action=save userID=%s err=%s", userID, err.Error())
+	}
+}
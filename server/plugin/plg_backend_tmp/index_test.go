@@ -0,0 +1,153 @@
+package plg_backend_tmp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"":          -1,
+		"unlimited": -1,
+		"0":         0,
+		"100":       100,
+		"100B":      100,
+		"1KB":       1024,
+		"1.5 MB":    1536 * 1024,
+		"2gb":       2 << 30,
+	}
+	for in, expected := range tests {
+		if got, err := parseSize(in); err != nil || got != expected {
+			t.Errorf("parseSize(%q) got %d err=%v want %d", in, got, err, expected)
+		}
+	}
+	for _, in := range []string{"abc", "-1MB", "10XB"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) should fail", in)
+		}
+	}
+}
+
+func TestQuotaLimitFailsClosed(t *testing.T) {
+	setup(t, "not a size")
+	if limit := quotaLimit(); limit != 0 {
+		t.Fatalf("an invalid quota shouldn't lift the limit, got %d", limit)
+	}
+	backend := newTestBackend(t)
+	if err := backend.Save("/file.txt", strings.NewReader("hello")); err != ErrQuotaExceeded {
+		t.Fatalf("nothing should be stored with an invalid quota, got %v", err)
+	}
+}
+
+func TestSaveQuota(t *testing.T) {
+	setup(t, "10B")
+	backend := newTestBackend(t)
+	if err := backend.Save("/a.txt", strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Save("/b.txt", strings.NewReader("x")); err != ErrQuotaExceeded {
+		t.Fatalf("the quota is reached, got %v", err)
+	}
+	if err := backend.Save("/a.txt", strings.NewReader("9876543210")); err != nil {
+		t.Fatalf("the file being replaced isn't counted, got %v", err)
+	}
+}
+
+func TestSaveReplaceQuota(t *testing.T) {
+	setup(t, "10B")
+	backend := newTestBackend(t)
+	if _, ok := interface{}(backend).(IBackendReplace); ok {
+		t.Fatal("an upload would be counted twice while it replaces a file")
+	}
+	if err := backend.Save("/a.txt", strings.NewReader("01234567")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Save("/a.txt", strings.NewReader("012345678")); err != nil {
+		t.Fatalf("the file being replaced shouldn't count while it's replaced, got %v", err)
+	}
+	if used, err := backend.usage(); err != nil || used != 9 {
+		t.Fatalf("unexpected usage %d err=%v", used, err)
+	}
+}
+
+func TestSaveConcurrentQuota(t *testing.T) {
+	setup(t, "100B")
+	backend := newTestBackend(t)
+
+	// every upload starts before any is done so they all see an empty folder at first
+	const uploads = 10
+	var started sync.WaitGroup
+	started.Add(uploads)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make([]error, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = backend.Save(fmt.Sprintf("/file%d", i), &slowReader{
+				Reader:  bytes.NewReader(make([]byte, 30)),
+				started: &started,
+				release: release,
+			})
+		}(i)
+	}
+	started.Wait()
+	close(release)
+	wg.Wait()
+
+	saved := 0
+	for _, err := range errs {
+		if err == nil {
+			saved += 1
+		} else if err != ErrQuotaExceeded {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	used, err := backend.usage()
+	if err != nil {
+		t.Fatal(err)
+	} else if used > 100 || saved != 3 {
+		t.Fatalf("concurrent uploads went over the quota: saved=%d used=%d", saved, used)
+	}
+}
+
+func setup(t *testing.T, quota string) {
+	dir := t.TempDir()
+	path, q, e := StoragePath, StorageQuota, StorageExpiry
+	StoragePath = func() string { return dir }
+	StorageQuota = func() string { return quota }
+	StorageExpiry = func() int { return 0 }
+	t.Cleanup(func() {
+		StoragePath, StorageQuota, StorageExpiry = path, q, e
+	})
+}
+
+func newTestBackend(t *testing.T) *TmpStorage {
+	b, err := TmpStorage{}.Init(map[string]string{"userID": "test"}, &App{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*TmpStorage)
+}
+
+type slowReader struct {
+	io.Reader
+	once    sync.Once
+	started *sync.WaitGroup
+	release chan struct{}
+}
+
+func (this *slowReader) Read(p []byte) (int, error) {
+	this.once.Do(func() {
+		this.started.Done()
+		<-this.release
+	})
+	return this.Reader.Read(p)
+}
@@ -0,0 +1,325 @@
+package plg_backend_union
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+/*
+ * The union backend shows many connections as a single tree. The `mounts` parameter is a json
+ * object where the keys are mount points and the values the parameters of the connection to put
+ * there, eg: {"/home": {"type": "sftp", ...}, "/team": {"type": "s3", ...}}. Being a plain string
+ * it goes through the templating of the attribute mapping middleware like any other parameter so
+ * every user can have their own mount table. A mount whose type ends up empty is skipped.
+ *
+ * Children are only connected to when a call reaches them and, as they are created with
+ * model.NewBackend, they must be allowed by the connections the admin has configured. The `path`
+ * parameter of a mount is the folder of the connection shown at the mount point, nothing above it
+ * can be reached.
+ */
+type Union struct {
+	app    *App
+	mounts []*mount
+}
+
+type mount struct {
+	path    string // always with a trailing slash
+	params  map[string]string
+	backend IBackend
+	err     error
+	once    sync.Once
+}
+
+func init() {
+	Backend.Register("union", Union{})
+}
+
+func (this Union) Init(params map[string]string, app *App) (IBackend, error) {
+	config := map[string]map[string]interface{}{}
+	if err := json.Unmarshal([]byte(params["mounts"]), &config); err != nil {
+		Log.Debug("plg_backend_union::init action=parse err=%s", err.Error())
+		return nil, NewError("Invalid mounts: "+err.Error(), 400)
+	}
+	backend := &Union{app: app, mounts: []*mount{}}
+	for point, conn := range config {
+		p := filepath.Clean("/" + strings.TrimSpace(point))
+		if p != "/" {
+			p += "/"
+		}
+		m := &mount{path: p, params: map[string]string{}}
+		for k, v := range conn {
+			m.params[k] = NewStringFromInterface(v)
+		}
+		if m.params["type"] == "" {
+			continue
+		} else if backend.isMountPoint(p) {
+			return nil, NewError("Duplicate mount point: "+p, 400)
+		}
+		backend.mounts = append(backend.mounts, m)
+	}
+	if len(backend.mounts) == 0 {
+		return nil, ErrNotValid
+	}
+	// the longest mount point comes first so the most specific one wins
+	sort.Slice(backend.mounts, func(i, j int) bool {
+		return len(backend.mounts[i].path) > len(backend.mounts[j].path)
+	})
+	return backend, nil
+}
+
+func (this Union) LoginForm() Form {
+	return Form{
+		Elmnts: []FormElement{
+			FormElement{
+				Name:  "type",
+				Type:  "hidden",
+				Value: "union",
+			},
+			FormElement{
+				Name:        "mounts",
+				Type:        "long_text",
+				Placeholder: `{"/home": {"type": "sftp", "hostname": "..."}, "/team": {"type": "s3", "path": "/team/"}}`,
+				Description: "Mount points with the parameters of the connection to mount there. Each connection needs to be allowed in the list of connections",
+			},
+		},
+	}
+}
+
+// find gives the mount a path belongs to, nil when it's not in any of them
+func (this Union) find(path string) *mount {
+	path = cleanPath(path)
+	for _, m := range this.mounts {
+		if strings.HasPrefix(path, m.path) || path+"/" == m.path {
+			return m
+		}
+	}
+	return nil
+}
+
+// route gives the backend a path belongs to and the path as this backend sees it
+func (this Union) route(path string) (IBackend, string, error) {
+	path = cleanPath(path)
+	m := this.find(path)
+	if m == nil {
+		return nil, "", ErrNotAllowed
+	}
+	m.once.Do(func() {
+		if m.backend, m.err = model.NewBackend(this.app, m.params); m.err != nil {
+			Log.Debug("plg_backend_union::route action=connect mount=%s err=%s", m.path, m.err.Error())
+		}
+	})
+	if m.err != nil {
+		return nil, "", m.err
+	}
+	root := "/"
+	if m.params["path"] != "" {
+		root = EnforceDirectory(cleanPath("/" + m.params["path"]))
+	}
+	p := root
+	if path+"/" != m.path {
+		p += strings.TrimPrefix(path, m.path)
+	}
+	if strings.HasPrefix(p, root) == false {
+		Log.Debug("plg_backend_union::route action=chroot mount=%s path=%s", m.path, path)
+		return nil, "", ErrNotAllowed
+	}
+	return m.backend, p, nil
+}
+
+// cleanPath resolves the "." and ".." of a path while keeping the trailing slash of a directory
+func cleanPath(path string) string {
+	p := filepath.Clean("/" + path)
+	if strings.HasSuffix(path, "/") && p != "/" {
+		p += "/"
+	}
+	return p
+}
+
+// isMountPoint tells if a path is the root of a mount, those can't be renamed or removed
+func (this Union) isMountPoint(path string) bool {
+	p := EnforceDirectory(cleanPath(path))
+	for _, m := range this.mounts {
+		if m.path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// virtual lists the mount points that are directly under a path
+func (this Union) virtual(path string) []os.FileInfo {
+	path = EnforceDirectory(cleanPath(path))
+	seen := map[string]bool{}
+	out := []os.FileInfo{}
+	for _, m := range this.mounts {
+		if m.path == path || strings.HasPrefix(m.path, path) == false {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(m.path, path), "/", 2)[0]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		out = append(out, File{FName: name, FType: "directory"})
+	}
+	return out
+}
+
+func (this Union) Ls(path string) ([]os.FileInfo, error) {
+	virtual := this.virtual(path)
+	b, p, err := this.route(path)
+	if err == ErrNotAllowed {
+		if len(virtual) == 0 {
+			return nil, ErrNotFound
+		}
+		return virtual, nil
+	} else if err != nil {
+		return nil, err
+	}
+	files, err := b.Ls(p)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, f := range files {
+		names[f.Name()] = true
+	}
+	for _, v := range virtual {
+		if names[v.Name()] == false {
+			files = append(files, v)
+		}
+	}
+	return files, nil
+}
+
+func (this Union) Stat(path string) (os.FileInfo, error) {
+	if this.isMountPoint(path) {
+		return File{FName: filepath.Base(path), FType: "directory"}, nil
+	}
+	b, p, err := this.route(path)
+	if err == ErrNotAllowed {
+		if len(this.virtual(path)) == 0 {
+			return nil, ErrNotFound
+		}
+		return File{FName: filepath.Base(path), FType: "directory"}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return b.Stat(p)
+}
+
+func (this Union) Cat(path string) (io.ReadCloser, error) {
+	b, p, err := this.route(path)
+	if err != nil {
+		return nil, err
+	}
+	return b.Cat(p)
+}
+
+func (this Union) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	b, p, err := this.route(path)
+	if err != nil {
+		return nil, err
+	} else if obj, ok := b.(IBackendRange); ok {
+		return obj.CatRange(p, offset, length)
+	}
+	r, err := b.Cat(p)
+	if err != nil {
+		return nil, err
+	} else if _, err = io.CopyN(io.Discard, r, offset); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return r, nil
+	}
+	return readCloser{io.LimitReader(r, length), r}, nil
+}
+
+func (this Union) Mkdir(path string) error {
+	if this.isMountPoint(path) {
+		return ErrConflict
+	}
+	b, p, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return b.Mkdir(p)
+}
+
+func (this Union) Rm(path string) error {
+	if this.isMountPoint(path) {
+		return ErrNotAllowed
+	}
+	b, p, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return b.Rm(p)
+}
+
+func (this Union) Save(path string, file io.Reader) error {
+	b, p, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return b.Save(p, file)
+}
+
+func (this Union) Touch(path string) error {
+	b, p, err := this.route(path)
+	if err != nil {
+		return err
+	}
+	return b.Touch(p)
+}
+
+func (this Union) Meta(path string) Metadata {
+	b, p, err := this.route(path)
+	if err != nil {
+		return Metadata{
+			CanCreateDirectory: NewBool(false),
+			CanCreateFile:      NewBool(false),
+			CanRename:          NewBool(false),
+			CanMove:            NewBool(false),
+			CanDelete:          NewBool(false),
+			CanUpload:          NewBool(false),
+		}
+	} else if obj, ok := b.(interface{ Meta(path string) Metadata }); ok {
+		return obj.Meta(p)
+	}
+	return Metadata{}
+}
+
+func (this Union) Checksum(path string, algo string) (string, error) {
+	b, p, err := this.route(path)
+	if err != nil {
+		return "", err
+	} else if obj, ok := b.(IBackendChecksum); ok {
+		return obj.Checksum(p, algo)
+	}
+	return "", ErrNotImplemented
+}
+
+func (this Union) Capacity(path string) (BackendCapacity, error) {
+	b, p, err := this.route(path)
+	if err != nil {
+		return BackendCapacity{}, err
+	} else if obj, ok := b.(IBackendCapacity); ok {
+		return obj.Capacity(p)
+	}
+	return BackendCapacity{}, ErrNotImplemented
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
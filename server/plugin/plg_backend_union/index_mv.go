@@ -0,0 +1,63 @@
+package plg_backend_union
+
+import (
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * Within a mount, a move is done by the backend of that mount. Across mounts there's no such thing
+ * so the content is copied over before being removed from where it was, if anything goes wrong
+ * along the way the source is left untouched.
+ */
+func (this Union) Mv(from string, to string) error {
+	if this.isMountPoint(from) || this.isMountPoint(to) {
+		return ErrNotAllowed
+	}
+	fromBackend, fromPath, err := this.route(from)
+	if err != nil {
+		return err
+	}
+	toBackend, toPath, err := this.route(to)
+	if err != nil {
+		return err
+	}
+	if this.find(from) == this.find(to) {
+		return fromBackend.Mv(fromPath, toPath)
+	}
+	Log.Debug("plg_backend_union::mv action=copy from=%s to=%s", from, to)
+	if err = copyAcross(fromBackend, fromPath, toBackend, toPath); err != nil {
+		return err
+	}
+	return fromBackend.Rm(fromPath)
+}
+
+func copyAcross(fromBackend IBackend, from string, toBackend IBackend, to string) error {
+	if strings.HasSuffix(from, "/") == false {
+		r, err := fromBackend.Cat(from)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return toBackend.Save(to, r)
+	}
+	to = EnforceDirectory(to)
+	if err := toBackend.Mkdir(to); err != nil && err != ErrConflict {
+		return err
+	}
+	files, err := fromBackend.Ls(from)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() {
+			name += "/"
+		}
+		if err = copyAcross(fromBackend, from+name, toBackend, to+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
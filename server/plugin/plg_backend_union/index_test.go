@@ -0,0 +1,71 @@
+package plg_backend_union
+
+import (
+	"sort"
+	"testing"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func TestRoute(t *testing.T) {
+	backend := newTestUnion(map[string]string{
+		"/home/":      "",
+		"/team/":      "/shared/team",
+		"/team/docs/": "/docs/",
+	})
+	tests := []struct {
+		path     string
+		expected string
+		err      error
+	}{
+		{path: "/home", expected: "/"},
+		{path: "/home/", expected: "/"},
+		{path: "/home/a/b.txt", expected: "/a/b.txt"},
+		{path: "/team/", expected: "/shared/team/"},
+		{path: "/team/a/", expected: "/shared/team/a/"},
+		{path: "/team/docs/x", expected: "/docs/x"},
+		{path: "/team/../team/a", expected: "/shared/team/a"},
+		{path: "/team/../../etc/passwd", err: ErrNotAllowed},
+		{path: "/team/..", err: ErrNotAllowed},
+		{path: "/home/../team/a", expected: "/shared/team/a"},
+		{path: "/team/a/../../docs", err: ErrNotAllowed},
+		{path: "/other/", err: ErrNotAllowed},
+	}
+	for _, test := range tests {
+		_, p, err := backend.route(test.path)
+		if err != test.err {
+			t.Errorf("route(%q) err=%v want %v", test.path, err, test.err)
+		} else if p != test.expected {
+			t.Errorf("route(%q) got %q want %q", test.path, p, test.expected)
+		}
+	}
+}
+
+func TestMountPoint(t *testing.T) {
+	backend := newTestUnion(map[string]string{"/home/": "", "/team/": ""})
+	for _, path := range []string{"/home", "/home/", "/team/../home/", "/home/./"} {
+		if backend.isMountPoint(path) == false {
+			t.Errorf("%q should be a mount point", path)
+		}
+	}
+	for _, path := range []string{"/", "/home/a", "/other/"} {
+		if backend.isMountPoint(path) {
+			t.Errorf("%q shouldn't be a mount point", path)
+		}
+	}
+}
+
+// newTestUnion gives a union where the mounts are already connected, mounts maps the mount point
+// to the `path` parameter of its connection
+func newTestUnion(mounts map[string]string) Union {
+	backend := Union{mounts: []*mount{}}
+	for point, path := range mounts {
+		m := &mount{path: point, params: map[string]string{"type": "test", "path": path}}
+		m.once.Do(func() {})
+		backend.mounts = append(backend.mounts, m)
+	}
+	sort.Slice(backend.mounts, func(i, j int) bool {
+		return len(backend.mounts[i].path) > len(backend.mounts[j].path)
+	})
+	return backend
+}
@@ -0,0 +1,46 @@
+package plg_backend_webdav
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// Checksum relies on the checksums ownCloud and Nextcloud keep for the files that were uploaded
+// with an OC-Checksum header. They come as a space separated list like "SHA1:<hex> MD5:<hex>"
+func (w WebDav) Checksum(path string, algo string) (string, error) {
+	query := `<d:propfind xmlns:d='DAV:' xmlns:oc='http://owncloud.org/ns'>
+			<d:prop>
+				<oc:checksums/>
+			</d:prop>
+		</d:propfind>`
+	res, err := w.request("PROPFIND", w.params.url+encodeURL(path), strings.NewReader(query), func(req *http.Request) {
+		req.Header.Add("Depth", "0")
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return "", ErrNotImplemented
+	}
+	var r struct {
+		Responses []struct {
+			Checksums []string `xml:"propstat>prop>checksums>checksum"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&r); err != nil || len(r.Responses) == 0 {
+		return "", ErrNotImplemented
+	}
+	for _, checksums := range r.Responses[0].Checksums {
+		for _, c := range strings.Fields(checksums) {
+			name, value, ok := strings.Cut(c, ":")
+			if ok && strings.ToLower(name) == algo && value != "" {
+				return value, nil
+			}
+		}
+	}
+	return "", ErrNotImplemented
+}
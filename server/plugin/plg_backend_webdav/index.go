@@ -202,6 +202,20 @@ func (w WebDav) Cat(path string) (io.ReadCloser, error) {
 	return res.Body, nil
 }
 
+func (w WebDav) CatRange(path string, offset int64, length int64) (io.ReadCloser, error) {
+	res, err := w.request("GET", w.params.url+encodeURL(path), nil, func(req *http.Request) {
+		req.Header.Set("Range", HTTPRange(offset, length))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 && res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		res.Body.Close()
+		return nil, NewError(HTTPFriendlyStatus(res.StatusCode)+": can't fetch "+filepath.Base(path), res.StatusCode)
+	}
+	return HTTPRangeBody(res, offset, length)
+}
+
 func (w WebDav) Mkdir(path string) error {
 	res, err := w.request("MKCOL", w.params.url+encodeURL(path), nil, func(req *http.Request) {
 		req.Header.Add("Overwrite", "F")
@@ -24,8 +24,6 @@ import (
 )
 
 var (
-	SECRET_KEY_DERIVATE_FOR_ONLYOFFICE string
-
 	onlyoffice_cache *cache.Cache
 	plugin_enable    func() bool
 	server_url       func() string
@@ -44,7 +42,6 @@ type onlyOfficeCacheData struct {
 }
 
 func init() {
-	SECRET_KEY_DERIVATE_FOR_ONLYOFFICE = Hash("ONLYOFFICE_"+SECRET_KEY, len(SECRET_KEY))
 	onlyoffice_cache = cache.New(720*time.Minute, 720*time.Minute)
 	plugin_enable = func() bool {
 		return Config.Get("features.office.enable").Schema(func(f *FormElement) *FormElement {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -38,26 +39,15 @@ func (this *Server) messageHandler(_ *App, w http.ResponseWriter, r *http.Reques
 func (this *Server) sseHandler(_ *App, w http.ResponseWriter, r *http.Request) {
 	token := ExtractToken(r)
 	if token == "" {
-		Log.Debug("plg_handler_mcp::sse msg=invalid_token")
-		w.Header().Add("Content-Type", "application/json")
-		w.Header().Add("WWW-Authenticate", "Bearer resource_metadata=\""+this.baseURL(r)+"/.well-known/oauth-protected-resource\"")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(JSONRPCResponse{
-			JSONRPC: "2.0",
-			Error: &JSONRPCError{
-				Code:    http.StatusUnauthorized,
-				Message: "Missing or invalid access token",
-			},
-		})
+		this.sendUnauthorized(w, r)
 		return
 	}
 
 	userSession := this.GetSession(uuid.New().String())
 	userSession.Token = token
-	if b, err := getBackend(userSession.Token); err == nil {
-		userSession.HomeDir, _ = model.GetHome(b, "/")
-		userSession.CurrDir = ToString(userSession.HomeDir, "/")
-	}
+	initSessionDirs(&userSession)
+	notifications := this.Subscribe(userSession.Id)
+	defer this.Unsubscribe(userSession.Id, notifications)
 
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -69,135 +59,10 @@ func (this *Server) sseHandler(_ *App, w http.ResponseWriter, r *http.Request) {
 	for {
 		select {
 		case request := <-userSession.Chan:
-			b, err := getBackend(userSession.Token)
-			if err != nil {
-				if err == ErrNotAuthorized {
-					err = JSONRPCError{
-						Code:    ErrNotAuthorized.Status(),
-						Message: "You aren't authenticated",
-					}
-				}
-				SendError(w, request.ID, err)
-				break
-			}
-			userSession.Backend = b
-
-			switch request.Method {
-			case "initialize":
-				SendMessage(w, request.ID, InitializeResponse{
-					ProtocolVersion: "2024-11-05",
-					ServerInfo: ServerInfo{
-						Name:    "Universal Storage Server",
-						Version: "1.0.0",
-					},
-					Capabilities: Capabilities{
-						Tools:     map[string]interface{}{},
-						Resources: map[string]interface{}{},
-						Prompts:   map[string]interface{}{},
-					},
-				})
-			case "resources/list":
-				SendMessage(w, request.ID, &ResourcesListResponse{
-					Resources: AllResources(),
-				})
-			case "resources/templates/list":
-				SendMessage(w, request.ID, &ResourceTemplatesListResponse{
-					ResourceTemplates: AllResourceTemplates(),
-				})
-			case "resources/read":
-				if uri, ok := request.Params["uri"].(string); ok {
-					if resource, err := FindResource(uri); err != nil {
-						SendError(w, request.ID, JSONRPCError{
-							Code:    http.StatusBadRequest,
-							Message: fmt.Sprintf("Unknown tool: %s", request.Params["name"]),
-						})
-					} else {
-						SendMessage(w, request.ID, &ResourceReadResponse{
-							Contents: []ResourceContent{
-								{
-									URI:      uri,
-									MimeType: resource.MimeType,
-									Text:     resource.Content,
-									Meta:     resource.Meta,
-								},
-							},
-						})
-					}
-				} else {
-					SendError(w, request.ID, JSONRPCError{
-						Code:    http.StatusBadRequest,
-						Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
-					})
-				}
-				SendMessage(w, request.ID, &ResourceReadResponse{
-					Contents: ExecResourceRead(request.Params),
-				})
-			case "prompts/list":
-				SendMessage(w, request.ID, &PromptsListResponse{
-					Prompts: AllPrompts(),
-				})
-			case "prompts/get":
-				if m, ok := request.Params["name"].(string); ok {
-					res, err := ExecPromptGet(m, request.Params, &userSession)
-					if err == nil {
-						SendMessage(w, request.ID, PromptGetResponse{
-							Messages:    res,
-							Description: ExecPromptDescription(request.Params),
-						})
-					} else {
-						SendError(w, request.ID, err)
-					}
-				} else {
-					SendError(w, request.ID, JSONRPCError{
-						Code:    http.StatusBadRequest,
-						Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
-					})
-				}
-			case "tools/list":
-				SendMessage(w, request.ID, &ListToolsResponse{
-					Tools: AllTools(),
-				})
-			case "tools/call":
-				if tname, ok := request.Params["name"].(string); ok {
-					if tool, err := FindTool(tname); err != nil {
-						SendError(w, request.ID, JSONRPCError{
-							Code:    http.StatusBadRequest,
-							Message: fmt.Sprintf("Unknown tool: %s", request.Params["name"]),
-						})
-					} else if res, err := tool.Run(request.Params, &userSession); err != nil {
-						SendMessage(w, request.ID, ToolResponse{
-							Content: []TextContent{{"text", err.Error()}},
-							IsError: true,
-						})
-					} else {
-						SendMessage(w, request.ID, res)
-					}
-				} else {
-					SendError(w, request.ID, JSONRPCError{
-						Code:    http.StatusBadRequest,
-						Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
-					})
-				}
-			case "notifications/initialized":
-				SendMessage(w, request.ID, map[string]string{})
-			case "completion/complete":
-				SendMessage(w, request.ID, CompletionResponse{
-					Completion: ExecCompletion(request.Params, &userSession),
-				})
-			case "ping":
-				SendMessage(w, request.ID, map[string]string{})
-			default:
-				if request.Method == "" && userSession.Ping.ID == request.ID { // response to ping
-					userSession.Ping.LastResponse = time.Now()
-					userSession.Ping.ID += 1
-				} else {
-					Log.Warning("plg_handler_mcp::sse message=unknown_method method=%s requestID=%d", request.Method, request.ID)
-					SendError(w, request.ID, JSONRPCError{
-						Code:    http.StatusMethodNotAllowed,
-						Message: fmt.Sprintf("Unknown request: %s", request.Method),
-					})
-				}
-			}
+			this.handleRequest(w, request, &userSession)
+		case msg := <-notifications:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", string(msg))
+			w.(http.Flusher).Flush()
 		case <-r.Context().Done():
 			this.RemoveSession(&userSession)
 			return
@@ -224,7 +89,188 @@ func (this *Server) sseHandler(_ *App, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (this *Server) handleRequest(w io.Writer, request JSONRPCRequest, userSession *UserSession) {
+	app, err := getApp(userSession.Token)
+	if err != nil {
+		if err == ErrNotAuthorized {
+			err = JSONRPCError{
+				Code:    ErrNotAuthorized.Status(),
+				Message: "You aren't authenticated",
+			}
+		}
+		SendError(w, request.ID, err)
+		return
+	}
+	userSession.App = app
+	userSession.Backend = app.Backend
+
+	switch request.Method {
+	case "initialize":
+		SendMessage(w, request.ID, InitializeResponse{
+			ProtocolVersion: negotiateProtocolVersion(request.Params),
+			ServerInfo: ServerInfo{
+				Name:    "Universal Storage Server",
+				Version: "1.0.0",
+			},
+			Capabilities: Capabilities{
+				Tools: map[string]interface{}{},
+				Resources: map[string]interface{}{
+					"subscribe":   true,
+					"listChanged": false,
+				},
+				Prompts: map[string]interface{}{},
+			},
+		})
+	case "resources/list":
+		SendMessage(w, request.ID, &ResourcesListResponse{
+			Resources: ListResources(userSession),
+		})
+	case "resources/templates/list":
+		SendMessage(w, request.ID, &ResourceTemplatesListResponse{
+			ResourceTemplates: AllResourceTemplates(),
+		})
+	case "resources/read":
+		if uri, ok := request.Params["uri"].(string); ok {
+			if contents, err := ExecResourceRead(uri, userSession); err != nil {
+				SendError(w, request.ID, err)
+			} else {
+				SendMessage(w, request.ID, &ResourceReadResponse{
+					Contents: contents,
+				})
+			}
+		} else {
+			SendError(w, request.ID, JSONRPCError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
+			})
+		}
+	case "resources/subscribe":
+		if uri, ok := request.Params["uri"].(string); ok {
+			if err := this.SubscribeResource(userSession, uri); err != nil {
+				SendError(w, request.ID, err)
+			} else {
+				SendMessage(w, request.ID, map[string]any{})
+			}
+		} else {
+			SendError(w, request.ID, JSONRPCError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
+			})
+		}
+	case "resources/unsubscribe":
+		if uri, ok := request.Params["uri"].(string); ok {
+			this.UnsubscribeResource(userSession, uri)
+			SendMessage(w, request.ID, map[string]any{})
+		} else {
+			SendError(w, request.ID, JSONRPCError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
+			})
+		}
+	case "prompts/list":
+		SendMessage(w, request.ID, &PromptsListResponse{
+			Prompts: AllPrompts(),
+		})
+	case "prompts/get":
+		if m, ok := request.Params["name"].(string); ok {
+			res, err := ExecPromptGet(m, request.Params, userSession)
+			if err == nil {
+				SendMessage(w, request.ID, PromptGetResponse{
+					Messages:    res,
+					Description: ExecPromptDescription(request.Params),
+				})
+			} else {
+				SendError(w, request.ID, err)
+			}
+		} else {
+			SendError(w, request.ID, JSONRPCError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
+			})
+		}
+	case "tools/list":
+		SendMessage(w, request.ID, &ListToolsResponse{
+			Tools: AllTools(),
+		})
+	case "tools/call":
+		if tname, ok := request.Params["name"].(string); ok {
+			if tool, err := FindTool(tname); err != nil {
+				SendError(w, request.ID, JSONRPCError{
+					Code:    http.StatusBadRequest,
+					Message: fmt.Sprintf("Unknown tool: %s", request.Params["name"]),
+				})
+			} else if res, err := tool.Run(request.Params, userSession); err != nil {
+				SendMessage(w, request.ID, ToolResponse{
+					Content: []TextContent{{Type: "text", Text: err.Error()}},
+					IsError: true,
+				})
+			} else {
+				SendMessage(w, request.ID, res)
+			}
+		} else {
+			SendError(w, request.ID, JSONRPCError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
+			})
+		}
+	case "notifications/initialized":
+		SendMessage(w, request.ID, map[string]string{})
+	case "completion/complete":
+		SendMessage(w, request.ID, CompletionResponse{
+			Completion: ExecCompletion(request.Params, userSession),
+		})
+	case "ping":
+		SendMessage(w, request.ID, map[string]string{})
+	default:
+		if request.Method == "" && userSession.Ping.ID == request.ID { // response to ping
+			userSession.Ping.LastResponse = time.Now()
+			userSession.Ping.ID += 1
+		} else {
+			Log.Warning("plg_handler_mcp::sse message=unknown_method method=%s requestID=%d", request.Method, request.ID)
+			SendError(w, request.ID, JSONRPCError{
+				Code:    http.StatusMethodNotAllowed,
+				Message: fmt.Sprintf("Unknown request: %s", request.Method),
+			})
+		}
+	}
+}
+
+var supportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+func negotiateProtocolVersion(params map[string]any) string {
+	version, _ := params["protocolVersion"].(string)
+	for _, v := range supportedProtocolVersions {
+		if v == version {
+			return v
+		}
+	}
+	return "2024-11-05"
+}
+
+func (this *Server) sendUnauthorized(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("plg_handler_mcp::auth msg=invalid_token")
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("WWW-Authenticate", "Bearer resource_metadata=\""+this.baseURL(r)+"/.well-known/oauth-protected-resource\"")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(JSONRPCResponse{
+		JSONRPC: "2.0",
+		Error: &JSONRPCError{
+			Code:    http.StatusUnauthorized,
+			Message: "Missing or invalid access token",
+		},
+	})
+}
+
 func getBackend(token string) (IBackend, error) {
+	app, err := getApp(token)
+	if err != nil {
+		return nil, err
+	}
+	return app.Backend, nil
+}
+
+// getApp builds the same context the HTTP handlers get from the session middleware
+func getApp(token string) (*App, error) {
 	str, err := DecryptString(SECRET_KEY_DERIVATE_FOR_USER, token)
 	if err != nil {
 		return nil, ErrNotAuthorized
@@ -233,7 +279,13 @@ func getBackend(token string) (IBackend, error) {
 	if err = json.Unmarshal([]byte(str), &session); err != nil {
 		return nil, err
 	}
-	return model.NewBackend(&App{
-		Context: context.Background(),
-	}, session)
+	app := &App{
+		Context:       context.Background(),
+		Session:       session,
+		Authorization: token,
+	}
+	if app.Backend, err = model.NewBackend(app, session); err != nil {
+		return nil, err
+	}
+	return app, nil
 }
@@ -16,12 +16,10 @@ const (
 	DEFAULT_SECRET_EXPIRY = 30 * 24 * 3600
 )
 
-var KEY_FOR_CODE string
+const KEY_FOR_CODE = "MCP_CODE_"
 
 func init() {
-	Hooks.Register.Onload(func() {
-		KEY_FOR_CODE = Hash("MCP_CODE_"+SECRET_KEY, len(SECRET_KEY))
-	})
+	RegisterSecretDerivate(KEY_FOR_CODE)
 }
 
 func (this Server) WellKnownOAuthAuthorizationServerHandler(_ *App, w http.ResponseWriter, r *http.Request) {
@@ -94,7 +92,7 @@ func (this Server) TokenHandler(_ *App, w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Invalid Grant Type", http.StatusBadRequest)
 		return
 	}
-	token, err := DecryptString(KEY_FOR_CODE, r.FormValue("code"))
+	token, err := DecryptString(SecretDerivate(KEY_FOR_CODE), r.FormValue("code"))
 	if err != nil {
 		http.Error(w, "Invalid authorization code", http.StatusBadRequest)
 		return
@@ -142,7 +140,7 @@ func (this Server) CallbackHandler(ctx *App, res http.ResponseWriter, req *http.
 		SendErrorResult(res, ErrNotValid)
 		return
 	}
-	code, err := EncryptString(KEY_FOR_CODE, ctx.Authorization)
+	code, err := EncryptString(SecretDerivate(KEY_FOR_CODE), ctx.Authorization)
 	if err != nil {
 		SendErrorResult(res, ErrNotValid)
 		return
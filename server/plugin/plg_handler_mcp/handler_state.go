@@ -1,15 +1,73 @@
 package plg_handler_mcp
 
 import (
+	"database/sql"
+	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
 	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/utils"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SESSION_PERSIST_TIME = 24 * time.Hour
+	SESSION_MAX_AGE      = 30 * 24 * time.Hour
+	EVENT_PERSIST_TIME   = 1 * time.Hour
 )
 
+var db *sql.DB
+
+// the key is taken from the keyring so sessions survive a rotation of the secret key
+const KEY_FOR_SESSION = "MCP_SESSION_"
+
+func init() {
+	RegisterSecretDerivate(KEY_FOR_SESSION)
+}
+
+func initState() error {
+	var err error
+	if db, err = sql.Open("sqlite3", GetAbsolutePath(DB_PATH, "mcp.db")); err != nil {
+		return err
+	}
+	if _, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			curr_dir TEXT NOT NULL,
+			closed BOOLEAN DEFAULT 0,
+			updated_at INTEGER NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id TEXT NOT NULL,
+			stream_id TEXT NOT NULL,
+			data TEXT NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_events_stream ON events(session_id, stream_id, id);`); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			// a closed session stays as long as its ID is valid so it can't be resumed
+			db.Exec(`DELETE FROM sessions WHERE closed = 0 AND updated_at < ?`, time.Now().Add(-SESSION_PERSIST_TIME).Unix())
+			db.Exec(`DELETE FROM sessions WHERE updated_at < ?`, time.Now().Add(-SESSION_MAX_AGE).Unix())
+			db.Exec(`DELETE FROM events WHERE created_at < ?`, time.Now().Add(-EVENT_PERSIST_TIME).Unix())
+			time.Sleep(10 * time.Minute)
+		}
+	}()
+	return nil
+}
+
 func (this *Server) RemoveSession(userSession *UserSession) {
 	this.sessions.Delete(userSession.Id)
+	this.UnsubscribeAllResources(userSession.Id)
 }
 
 func ExtractToken(r *http.Request) string {
@@ -33,3 +91,194 @@ func (this *Server) GetSession(uuid string) UserSession {
 	})
 	return ch.(UserSession)
 }
+
+// Subscribe registers the stream on which server initiated messages are sent to a session
+func (this *Server) Subscribe(sessionID string) chan []byte {
+	ch := make(chan []byte, 10)
+	this.streams.Store(sessionID, ch)
+	return ch
+}
+
+func (this *Server) Unsubscribe(sessionID string, ch chan []byte) {
+	this.streams.CompareAndDelete(sessionID, ch)
+}
+
+func (this *Server) Notify(sessionID string, method string, params map[string]any) {
+	ch, ok := this.streams.Load(sessionID)
+	if !ok {
+		return
+	}
+	b, err := json.Marshal(JSONRPCMethod{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case ch.(chan []byte) <- b:
+	default:
+	}
+}
+
+func initSessionDirs(userSession *UserSession) {
+	if b, err := getBackend(userSession.Token); err == nil {
+		userSession.HomeDir, _ = model.GetHome(b, "/")
+		userSession.CurrDir = ToString(userSession.HomeDir, "/")
+	}
+}
+
+/*
+ * Sessions of the streamable HTTP transport outlive the connection. Their ID is an encrypted
+ * blob binding the session to the user token so any instance sharing the same secret key can
+ * resume it, while the state that changes over time is persisted on disk. An ID is only valid
+ * for SESSION_MAX_AGE which is also how long a closed session is remembered.
+ */
+type persistedSession struct {
+	ID      string `json:"id"`
+	Token   string `json:"token"`
+	HomeDir string `json:"home"`
+	Created int64  `json:"created"`
+}
+
+func (this *Server) NewPersistedSession(token string) (UserSession, error) {
+	userSession := UserSession{
+		Token:   token,
+		CurrDir: "/",
+		HomeDir: "/",
+		Ping:    Ping{LastResponse: time.Now()},
+	}
+	initSessionDirs(&userSession)
+	b, err := json.Marshal(persistedSession{
+		ID:      uuid.New().String(),
+		Token:   Hash(token, 16),
+		HomeDir: userSession.HomeDir,
+		Created: time.Now().Unix(),
+	})
+	if err != nil {
+		return userSession, err
+	}
+	if userSession.Id, err = EncryptString(SecretDerivate(KEY_FOR_SESSION), string(b)); err != nil {
+		return userSession, err
+	}
+	return userSession, this.SavePersistedSession(&userSession)
+}
+
+func (this *Server) LoadPersistedSession(sessionID string, token string) (UserSession, error) {
+	str, err := DecryptString(SecretDerivate(KEY_FOR_SESSION), sessionID)
+	if err != nil {
+		return UserSession{}, ErrNotFound
+	}
+	var p persistedSession
+	if err = json.Unmarshal([]byte(str), &p); err != nil {
+		return UserSession{}, ErrNotFound
+	} else if p.Token != Hash(token, 16) {
+		return UserSession{}, ErrNotFound
+	} else if time.Since(time.Unix(p.Created, 0)) > SESSION_MAX_AGE {
+		return UserSession{}, ErrNotFound
+	}
+	userSession := UserSession{
+		Id:      sessionID,
+		Token:   token,
+		HomeDir: p.HomeDir,
+		CurrDir: ToString(p.HomeDir, "/"),
+		Ping:    Ping{LastResponse: time.Now()},
+	}
+	var closed bool
+	var currDir string
+	err = db.QueryRow(`SELECT curr_dir, closed FROM sessions WHERE id = ?`, sessionID).Scan(&currDir, &closed)
+	if err == sql.ErrNoRows { // eg: session created on another instance
+		return userSession, nil
+	} else if err != nil {
+		return userSession, err
+	} else if closed {
+		return userSession, ErrNotFound
+	}
+	userSession.CurrDir = currDir
+	return userSession, nil
+}
+
+func (this *Server) SavePersistedSession(userSession *UserSession) error {
+	_, err := db.Exec(`
+		INSERT INTO sessions (id, curr_dir, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET curr_dir = excluded.curr_dir, updated_at = excluded.updated_at
+	`, userSession.Id, userSession.CurrDir, time.Now().Unix())
+	return err
+}
+
+func (this *Server) ClosePersistedSession(userSession *UserSession) error {
+	if _, err := db.Exec(`
+		INSERT INTO sessions (id, curr_dir, closed, updated_at) VALUES (?, ?, 1, ?)
+			ON CONFLICT(id) DO UPDATE SET closed = 1, updated_at = excluded.updated_at
+	`, userSession.Id, userSession.CurrDir, time.Now().Unix()); err != nil {
+		return err
+	}
+	this.UnsubscribeAllResources(userSession.Id)
+	_, err := db.Exec(`DELETE FROM events WHERE session_id = ?`, userSession.Id)
+	return err
+}
+
+// events are kept for a stream to be resumed, they can contain the content of files so what's
+// stored can only be read back with the token of the user they were sent to
+func eventKey(token string) string {
+	return Hash(SecretDerivate(KEY_FOR_SESSION)+token, 16)
+}
+
+func saveEvent(sessionID string, streamID string, token string, data []byte) (string, error) {
+	encrypted, err := EncryptString(eventKey(token), string(data))
+	if err != nil {
+		return "", err
+	}
+	res, err := db.Exec(
+		`INSERT INTO events (session_id, stream_id, data, created_at) VALUES (?, ?, ?, ?)`,
+		sessionID, streamID, encrypted, time.Now().Unix(),
+	)
+	if err != nil {
+		return "", err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+type event struct {
+	ID   string
+	Data string
+}
+
+func replayEvents(sessionID string, token string, lastEventID string) (string, []event, error) {
+	var streamID string
+	since := time.Now().Add(-EVENT_PERSIST_TIME).Unix()
+	if err := db.QueryRow(
+		`SELECT stream_id FROM events WHERE id = ? AND session_id = ? AND created_at >= ?`,
+		lastEventID, sessionID, since,
+	).Scan(&streamID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil, ErrNotFound
+		}
+		return "", nil, err
+	}
+	rows, err := db.Query(`
+		SELECT id, data FROM events
+			WHERE session_id = ? AND stream_id = ? AND id > ? AND created_at >= ?
+			ORDER BY id ASC
+	`, sessionID, streamID, lastEventID, since)
+	if err != nil {
+		return streamID, nil, err
+	}
+	defer rows.Close()
+	events := []event{}
+	for rows.Next() {
+		var e event
+		if err = rows.Scan(&e.ID, &e.Data); err != nil {
+			return streamID, nil, err
+		} else if e.Data, err = DecryptString(eventKey(token), e.Data); err != nil {
+			return streamID, nil, ErrNotFound
+		}
+		events = append(events, e)
+	}
+	return streamID, events, rows.Err()
+}
@@ -0,0 +1,241 @@
+package plg_handler_mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
+
+	"github.com/google/uuid"
+)
+
+const MAX_REQUEST_SIZE = 10 << 20
+
+/*
+ * Streamable HTTP transport as described in:
+ * https://modelcontextprotocol.io/specification/2025-03-26/basic/transports#streamable-http
+ * - POST: client messages, answered with JSON or with an SSE stream depending on the Accept header
+ * - GET: stream for server initiated messages, resumable via the Last-Event-ID header
+ * - DELETE: terminate the session
+ */
+func (this *Server) streamableHandler(_ *App, w http.ResponseWriter, r *http.Request) {
+	token := ExtractToken(r)
+	if token == "" {
+		this.sendUnauthorized(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		this.streamablePost(token, w, r)
+	case http.MethodGet:
+		this.streamableGet(token, w, r)
+	case http.MethodDelete:
+		this.streamableDelete(token, w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (this *Server) streamablePost(token string, w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_REQUEST_SIZE))
+	if _, ok := err.(*http.MaxBytesError); ok {
+		sendHTTPError(w, http.StatusRequestEntityTooLarge, "Request too large")
+		return
+	} else if err != nil {
+		sendHTTPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	requests := []JSONRPCRequest{}
+	isBatch := len(bytes.TrimSpace(body)) > 0 && bytes.TrimSpace(body)[0] == '['
+	if isBatch {
+		err = json.Unmarshal(body, &requests)
+	} else {
+		request := JSONRPCRequest{}
+		err = json.Unmarshal(body, &request)
+		requests = append(requests, request)
+	}
+	if err != nil {
+		sendHTTPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var userSession UserSession
+	if slices.ContainsFunc(requests, func(r JSONRPCRequest) bool { return r.Method == "initialize" }) {
+		if userSession, err = this.NewPersistedSession(token); err != nil {
+			sendHTTPError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Mcp-Session-Id", userSession.Id)
+	} else if userSession, err = this.streamableSession(token, w, r); err != nil {
+		return
+	}
+	defer this.SavePersistedSession(&userSession)
+
+	if !slices.ContainsFunc(requests, isRequest) {
+		for _, request := range requests {
+			this.handleRequest(&jsonResponse{}, request, &userSession)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		stream := &sseStream{w: w, sessionID: userSession.Id, token: token, streamID: uuid.New().String()}
+		for _, request := range requests {
+			if isRequest(request) {
+				this.handleRequest(stream, request, &userSession)
+			} else {
+				this.handleRequest(&jsonResponse{}, request, &userSession)
+			}
+		}
+		return
+	}
+
+	response := &jsonResponse{}
+	for _, request := range requests {
+		if isRequest(request) {
+			this.handleRequest(response, request, &userSession)
+		} else {
+			this.handleRequest(&jsonResponse{}, request, &userSession)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if isBatch {
+		json.NewEncoder(w).Encode(response.messages)
+	} else if len(response.messages) > 0 {
+		w.Write(response.messages[0])
+	}
+}
+
+func (this *Server) streamableGet(token string, w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	userSession, err := this.streamableSession(token, w, r)
+	if err != nil {
+		return
+	}
+	stream := &sseStream{w: w, sessionID: userSession.Id, token: token, streamID: uuid.New().String()}
+	var replay []event
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		if stream.streamID, replay, err = replayEvents(userSession.Id, token, lastEventID); err != nil {
+			sendHTTPError(w, http.StatusNotFound, "Unknown event")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range replay {
+		fmt.Fprintf(w, "id: %s\nevent: message\ndata: %s\n\n", e.ID, e.Data)
+	}
+	w.(http.Flusher).Flush()
+
+	notifications := this.Subscribe(userSession.Id)
+	defer this.Unsubscribe(userSession.Id, notifications)
+	for {
+		select {
+		case msg := <-notifications:
+			stream.WriteMessage(msg)
+		case <-time.After(15 * time.Second):
+			fmt.Fprintf(w, ": ping\n\n")
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (this *Server) streamableDelete(token string, w http.ResponseWriter, r *http.Request) {
+	userSession, err := this.streamableSession(token, w, r)
+	if err != nil {
+		return
+	}
+	if err = this.ClosePersistedSession(&userSession); err != nil {
+		sendHTTPError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (this *Server) streamableSession(token string, w http.ResponseWriter, r *http.Request) (UserSession, error) {
+	sessionID := r.Header.Get("Mcp-Session-Id")
+	if sessionID == "" {
+		sendHTTPError(w, http.StatusBadRequest, "Missing Mcp-Session-Id header")
+		return UserSession{}, ErrNotValid
+	}
+	userSession, err := this.LoadPersistedSession(sessionID, token)
+	if err == ErrNotFound {
+		sendHTTPError(w, http.StatusNotFound, "Session not found")
+		return userSession, err
+	} else if err != nil {
+		sendHTTPError(w, http.StatusInternalServerError, err.Error())
+		return userSession, err
+	}
+	return userSession, nil
+}
+
+// isRequest tells apart requests expecting a response from notifications and responses
+func isRequest(request JSONRPCRequest) bool {
+	return request.Method != "" && !strings.HasPrefix(request.Method, "notifications/")
+}
+
+func sendHTTPError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(JSONRPCResponse{
+		JSONRPC: "2.0",
+		Error: &JSONRPCError{
+			Code:    status,
+			Message: message,
+		},
+	})
+}
+
+type sseStream struct {
+	w         http.ResponseWriter
+	sessionID string
+	token     string
+	streamID  string
+}
+
+func (this *sseStream) Write(p []byte) (int, error) {
+	return this.w.Write(p)
+}
+
+func (this *sseStream) WriteMessage(b []byte) error {
+	id, err := saveEvent(this.sessionID, this.streamID, this.token, b)
+	if err != nil {
+		Log.Debug("plg_handler_mcp::stream action=saveEvent err=%s", err.Error())
+		fmt.Fprintf(this.w, "event: message\ndata: %s\n\n", string(b))
+	} else {
+		fmt.Fprintf(this.w, "id: %s\nevent: message\ndata: %s\n\n", id, string(b))
+	}
+	this.w.(http.Flusher).Flush()
+	return err
+}
+
+type jsonResponse struct {
+	messages []json.RawMessage
+}
+
+func (this *jsonResponse) Write(p []byte) (int, error) {
+	return len(p), this.WriteMessage(p)
+}
+
+func (this *jsonResponse) WriteMessage(b []byte) error {
+	this.messages = append(this.messages, json.RawMessage(bytes.Clone(b)))
+	return nil
+}
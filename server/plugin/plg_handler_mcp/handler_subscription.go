@@ -0,0 +1,121 @@
+package plg_handler_mcp
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/impl"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
+)
+
+const (
+	RESOURCE_POLL_INTERVAL        = 10 * time.Second
+	MAX_SUBSCRIPTIONS_PER_SESSION = 32
+	MAX_SUBSCRIPTIONS             = 1024
+)
+
+/*
+ * Resource subscriptions are implemented by polling the backend as most storage providers
+ * don't have a way to get notified of changes. A watcher stops when the client unsubscribes,
+ * when its session is closed or when nobody has listened to the session for too long. As each
+ * watcher polls on its own, the number of subscriptions is capped for a session and overall.
+ */
+func (this *Server) SubscribeResource(userSession *UserSession, uri string) error {
+	if !IsFileResource(uri) {
+		if _, err := FindResource(uri); err != nil {
+			return err
+		}
+		return nil // static resources never change
+	}
+	path := FileResourcePath(uri, userSession)
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Stat(ctx, path) }); err != nil {
+		return err
+	}
+	if _, err := userSession.Backend.Stat(path); err != nil {
+		return err
+	}
+	key := subscriptionKey(userSession.Id, uri)
+	if _, exists := this.subscriptions.Load(key); !exists {
+		total, mine := 0, 0
+		this.subscriptions.Range(func(k, _ any) bool {
+			total += 1
+			if strings.HasPrefix(k.(string), subscriptionKey(userSession.Id, "")) {
+				mine += 1
+			}
+			return true
+		})
+		if mine >= MAX_SUBSCRIPTIONS_PER_SESSION || total >= MAX_SUBSCRIPTIONS {
+			Log.Debug("plg_handler_mcp::subscription action=subscribe err=too_many session=%d total=%d", mine, total)
+			return JSONRPCError{
+				Code:    http.StatusTooManyRequests,
+				Message: "Too many subscriptions",
+			}
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if prev, loaded := this.subscriptions.Swap(key, cancel); loaded {
+		prev.(context.CancelFunc)()
+	}
+	go this.watchResource(ctx, userSession.Id, userSession.Token, uri, path)
+	return nil
+}
+
+func (this *Server) UnsubscribeResource(userSession *UserSession, uri string) {
+	if cancel, ok := this.subscriptions.LoadAndDelete(subscriptionKey(userSession.Id, uri)); ok {
+		cancel.(context.CancelFunc)()
+	}
+}
+
+func (this *Server) UnsubscribeAllResources(sessionID string) {
+	this.subscriptions.Range(func(key, value any) bool {
+		if strings.HasPrefix(key.(string), subscriptionKey(sessionID, "")) {
+			this.subscriptions.Delete(key)
+			value.(context.CancelFunc)()
+		}
+		return true
+	})
+}
+
+func (this *Server) watchResource(ctx context.Context, sessionID string, token string, uri string, path string) {
+	backend, err := getBackend(token)
+	if err != nil {
+		return
+	}
+	state := func() string {
+		f, err := backend.Stat(path)
+		if err != nil {
+			return ""
+		}
+		return f.ModTime().String() + "-" + strconv.FormatInt(f.Size(), 10)
+	}
+	last := state()
+	lastSeen := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(RESOURCE_POLL_INTERVAL):
+		}
+		if _, ok := this.streams.Load(sessionID); ok {
+			lastSeen = time.Now()
+		} else if time.Since(lastSeen) > SESSION_PERSIST_TIME {
+			this.subscriptions.Delete(subscriptionKey(sessionID, uri))
+			return
+		}
+		if curr := state(); curr != last {
+			last = curr
+			Log.Debug("plg_handler_mcp::subscription action=updated uri=%s", uri)
+			this.Notify(sessionID, "notifications/resources/updated", map[string]any{
+				"uri": uri,
+			})
+		}
+	}
+}
+
+func subscriptionKey(sessionID string, uri string) string {
+	return sessionID + " " + uri
+}
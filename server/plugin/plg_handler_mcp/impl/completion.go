@@ -1,6 +1,7 @@
 package impl
 
 import (
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -16,7 +17,11 @@ func ExecCompletion(params map[string]any, userSession *UserSession) Completion
 		if strings.HasSuffix(path, "/") {
 			fname = ""
 		}
-		files, err := userSession.Backend.Ls(EnforceDirectory(fpath))
+		err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Ls(ctx, EnforceDirectory(fpath)) })
+		var files []os.FileInfo
+		if err == nil {
+			files, err = userSession.Backend.Ls(EnforceDirectory(fpath))
+		}
 		if err == nil {
 			values := []string{}
 			for _, file := range files {
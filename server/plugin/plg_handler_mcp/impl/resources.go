@@ -1,12 +1,22 @@
 package impl
 
 import (
+	"encoding/base64"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 
+	. "github.com/mickael-kerjean/filestash/server/common"
 	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
 )
 
+const (
+	FILE_RESOURCE_SCHEME = "filestash://"
+	MAX_RESOURCE_SIZE    = 10 * 1024 * 1024
+)
+
 var listOfResources = map[string]Resource{}
 
 func RegisterResource(r Resource) {
@@ -21,8 +31,39 @@ func AllResources() []Resource {
 	return r
 }
 
+// ListResources returns the registered resources alongside the files of the current directory
+func ListResources(userSession *UserSession) []Resource {
+	r := AllResources()
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Ls(ctx, userSession.CurrDir) }); err != nil {
+		return r
+	}
+	files, err := userSession.Backend.Ls(userSession.CurrDir)
+	if err != nil {
+		return r
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		path := JoinPath(userSession.CurrDir, file.Name())
+		r = append(r, Resource{
+			URI:         FileResourceURI(path),
+			Name:        file.Name(),
+			Description: path,
+			MimeType:    GetMimeType(file.Name()),
+		})
+	}
+	return r
+}
+
 func AllResourceTemplates() []ResourceTemplate {
-	return []ResourceTemplate{}
+	return []ResourceTemplate{
+		{
+			URITemplate: FILE_RESOURCE_SCHEME + "{path}",
+			Name:        "file",
+			Description: "File from the storage, eg: filestash:///path/to/file.txt",
+		},
+	}
 }
 
 func FindResource(uri string) (*Resource, error) {
@@ -36,6 +77,78 @@ func FindResource(uri string) (*Resource, error) {
 	return &r, nil
 }
 
-func ExecResourceRead(params map[string]any) []ResourceContent {
-	return []ResourceContent{}
+func ExecResourceRead(uri string, userSession *UserSession) ([]ResourceContent, error) {
+	if !IsFileResource(uri) {
+		resource, err := FindResource(uri)
+		if err != nil {
+			return nil, err
+		}
+		return []ResourceContent{
+			{
+				URI:      uri,
+				MimeType: resource.MimeType,
+				Text:     resource.Content,
+				Meta:     resource.Meta,
+			},
+		}, nil
+	}
+
+	path := FileResourcePath(uri, userSession)
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Cat(ctx, path) }); err != nil {
+		return nil, err
+	}
+	r, err := userSession.Backend.Cat(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(io.LimitReader(r, MAX_RESOURCE_SIZE+1))
+	if err != nil {
+		return nil, err
+	} else if len(b) > MAX_RESOURCE_SIZE {
+		return nil, JSONRPCError{
+			Code:    http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("Resource is too large: %s", uri),
+		}
+	}
+	content := ResourceContent{
+		URI:      uri,
+		MimeType: GetMimeType(path),
+	}
+	if isTextContent(content.MimeType, b) {
+		content.Text = string(b)
+	} else {
+		content.Blob = base64.StdEncoding.EncodeToString(b)
+	}
+	return []ResourceContent{content}, nil
+}
+
+func IsFileResource(uri string) bool {
+	return strings.HasPrefix(uri, FILE_RESOURCE_SCHEME)
+}
+
+func FileResourceURI(path string) string {
+	return FILE_RESOURCE_SCHEME + path
+}
+
+// FileResourcePath resolves the uri of a file resource the same way tools resolve their path argument
+func FileResourcePath(uri string, userSession *UserSession) string {
+	return getPath(
+		map[string]any{"arguments": map[string]any{"path": strings.TrimPrefix(uri, FILE_RESOURCE_SCHEME)}},
+		userSession,
+		"path",
+	)
+}
+
+func isTextContent(mType string, b []byte) bool {
+	if strings.HasPrefix(mType, "text/") {
+		return true
+	}
+	switch mType {
+	case "application/json", "application/xml", "application/javascript", "application/x-sh", "image/svg+xml":
+		return true
+	case "application/octet-stream":
+		return utf8.Valid(b)
+	}
+	return strings.HasSuffix(mType, "+json") || strings.HasSuffix(mType, "+xml")
 }
@@ -1,9 +1,11 @@
 package impl
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
+	. "github.com/mickael-kerjean/filestash/server/common"
 	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
 )
 
@@ -31,3 +33,22 @@ func FindTool(name string) (*Tool, error) {
 	}
 	return &t, nil
 }
+
+// Authorise goes through the chain of authorisation plugins the same way the HTTP handlers do
+func Authorise(userSession *UserSession, fn func(auth IAuthorisation, ctx *App) error) error {
+	ctx := appContext(userSession)
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err := fn(auth, ctx); err != nil {
+			Log.Info("plg_handler_mcp::auth err=%s", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+func appContext(userSession *UserSession) *App {
+	if userSession.App == nil {
+		return &App{Backend: userSession.Backend, Context: context.Background()}
+	}
+	return userSession.App
+}
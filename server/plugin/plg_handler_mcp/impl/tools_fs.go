@@ -3,12 +3,16 @@ package impl
 import (
 	"bytes"
 	_ "embed"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"path/filepath"
 	"strings"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
 	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
 	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/utils"
 )
@@ -16,6 +20,8 @@ import (
 //go:embed public/file-list.html
 var widget_ls string
 
+const MAX_COPY_DEPTH = 32
+
 func init() {
 	Hooks.Register.Onload(func() {
 		RegisterTool(Tool{
@@ -144,6 +150,76 @@ func init() {
 			},
 		})
 
+		RegisterTool(Tool{
+			Name:        "stat",
+			Description: "Use this when you need to know the type, size, modification time and mime type of a file or directory, based on the Unix command: `stat`.",
+			InputSchema: JsonSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]string{
+						"type":        "string",
+						"description": "path where the query is made",
+					},
+				},
+				"required": []string{"path"},
+			}),
+			Run: ToolFSStat,
+			Annotations: Meta{
+				"destructiveHint": false,
+				"openWorldHint":   true,
+				"readOnlyHint":    true,
+			},
+		})
+
+		RegisterTool(Tool{
+			Name:        "du",
+			Description: fmt.Sprintf("Use this when you need to know what is taking up space in a directory: the total size, the largest entries and the size by file extension, based on the Unix command: `du -s *`. Large trees are walked for at most %d seconds after what an incomplete result is returned", int(model.DU_TIME_BUDGET.Seconds())),
+			InputSchema: JsonSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]string{
+						"type":        "string",
+						"description": "directory to inspect",
+					},
+					"top": map[string]string{
+						"type":        "number",
+						"description": "number of entries to show",
+					},
+				},
+			}),
+			Run: ToolFSDu,
+			Annotations: Meta{
+				"destructiveHint": false,
+				"openWorldHint":   true,
+				"readOnlyHint":    true,
+			},
+		})
+
+		RegisterTool(Tool{
+			Name:        "cp",
+			Description: "Use this when you need to copy a file or a directory and its content from one path to another, based on the Unix command: `cp -r`.",
+			InputSchema: JsonSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"from": map[string]string{
+						"type":        "string",
+						"description": "origin path",
+					},
+					"to": map[string]string{
+						"type":        "string",
+						"description": "destination path",
+					},
+				},
+				"required": []string{"from", "to"},
+			}),
+			Run: ToolFSCp,
+			Annotations: Meta{
+				"destructiveHint": true,
+				"openWorldHint":   true,
+				"readOnlyHint":    false,
+			},
+		})
+
 		RegisterTool(Tool{
 			Name:        "mkdir",
 			Description: "Use this when you need to create a new directory at a specified path, based on the Unix command: `mkdir`.",
@@ -235,8 +311,11 @@ func init() {
 }
 
 func ToolFSLs(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
-	path := getPath(params, userSession, "path")
-	files, err := userSession.Backend.Ls(EnforceDirectory(path))
+	path := EnforceDirectory(getPath(params, userSession, "path"))
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Ls(ctx, path) }); err != nil {
+		return nil, err
+	}
+	files, err := userSession.Backend.Ls(path)
 	if err != nil {
 		return nil, err
 	}
@@ -278,14 +357,44 @@ func ToolFSCat(params map[string]any, userSession *UserSession) (*ToolResponse,
 	if isArgEmpty(params, "path") {
 		return nil, ErrNotValid
 	}
-	r, err := userSession.Backend.Cat(getPath(params, userSession, "path"))
+	path := getPath(params, userSession, "path")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Cat(ctx, path) }); err != nil {
+		return nil, err
+	}
+	r, err := userSession.Backend.Cat(path)
 	if err != nil {
 		return nil, err
 	}
-	b, err := io.ReadAll(r)
+	b, err := io.ReadAll(io.LimitReader(r, MAX_RESOURCE_SIZE+1))
 	r.Close()
 	if err != nil {
 		return nil, err
+	} else if len(b) > MAX_RESOURCE_SIZE {
+		return nil, NewError("File is too large", http.StatusRequestEntityTooLarge)
+	}
+	mType := GetMimeType(path)
+	if strings.HasPrefix(mType, "image/") && mType != "image/svg+xml" {
+		return &ToolResponse{
+			Content: []TextContent{
+				{
+					Type:     "image",
+					Data:     base64.StdEncoding.EncodeToString(b),
+					MimeType: mType,
+				},
+			},
+		}, nil
+	} else if !isTextContent(mType, b) {
+		return &ToolResponse{
+			Content: []TextContent{
+				{
+					Type: "text",
+					Text: fmt.Sprintf(
+						"binary file of %d bytes (%s), its content is available as the resource %s",
+						len(b), mType, FileResourceURI(path),
+					),
+				},
+			},
+		}, nil
 	}
 	return &ToolResponse{
 		Content: []TextContent{
@@ -310,7 +419,9 @@ func ToolFSPwd(params map[string]any, userSession *UserSession) (*ToolResponse,
 
 func ToolFSCd(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
 	path := EnforceDirectory(getPath(params, userSession, "path"))
-	if _, err := userSession.Backend.Ls(path); err != nil {
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Ls(ctx, path) }); err != nil {
+		return nil, err
+	} else if _, err := userSession.Backend.Ls(path); err != nil {
 		return nil, errors.New("No such file or directory")
 	}
 	userSession.CurrDir = EnforceDirectory(path)
@@ -328,10 +439,11 @@ func ToolFSMv(params map[string]any, userSession *UserSession) (*ToolResponse, e
 	if isArgEmpty(params, "from") || isArgEmpty(params, "to") {
 		return nil, ErrNotValid
 	}
-	if err := userSession.Backend.Mv(
-		getPath(params, userSession, "from"),
-		getPath(params, userSession, "to"),
-	); err != nil {
+	from := getPath(params, userSession, "from")
+	to := getPath(params, userSession, "to")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Mv(ctx, from, to) }); err != nil {
+		return nil, err
+	} else if err := userSession.Backend.Mv(from, to); err != nil {
 		return nil, err
 	}
 	return &ToolResponse{
@@ -344,11 +456,164 @@ func ToolFSMv(params map[string]any, userSession *UserSession) (*ToolResponse, e
 	}, nil
 }
 
+func ToolFSStat(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
+	if isArgEmpty(params, "path") {
+		return nil, ErrNotValid
+	}
+	path := getPath(params, userSession, "path")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Stat(ctx, path) }); err != nil {
+		return nil, err
+	}
+	f, err := userSession.Backend.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	stat := map[string]any{
+		"name": f.Name(),
+		"type": "file",
+		"size": f.Size(),
+		"time": f.ModTime().Unix(),
+		"mime": GetMimeType(f.Name()),
+	}
+	if f.IsDir() {
+		stat["type"] = "directory"
+		delete(stat, "mime")
+	}
+	return &ToolResponse{
+		StructuredContent: stat,
+		Content: []TextContent{
+			{
+				Type: "text",
+				Text: JsonText(stat),
+			},
+		},
+	}, nil
+}
+
+func ToolFSDu(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
+	path := EnforceDirectory(getPath(params, userSession, "path"))
+	du, err := model.DiskUsageOf(appContext(userSession), path, model.DiskUsageOptions{
+		Top: int(GetArgumentsNumber(params, "top")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	content := bytes.Buffer{}
+	for _, c := range du.Children {
+		name := c.Name
+		if c.Type == "directory" {
+			name += "/"
+		}
+		content.Write([]byte(fmt.Sprintf("%d\t%s\n", c.Size, name)))
+	}
+	content.Write([]byte(fmt.Sprintf("%d\ttotal (%d files, %d directories)", du.Size, du.Files, du.Directories)))
+	if du.Complete == false {
+		content.Write([]byte("\n[incomplete: the time budget ran out]"))
+	}
+	return &ToolResponse{
+		StructuredContent: map[string]any{
+			"size":        du.Size,
+			"files":       du.Files,
+			"directories": du.Directories,
+			"children":    du.Children,
+			"types":       du.Types,
+			"complete":    du.Complete,
+		},
+		Content: []TextContent{
+			{
+				Type: "text",
+				Text: content.String(),
+			},
+		},
+	}, nil
+}
+
+func ToolFSCp(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
+	if isArgEmpty(params, "from") || isArgEmpty(params, "to") {
+		return nil, ErrNotValid
+	}
+	from := getPath(params, userSession, "from")
+	to := getPath(params, userSession, "to")
+	if from == to {
+		return nil, ErrNotValid
+	}
+	isDir := strings.HasSuffix(GetArgumentsString(params, "from"), "/")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Stat(ctx, from) }); err != nil {
+		return nil, err
+	} else if f, err := userSession.Backend.Stat(from); err == nil {
+		isDir = f.IsDir()
+	}
+	if isDir && strings.HasPrefix(EnforceDirectory(to), EnforceDirectory(from)) {
+		return nil, NewError("Cannot copy a directory into itself", http.StatusBadRequest)
+	}
+	n, err := copyPath(userSession, from, to, isDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &ToolResponse{
+		Content: []TextContent{
+			{
+				Type: "text",
+				Text: fmt.Sprintf("done: %d file(s) copied", n),
+			},
+		},
+	}, nil
+}
+
+func copyPath(userSession *UserSession, from string, to string, isDir bool, depth int) (int, error) {
+	if depth > MAX_COPY_DEPTH {
+		return 0, NewError("Directory is too deep", http.StatusBadRequest)
+	}
+	if !isDir {
+		if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error {
+			if err := auth.Cat(ctx, from); err != nil {
+				return err
+			}
+			return auth.Save(ctx, to)
+		}); err != nil {
+			return 0, err
+		}
+		r, err := userSession.Backend.Cat(from)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+		return 1, userSession.Backend.Save(to, r)
+	}
+	from, to = EnforceDirectory(from), EnforceDirectory(to)
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error {
+		if err := auth.Ls(ctx, from); err != nil {
+			return err
+		}
+		return auth.Mkdir(ctx, to)
+	}); err != nil {
+		return 0, err
+	}
+	files, err := userSession.Backend.Ls(from)
+	if err != nil {
+		return 0, err
+	} else if err = userSession.Backend.Mkdir(to); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, file := range files {
+		n, err := copyPath(userSession, from+file.Name(), to+file.Name(), file.IsDir(), depth+1)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
 func ToolFSMkdir(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
 	if isArgEmpty(params, "path") {
 		return nil, ErrNotValid
 	}
-	if err := userSession.Backend.Mkdir(EnforceDirectory(getPath(params, userSession, "path"))); err != nil {
+	path := EnforceDirectory(getPath(params, userSession, "path"))
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Mkdir(ctx, path) }); err != nil {
+		return nil, err
+	} else if err := userSession.Backend.Mkdir(path); err != nil {
 		return nil, err
 	}
 	return &ToolResponse{
@@ -365,7 +630,10 @@ func ToolFSTouch(params map[string]any, userSession *UserSession) (*ToolResponse
 	if isArgEmpty(params, "path") {
 		return nil, ErrNotValid
 	}
-	if err := userSession.Backend.Touch(getPath(params, userSession, "path")); err != nil {
+	path := getPath(params, userSession, "path")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Touch(ctx, path) }); err != nil {
+		return nil, err
+	} else if err := userSession.Backend.Touch(path); err != nil {
 		return nil, err
 	}
 	return &ToolResponse{
@@ -382,7 +650,10 @@ func ToolFSRm(params map[string]any, userSession *UserSession) (*ToolResponse, e
 	if isArgEmpty(params, "path") {
 		return nil, ErrNotValid
 	}
-	if err := userSession.Backend.Rm(getPath(params, userSession, "path")); err != nil {
+	path := getPath(params, userSession, "path")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Rm(ctx, path) }); err != nil {
+		return nil, err
+	} else if err := userSession.Backend.Rm(path); err != nil {
 		return nil, err
 	}
 	return &ToolResponse{
@@ -399,8 +670,11 @@ func ToolFSSave(params map[string]any, userSession *UserSession) (*ToolResponse,
 	if isArgEmpty(params, "path") {
 		return nil, ErrNotValid
 	}
-	if err := userSession.Backend.Save(
-		getPath(params, userSession, "path"),
+	path := getPath(params, userSession, "path")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Save(ctx, path) }); err != nil {
+		return nil, err
+	} else if err := userSession.Backend.Save(
+		path,
 		NewReadCloserFromBytes([]byte(GetArgumentsString(params, "content"))),
 	); err != nil {
 		return nil, err
@@ -0,0 +1,262 @@
+package impl
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/utils"
+)
+
+func init() {
+	Hooks.Register.Onload(func() {
+		RegisterTool(Tool{
+			Name:        "metadata_get",
+			Description: "Use this when you need to read the metadata attached to a file or directory, like its tags.",
+			InputSchema: JsonSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]string{
+						"type":        "string",
+						"description": "path where the query is made",
+					},
+				},
+				"required": []string{"path"},
+			}),
+			Run: ToolMetadataGet,
+			Annotations: Meta{
+				"destructiveHint": false,
+				"openWorldHint":   true,
+				"readOnlyHint":    true,
+			},
+		})
+
+		RegisterTool(Tool{
+			Name:        "metadata_set",
+			Description: "Use this when you need to change the metadata attached to a file or directory. Fields are merged with the existing metadata and a field set to an empty string is removed. eg: {\"tags\": \"invoice, 2024\"}",
+			InputSchema: JsonSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]string{
+						"type":        "string",
+						"description": "path where the query is made",
+					},
+					"fields": map[string]interface{}{
+						"type":                 "object",
+						"description":          "metadata fields to update",
+						"additionalProperties": map[string]string{"type": "string"},
+					},
+				},
+				"required": []string{"path", "fields"},
+			}),
+			Run: ToolMetadataSet,
+			Annotations: Meta{
+				"destructiveHint": false,
+				"openWorldHint":   true,
+				"readOnlyHint":    false,
+			},
+		})
+
+		RegisterTool(Tool{
+			Name:        "share_create",
+			Description: "Use this when you need to create a shared link giving access to a file or directory to other people.",
+			InputSchema: JsonSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path": map[string]string{
+						"type":        "string",
+						"description": "path of the file or directory to share",
+					},
+					"can_write": map[string]string{
+						"type":        "boolean",
+						"description": "people with the link can edit",
+					},
+					"can_upload": map[string]string{
+						"type":        "boolean",
+						"description": "people with the link can upload new files",
+					},
+					"password": map[string]string{
+						"type":        "string",
+						"description": "password protecting the link",
+					},
+					"users": map[string]string{
+						"type":        "string",
+						"description": "comma separated list of emails allowed to use the link",
+					},
+					"expire_in_days": map[string]string{
+						"type":        "number",
+						"description": "number of days after which the link expires",
+					},
+				},
+				"required": []string{"path"},
+			}),
+			Run: ToolShareCreate,
+			Annotations: Meta{
+				"destructiveHint": false,
+				"openWorldHint":   true,
+				"readOnlyHint":    false,
+			},
+		})
+	})
+}
+
+func ToolMetadataGet(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
+	m := Hooks.Get.Metadata()
+	if m == nil {
+		return nil, ErrNotImplemented
+	} else if isArgEmpty(params, "path") {
+		return nil, ErrNotValid
+	}
+	path := getPath(params, userSession, "path")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Stat(ctx, path) }); err != nil {
+		return nil, err
+	}
+	forms, err := m.Get(appContext(userSession), path)
+	if err != nil {
+		return nil, err
+	}
+	fields := metadataFields(forms)
+	return &ToolResponse{
+		StructuredContent: fields,
+		Content: []TextContent{
+			{
+				Type: "text",
+				Text: JsonText(fields),
+			},
+		},
+	}, nil
+}
+
+func ToolMetadataSet(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
+	m := Hooks.Get.Metadata()
+	if m == nil {
+		return nil, ErrNotImplemented
+	} else if isArgEmpty(params, "path") {
+		return nil, ErrNotValid
+	}
+	path := getPath(params, userSession, "path")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Save(ctx, path) }); err != nil {
+		return nil, err
+	}
+	ctx := appContext(userSession)
+	forms, err := m.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range GetArgumentsMap(params, "fields") {
+		value := strings.TrimSpace(fmt.Sprintf("%v", val))
+		idx := -1
+		for i := range forms {
+			if forms[i].Id == key {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 && value != "" {
+			forms = append(forms, FormElement{Id: key, Type: "hidden", Value: value})
+		} else if idx != -1 && value != "" {
+			forms[idx].Value = value
+		} else if idx != -1 {
+			forms = append(forms[:idx], forms[idx+1:]...)
+		}
+	}
+	if err = m.Set(ctx, path, forms); err != nil {
+		return nil, err
+	}
+	fields := metadataFields(forms)
+	return &ToolResponse{
+		StructuredContent: fields,
+		Content: []TextContent{
+			{
+				Type: "text",
+				Text: JsonText(fields),
+			},
+		},
+	}, nil
+}
+
+func ToolShareCreate(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
+	if Config.Get("features.share.enable").Bool() == false {
+		return nil, NewError("Feature isn't enabled, contact your administrator", http.StatusMethodNotAllowed)
+	} else if isArgEmpty(params, "path") {
+		return nil, ErrNotValid
+	}
+	path := getPath(params, userSession, "path")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Stat(ctx, path) }); err != nil {
+		return nil, err
+	}
+	isDir := strings.HasSuffix(GetArgumentsString(params, "path"), "/")
+	if f, err := userSession.Backend.Stat(path); err == nil {
+		isDir = f.IsDir()
+	}
+	if isDir {
+		path = EnforceDirectory(path)
+	}
+
+	// like for shares made from the HTTP API, the path is relative to where the user is chrooted
+	ctx := appContext(userSession)
+	if ctx.Session["path"] != "" {
+		path = EnforceDirectory(ctx.Session["path"]) + strings.TrimPrefix(path, "/")
+	}
+	s := Share{
+		Id:           RandomString(7),
+		Auth:         ctx.Authorization,
+		Backend:      GenerateID(ctx.Session),
+		Path:         path,
+		CanRead:      true,
+		CanWrite:     GetArgumentsBool(params, "can_write"),
+		CanUpload:    GetArgumentsBool(params, "can_upload") && isDir,
+		CanShare:     false,
+		CanManageOwn: false,
+	}
+	if password := GetArgumentsString(params, "password"); password != "" {
+		s.Password = NewString(password)
+	}
+	if users := GetArgumentsString(params, "users"); users != "" {
+		s.Users = NewString(users)
+	}
+	if days := GetArgumentsNumber(params, "expire_in_days"); days > 0 {
+		expire := time.Now().Add(time.Duration(days*24) * time.Hour).UnixMilli()
+		s.Expire = &expire
+	}
+	if err := model.ShareUpsert(&s); err != nil {
+		return nil, err
+	}
+
+	link := WithBase("/s/" + s.Id)
+	if host := Config.Get("general.host").String(); host != "" {
+		scheme := "http://"
+		if Config.Get("general.force_ssl").Bool() {
+			scheme = "https://"
+		}
+		link = scheme + host + link
+	}
+	return &ToolResponse{
+		StructuredContent: map[string]any{
+			"id":   s.Id,
+			"link": link,
+		},
+		Content: []TextContent{
+			{
+				Type: "text",
+				Text: link,
+			},
+		},
+	}, nil
+}
+
+func metadataFields(forms []FormElement) map[string]any {
+	fields := map[string]any{}
+	for _, form := range forms {
+		key := form.Id
+		if key == "" {
+			key = form.Name
+		}
+		fields[key] = form.Value
+	}
+	return fields
+}
@@ -0,0 +1,241 @@
+package impl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/utils"
+)
+
+const (
+	GREP_MAX_FILE_SIZE = 1024 * 1024
+	GREP_MAX_FILES     = 500
+	GREP_MAX_MATCHES   = 200
+	GREP_MAX_DEPTH     = 16
+)
+
+func init() {
+	Hooks.Register.Onload(func() {
+		RegisterTool(Tool{
+			Name:        "search",
+			Description: "Use this when you need to find files by name or content using the search engine configured on the server. If path is omitted, the search is made from the current working directory",
+			InputSchema: JsonSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"query": map[string]string{
+						"type":        "string",
+						"description": "search term",
+					},
+					"path": map[string]string{
+						"type":        "string",
+						"description": "directory where the search is made",
+					},
+				},
+				"required": []string{"query"},
+			}),
+			Run: ToolSearch,
+			Annotations: Meta{
+				"destructiveHint": false,
+				"openWorldHint":   true,
+				"readOnlyHint":    true,
+			},
+		})
+
+		RegisterTool(Tool{
+			Name:        "grep",
+			Description: fmt.Sprintf("Use this when you need to find the lines matching a regular expression in the files of a directory and its subdirectories, based on the Unix command: `grep -rn`. Files bigger than %dKB are skipped and the search stops after %d files or %d matches", GREP_MAX_FILE_SIZE/1024, GREP_MAX_FILES, GREP_MAX_MATCHES),
+			InputSchema: JsonSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"pattern": map[string]string{
+						"type":        "string",
+						"description": "regular expression to look for",
+					},
+					"path": map[string]string{
+						"type":        "string",
+						"description": "file or directory where the search is made",
+					},
+					"ignore_case": map[string]string{
+						"type":        "boolean",
+						"description": "case insensitive matching",
+					},
+				},
+				"required": []string{"pattern"},
+			}),
+			Run: ToolGrep,
+			Annotations: Meta{
+				"destructiveHint": false,
+				"openWorldHint":   true,
+				"readOnlyHint":    true,
+			},
+		})
+	})
+}
+
+func ToolSearch(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
+	if isArgEmpty(params, "query") {
+		return nil, ErrNotValid
+	}
+	searchEngine := Hooks.Get.SearchEngine()
+	if searchEngine == nil {
+		return nil, ErrMissingDependency
+	}
+	path := EnforceDirectory(getPath(params, userSession, "path"))
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Ls(ctx, path) }); err != nil {
+		return nil, err
+	}
+	results, err := searchEngine.Query(*appContext(userSession), path, GetArgumentsString(params, "query"))
+	if err != nil {
+		return nil, err
+	}
+	structuredContent := make([]File, len(results))
+	content := bytes.Buffer{}
+	for i, file := range results {
+		ftype := "file"
+		if file.IsDir() {
+			ftype = "directory"
+			content.Write([]byte("[DIR]  "))
+		} else {
+			content.Write([]byte("[FILE] "))
+		}
+		content.Write([]byte(file.Path()))
+		content.Write([]byte("\n"))
+		structuredContent[i] = File{
+			FName: file.Name(),
+			FType: ftype,
+			FSize: file.Size(),
+			FTime: file.ModTime().Unix(),
+			FPath: file.Path(),
+		}
+	}
+	if len(results) == 0 {
+		content.Write([]byte("no results"))
+	}
+	return &ToolResponse{
+		StructuredContent: map[string]any{
+			"files": structuredContent,
+		},
+		Content: []TextContent{
+			{
+				Type: "text",
+				Text: content.String(),
+			},
+		},
+	}, nil
+}
+
+func ToolGrep(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
+	if isArgEmpty(params, "pattern") {
+		return nil, ErrNotValid
+	}
+	pattern := GetArgumentsString(params, "pattern")
+	if GetArgumentsBool(params, "ignore_case") {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, NewError("Invalid pattern: "+err.Error(), http.StatusBadRequest)
+	}
+	g := &grep{userSession: userSession, re: re}
+	path := getPath(params, userSession, "path")
+	if err := Authorise(userSession, func(auth IAuthorisation, ctx *App) error { return auth.Stat(ctx, path) }); err != nil {
+		return nil, err
+	}
+	if f, err := userSession.Backend.Stat(path); err == nil && !f.IsDir() {
+		g.file(path)
+	} else if err = g.dir(EnforceDirectory(path), 0); err != nil {
+		return nil, err
+	}
+
+	content := strings.Join(g.matches, "\n")
+	if len(g.matches) == 0 {
+		content = "no match"
+	}
+	if g.truncated {
+		content += "\n[results truncated]"
+	}
+	return &ToolResponse{
+		Content: []TextContent{
+			{
+				Type: "text",
+				Text: content,
+			},
+		},
+	}, nil
+}
+
+type grep struct {
+	userSession *UserSession
+	re          *regexp.Regexp
+	matches     []string
+	files       int
+	truncated   bool
+}
+
+func (this *grep) done() bool {
+	if len(this.matches) >= GREP_MAX_MATCHES || this.files >= GREP_MAX_FILES {
+		this.truncated = true
+		return true
+	}
+	return false
+}
+
+func (this *grep) dir(path string, depth int) error {
+	if err := Authorise(this.userSession, func(auth IAuthorisation, ctx *App) error { return auth.Ls(ctx, path) }); err != nil {
+		return err
+	}
+	files, err := this.userSession.Backend.Ls(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if this.done() {
+			return nil
+		} else if strings.HasPrefix(file.Name(), ".") {
+			continue
+		} else if file.IsDir() {
+			if depth < GREP_MAX_DEPTH {
+				this.dir(path+file.Name()+"/", depth+1) // unreadable subdirectories are skipped
+			}
+		} else if file.Size() <= GREP_MAX_FILE_SIZE {
+			this.file(path + file.Name())
+		}
+	}
+	return nil
+}
+
+func (this *grep) file(path string) {
+	if err := Authorise(this.userSession, func(auth IAuthorisation, ctx *App) error { return auth.Cat(ctx, path) }); err != nil {
+		return
+	}
+	r, err := this.userSession.Backend.Cat(path)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	this.files += 1
+	b, err := io.ReadAll(io.LimitReader(r, GREP_MAX_FILE_SIZE+1))
+	if err != nil || len(b) > GREP_MAX_FILE_SIZE || !isTextContent(GetMimeType(path), b) {
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 64*1024), GREP_MAX_FILE_SIZE)
+	for i := 1; scanner.Scan(); i++ {
+		if line := scanner.Text(); this.re.MatchString(line) {
+			if len(line) > 500 {
+				line = line[:500] + "…"
+			}
+			this.matches = append(this.matches, fmt.Sprintf("%s:%d:%s", path, i, line))
+			if this.done() {
+				return
+			}
+		}
+	}
+}
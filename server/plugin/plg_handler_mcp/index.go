@@ -12,7 +12,9 @@ import (
 )
 
 type Server struct {
-	sessions sync.Map
+	sessions      sync.Map
+	streams       sync.Map
+	subscriptions sync.Map
 }
 
 func init() {
@@ -24,13 +26,18 @@ func init() {
 		if !PluginEnable() {
 			return nil
 		}
+		if err := initState(); err != nil {
+			return err
+		}
 		srv := Server{}
 		m := []Middleware{WithCORS}
+		r.HandleFunc("/mcp", NewMiddlewareChain(srv.streamableHandler, m)).Methods("GET", "POST", "DELETE", "OPTIONS")
 		r.HandleFunc("/sse", NewMiddlewareChain(srv.sseHandler, m)).Methods("GET", "OPTIONS")
 		r.HandleFunc("/messages", NewMiddlewareChain(srv.messageHandler, m)).Methods("POST", "OPTIONS")
 		r.HandleFunc("/.well-known/oauth-authorization-server", NewMiddlewareChain(srv.WellKnownOAuthAuthorizationServerHandler, m)).Methods("GET", "OPTIONS")
 		r.HandleFunc("/.well-known/oauth-protected-resource", NewMiddlewareChain(srv.WellKnownOAuthProtectedResourceHandler, m)).Methods("GET", "OPTIONS")
 		r.HandleFunc("/.well-known/oauth-protected-resource/sse", NewMiddlewareChain(srv.WellKnownOAuthProtectedResourceHandler, m)).Methods("GET", "OPTIONS")
+		r.HandleFunc("/.well-known/oauth-protected-resource/mcp", NewMiddlewareChain(srv.WellKnownOAuthProtectedResourceHandler, m)).Methods("GET", "OPTIONS")
 
 		r.HandleFunc("/mcp/token", NewMiddlewareChain(srv.TokenHandler, m)).Methods("POST")
 		m = []Middleware{}
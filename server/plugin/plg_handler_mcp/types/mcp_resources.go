@@ -1,5 +1,7 @@
 package types
 
+import "encoding/json"
+
 type ResourcesListResponse struct {
 	Resources []Resource `json:"resources"`
 }
@@ -32,5 +34,20 @@ type ResourceContent struct {
 	URI      string `json:"uri"`
 	MimeType string `json:"mimeType"`
 	Text     string `json:"text"`
+	Blob     string `json:"blob,omitempty"`
 	Meta     Meta   `json:"_meta,omitempty"`
 }
+
+// MarshalJSON drops the text field of binary content as a resource holds either a text or a blob
+func (this ResourceContent) MarshalJSON() ([]byte, error) {
+	type content ResourceContent
+	if this.Blob == "" {
+		return json.Marshal(content(this))
+	}
+	return json.Marshal(struct {
+		URI      string `json:"uri"`
+		MimeType string `json:"mimeType"`
+		Blob     string `json:"blob"`
+		Meta     Meta   `json:"_meta,omitempty"`
+	}{this.URI, this.MimeType, this.Blob, this.Meta})
+}
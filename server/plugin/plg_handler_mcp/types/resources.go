@@ -7,8 +7,23 @@ type IResource interface {
 }
 
 type TextContent struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Data     string `json:"data,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// MarshalJSON handles image content blocks which carry base64 data instead of text
+func (this TextContent) MarshalJSON() ([]byte, error) {
+	type content TextContent
+	if this.Type != "image" {
+		return json.Marshal(content(this))
+	}
+	return json.Marshal(struct {
+		Type     string `json:"type"`
+		Data     string `json:"data"`
+		MimeType string `json:"mimeType"`
+	}{this.Type, this.Data, this.MimeType})
 }
 
 type BinaryContent struct {
@@ -13,6 +13,7 @@ type UserSession struct {
 	CurrDir string
 	Token   string
 	Backend IBackend
+	App     *App
 	Ping    Ping
 }
 
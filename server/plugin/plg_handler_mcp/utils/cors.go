@@ -9,7 +9,8 @@ import (
 func WithCORS(fn HandlerFunc) HandlerFunc {
 	return HandlerFunc(func(ctx *App, w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "mcp-protocol-version, Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "mcp-protocol-version, mcp-session-id, last-event-id, Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", "mcp-session-id")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -23,3 +23,39 @@ func GetArgumentString(params map[string]any, name string) string {
 	}
 	return p
 }
+
+func GetArgumentsBool(params map[string]any, name string) bool {
+	m, ok := params["arguments"].(map[string]any)
+	if !ok {
+		return false
+	}
+	p, ok := m[name].(bool)
+	if !ok {
+		return false
+	}
+	return p
+}
+
+func GetArgumentsNumber(params map[string]any, name string) float64 {
+	m, ok := params["arguments"].(map[string]any)
+	if !ok {
+		return 0
+	}
+	p, ok := m[name].(float64)
+	if !ok {
+		return 0
+	}
+	return p
+}
+
+func GetArgumentsMap(params map[string]any, name string) map[string]any {
+	m, ok := params["arguments"].(map[string]any)
+	if !ok {
+		return map[string]any{}
+	}
+	p, ok := m[name].(map[string]any)
+	if !ok {
+		return map[string]any{}
+	}
+	return p
+}
@@ -22,8 +22,7 @@ func SendMessage(w io.Writer, requestID uint64, response any) {
 			Message: err.Error(),
 		})
 	}
-	fmt.Fprintf(w, "event: message\ndata: %s\n\n", string(b))
-	w.(http.Flusher).Flush()
+	send(w, b)
 }
 
 func SendPing(w io.Writer, requestID uint64) {
@@ -38,8 +37,7 @@ func SendPing(w io.Writer, requestID uint64) {
 			Message: err.Error(),
 		})
 	}
-	fmt.Fprintf(w, "event: message\ndata: %s\n\n", string(b))
-	w.(http.Flusher).Flush()
+	send(w, b)
 }
 
 func SendMethod(w io.Writer, requestID uint64, method string, args ...map[string]any) {
@@ -59,8 +57,7 @@ func SendMethod(w io.Writer, requestID uint64, method string, args ...map[string
 			Message: err.Error(),
 		})
 	}
-	fmt.Fprintf(w, "event: message\ndata: %s\n\n", string(b))
-	w.(http.Flusher).Flush()
+	send(w, b)
 }
 
 func SendError(w io.Writer, requestID uint64, d error) {
@@ -85,9 +82,24 @@ func SendError(w io.Writer, requestID uint64, d error) {
 		Error:   &rpcErr,
 	})
 	if err != nil {
-		fmt.Fprintf(w, "event: message\ndata: %s\n\n", string(`nil`))
-	} else {
-		fmt.Fprintf(w, "event: message\ndata: %s\n\n", string(b))
+		b = []byte(`nil`)
 	}
+	send(w, b)
+}
+
+// IMessageWriter is implemented by transports which frame the messages on their own. Other
+// writers receive the messages as server sent events
+type IMessageWriter interface {
+	WriteMessage(b []byte) error
+}
+
+func send(w io.Writer, b []byte) {
+	if mw, ok := w.(IMessageWriter); ok {
+		if err := mw.WriteMessage(b); err != nil {
+			Log.Debug("plg_handler_mcp::send err=%s", err.Error())
+		}
+		return
+	}
+	fmt.Fprintf(w, "event: message\ndata: %s\n\n", string(b))
 	w.(http.Flusher).Flush()
 }
@@ -0,0 +1,30 @@
+package plg_metrics_health
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/ctrl"
+)
+
+/*
+ * Count what users are doing on each type of backend, the outcome shows up in the health page of
+ * the admin console next to the probes
+ */
+func init() {
+	Hooks.Register.Middleware(func(next HandlerFunc) HandlerFunc {
+		return func(ctx *App, res http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			next(ctx, res, req)
+			if ctx.Backend == nil || ctx.Session["type"] == "" {
+				return
+			}
+			status := http.StatusOK
+			if r, ok := res.(interface{ Status() int }); ok && r.Status() != 0 {
+				status = r.Status()
+			}
+			ctrl.HealthRecord(ctx.Session["type"], status, time.Since(start))
+		}
+	})
+}
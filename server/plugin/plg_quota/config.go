@@ -0,0 +1,140 @@
+package plg_quota
+
+import (
+	"strconv"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.Onload(func() {
+		PluginEnable()
+		PluginDefaultLimit()
+		PluginLimits()
+	})
+}
+
+var PluginEnable = func() bool {
+	return Config.Get("features.quota.enable").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "enable"
+		f.Type = "enable"
+		f.Target = []string{"quota_default_limit", "quota_limits"}
+		f.Description = "Keep track of how much each user stores and reject writes once over their limit"
+		f.Default = false
+		return f
+	}).Bool()
+}
+
+var PluginDefaultLimit = func() string {
+	return Config.Get("features.quota.default_limit").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Id = "quota_default_limit"
+		f.Name = "default_limit"
+		f.Type = "text"
+		f.Description = "Limit applied to anyone who doesn't match a more specific rule, eg: 10GB. Leave empty for no limit"
+		f.Placeholder = "Eg: 10GB"
+		f.Default = ""
+		return f
+	}).String()
+}
+
+var PluginLimits = func() string {
+	return Config.Get("features.quota.limits").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Id = "quota_limits"
+		f.Name = "limits"
+		f.Type = "long_text"
+		f.Description = "One rule per line as 'user:<name> = <size>', 'group:<name> = <size>' or 'backend:<type> = <size>'. " +
+			"A user rule wins over a group rule which wins over a backend rule. Use 'unlimited' to lift the limit"
+		f.Placeholder = "Eg: user:bob = 5GB"
+		f.Default = ""
+		return f
+	}).String()
+}
+
+// limitFor gives the number of bytes someone can store, -1 meaning there's no limit
+func limitFor(session map[string]string) int64 {
+	groups := map[string]bool{}
+	for _, g := range strings.Split(session["groups"]+","+session["group"], ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups[g] = true
+		}
+	}
+	var (
+		user    *int64
+		group   *int64
+		backend *int64
+	)
+	for _, line := range strings.Split(PluginLimits(), "\n") {
+		rule, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		kind, name, ok := strings.Cut(strings.TrimSpace(rule), ":")
+		if !ok {
+			continue
+		}
+		size, err := parseSize(value)
+		if err != nil {
+			Log.Warning("plg_quota::limit invalid rule '%s'", strings.TrimSpace(line))
+			continue
+		}
+		name = strings.TrimSpace(name)
+		switch strings.TrimSpace(kind) {
+		case "user":
+			if name != "" && name == session["user"] {
+				user = &size
+			}
+		case "group":
+			if groups[name] && (group == nil || *group >= 0 && (size < 0 || size > *group)) {
+				group = &size // someone in many groups gets the most generous limit
+			}
+		case "backend":
+			if name == session["type"] {
+				backend = &size
+			}
+		}
+	}
+	if user != nil {
+		return *user
+	} else if group != nil {
+		return *group
+	} else if backend != nil {
+		return *backend
+	}
+	size, err := parseSize(PluginDefaultLimit())
+	if err != nil {
+		Log.Warning("plg_quota::limit invalid default limit '%s'", PluginDefaultLimit())
+		return -1
+	}
+	return size
+}
+
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" || s == "UNLIMITED" {
+		return -1, nil
+	}
+	unit := int64(1)
+	for i, suffix := range []string{"KB", "MB", "GB", "TB", "PB"} {
+		if strings.HasSuffix(s, suffix) {
+			unit = int64(1) << (10 * (i + 1))
+			s = strings.TrimSuffix(s, suffix)
+			break
+		}
+	}
+	s = strings.TrimSuffix(strings.TrimSpace(s), "B")
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, ErrNotValid
+	}
+	return int64(n * float64(unit)), nil
+}
@@ -0,0 +1,179 @@
+package plg_quota
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * The quota plugin keeps the size of every file someone owns in a sqlite db. The db is populated
+ * by a scan of the storage the first time we hear about someone, and is kept up to date with what
+ * goes through Filestash afterward. Changes made behind our back are picked up by the next scan.
+ */
+func init() {
+	db, err := sql.Open("sqlite3", GetAbsolutePath(DB_PATH, "quota.sql"))
+	if err != nil {
+		Log.Error("plg_quota - cannot open sqlite quota db: %s", err.Error())
+		os.Exit(1)
+		return
+	}
+	db.Exec(`CREATE TABLE IF NOT EXISTS quota_file (
+        tenantID TEXT NOT NULL,
+        path     TEXT NOT NULL,
+        size     INTEGER NOT NULL,
+        PRIMARY KEY (tenantID, path)
+    )`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS quota_staging (
+        tenantID TEXT NOT NULL,
+        path     TEXT NOT NULL,
+        size     INTEGER NOT NULL
+    )`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS quota_scan (
+        tenantID   TEXT NOT NULL PRIMARY KEY,
+        scanned_at INTEGER NOT NULL
+    )`)
+	Hooks.Register.Quota(QuotaImpl{db: db})
+}
+
+type QuotaImpl struct {
+	db *sql.DB
+}
+
+func (this QuotaImpl) Usage(ctx *App, path string) (QuotaUsage, error) {
+	if PluginEnable() == false {
+		return QuotaUsage{}, ErrNotImplemented
+	}
+	tenantID := GenerateID(ctx.Session)
+	scanning := this.scan(ctx, tenantID)
+	total, err := this.sum(tenantID, "/")
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	used := total
+	if root := sessionRoot(ctx); strings.TrimSuffix(path, "/") != strings.TrimSuffix(root, "/") {
+		if used, err = this.sum(tenantID, path); err != nil {
+			return QuotaUsage{}, err
+		}
+	}
+	usage := QuotaUsage{Used: used, Limit: limitFor(ctx.Session), Available: -1, Scanning: scanning}
+	if usage.Limit >= 0 {
+		usage.Available = max(usage.Limit-total, 0)
+	}
+	return usage, nil
+}
+
+func (this QuotaImpl) Allowance(ctx *App, path string) (int64, error) {
+	if PluginEnable() == false {
+		return -1, nil
+	}
+	limit := limitFor(ctx.Session)
+	if limit < 0 {
+		return -1, nil
+	}
+	tenantID := GenerateID(ctx.Session)
+	if this.scan(ctx, tenantID) {
+		// until the first scan is done, what we know about is only a fraction of the usage
+		if this.waitScan(tenantID, QUOTA_SCAN_WAIT) == false || this.scanned(tenantID) == false {
+			return 0, NewError("Quota is being computed, try again later", http.StatusServiceUnavailable)
+		}
+	}
+	total, err := this.sum(tenantID, "/")
+	if err != nil {
+		return 0, err
+	}
+	var existing int64 // overwriting a file frees what it was using
+	if err = this.db.QueryRow(
+		"SELECT size FROM quota_file WHERE tenantID=? AND path=?", tenantID, path,
+	).Scan(&existing); err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	return max(limit-total+existing, 0), nil
+}
+
+func (this QuotaImpl) OnSave(ctx *App, path string, size int64) {
+	if PluginEnable() == false {
+		return
+	}
+	if size < 0 {
+		f, err := ctx.Backend.Stat(path)
+		if err != nil {
+			Log.Debug("plg_quota::save action=stat path=%s err=%s", path, err.Error())
+			return
+		}
+		size = f.Size()
+	}
+	if _, err := this.db.Exec(`
+        INSERT INTO quota_file (tenantID, path, size) VALUES (?, ?, ?)
+        ON CONFLICT(tenantID, path) DO UPDATE SET size=excluded.size
+    `, GenerateID(ctx.Session), path, size); err != nil {
+		Log.Warning("plg_quota::save path=%s err=%s", path, err.Error())
+	}
+}
+
+func (this QuotaImpl) OnRm(ctx *App, path string) {
+	if PluginEnable() == false {
+		return
+	}
+	from, to := prefixRange(path)
+	if _, err := this.db.Exec(
+		"DELETE FROM quota_file WHERE tenantID=? AND (path=? OR (path>=? AND path<?))",
+		GenerateID(ctx.Session), strings.TrimSuffix(path, "/"), from, to,
+	); err != nil {
+		Log.Warning("plg_quota::rm path=%s err=%s", path, err.Error())
+	}
+}
+
+func (this QuotaImpl) OnMv(ctx *App, from string, to string) {
+	if PluginEnable() == false {
+		return
+	}
+	tenantID := GenerateID(ctx.Session)
+	if _, err := this.db.Exec(
+		"UPDATE OR REPLACE quota_file SET path=? WHERE tenantID=? AND path=?",
+		strings.TrimSuffix(to, "/"), tenantID, strings.TrimSuffix(from, "/"),
+	); err != nil {
+		Log.Warning("plg_quota::mv from=%s to=%s err=%s", from, to, err.Error())
+		return
+	}
+	lower, upper := prefixRange(from)
+	if _, err := this.db.Exec(
+		"UPDATE OR REPLACE quota_file SET path=? || substr(path, ?) WHERE tenantID=? AND path>=? AND path<?",
+		strings.TrimSuffix(to, "/")+"/", utf8.RuneCountInString(lower)+1, tenantID, lower, upper,
+	); err != nil {
+		Log.Warning("plg_quota::mv from=%s to=%s err=%s", from, to, err.Error())
+	}
+}
+
+func (this QuotaImpl) scanned(tenantID string) bool {
+	var scannedAt int64
+	return this.db.QueryRow("SELECT scanned_at FROM quota_scan WHERE tenantID=?", tenantID).Scan(&scannedAt) == nil
+}
+
+func (this QuotaImpl) sum(tenantID string, path string) (int64, error) {
+	var size int64
+	from, to := prefixRange(path)
+	err := this.db.QueryRow(
+		"SELECT COALESCE(SUM(size), 0) FROM quota_file WHERE tenantID=? AND (path=? OR (path>=? AND path<?))",
+		tenantID, strings.TrimSuffix(path, "/"), from, to,
+	).Scan(&size)
+	return size, err
+}
+
+// prefixRange gives the bounds of everything that's inside a folder. Paths are compared byte by
+// byte, '0' being the character after '/', so unlike LIKE there's nothing to escape
+func prefixRange(path string) (string, string) {
+	dir := strings.TrimSuffix(path, "/")
+	return dir + "/", dir + "0"
+}
+
+func sessionRoot(ctx *App) string {
+	if root := ctx.Session["path"]; root != "" {
+		return root
+	}
+	return "/"
+}
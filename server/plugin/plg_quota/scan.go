@@ -0,0 +1,168 @@
+package plg_quota
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+const (
+	QUOTA_RESCAN_INTERVAL = 24 * time.Hour
+	QUOTA_SCAN_BATCH      = 500
+	QUOTA_SCAN_PARALLEL   = 2
+	QUOTA_SCAN_WAIT       = 10 * time.Second
+)
+
+var (
+	quotaScanning sync.Map
+	quotaScanSlot = make(chan struct{}, QUOTA_SCAN_PARALLEL)
+)
+
+type quotaFile struct {
+	path string
+	size int64
+}
+
+// scan kicks off a scan of someone's storage in the background when we've never done it or when
+// the last one is getting old. It tells if the usage we know about is still incomplete
+func (this QuotaImpl) scan(ctx *App, tenantID string) bool {
+	var scannedAt int64
+	err := this.db.QueryRow("SELECT scanned_at FROM quota_scan WHERE tenantID=?", tenantID).Scan(&scannedAt)
+	if err != nil && err != sql.ErrNoRows {
+		Log.Warning("plg_quota::scan action=status err=%s", err.Error())
+		return false
+	}
+	incomplete := err == sql.ErrNoRows
+	if incomplete == false && time.Since(time.Unix(scannedAt, 0)) < QUOTA_RESCAN_INTERVAL {
+		return false
+	}
+	done := make(chan struct{})
+	if _, running := quotaScanning.LoadOrStore(tenantID, done); running {
+		return incomplete
+	}
+
+	session := map[string]string{}
+	for k, v := range ctx.Session {
+		session[k] = v
+	}
+	root := sessionRoot(ctx)
+	go func() {
+		defer close(done)
+		defer quotaScanning.Delete(tenantID)
+		quotaScanSlot <- struct{}{}
+		defer func() { <-quotaScanSlot }()
+
+		app := &App{Context: context.Background(), Session: session}
+		backend, err := ctx.Backend.Init(session, app)
+		if err != nil {
+			Log.Warning("plg_quota::scan action=init err=%s", err.Error())
+			return
+		}
+		app.Backend = backend
+		start := time.Now()
+		if err = this.rescan(backend, tenantID, root); err != nil {
+			Log.Warning("plg_quota::scan action=rescan err=%s", err.Error())
+			return
+		}
+		Log.Debug("plg_quota::scan tenant=%s duration=%s", tenantID, time.Since(start))
+	}()
+	return incomplete
+}
+
+// waitScan waits for a scan that's running to be done, it tells if it completed in time
+func (this QuotaImpl) waitScan(tenantID string, timeout time.Duration) bool {
+	done, ok := quotaScanning.Load(tenantID)
+	if ok == false {
+		return true
+	}
+	select {
+	case <-done.(chan struct{}):
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// rescan walks through everything under root. What's found is staged on the side so the usage
+// we already know about stays available until the new one is ready to replace it
+func (this QuotaImpl) rescan(backend IBackend, tenantID string, root string) error {
+	if _, err := this.db.Exec("DELETE FROM quota_staging WHERE tenantID=?", tenantID); err != nil {
+		return err
+	}
+	batch := make([]quotaFile, 0, QUOTA_SCAN_BATCH)
+	queue := []string{EnforceDirectory(root)}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		files, err := backend.Ls(dir)
+		if err != nil {
+			if dir == EnforceDirectory(root) {
+				return err
+			}
+			Log.Debug("plg_quota::scan action=ls path=%s err=%s", dir, err.Error())
+			continue
+		}
+		for _, f := range files {
+			if f.IsDir() {
+				queue = append(queue, dir+f.Name()+"/")
+				continue
+			}
+			batch = append(batch, quotaFile{dir + f.Name(), max(f.Size(), 0)})
+		}
+		if len(batch) >= QUOTA_SCAN_BATCH {
+			if err = this.stage(tenantID, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := this.stage(tenantID, batch); err != nil {
+		return err
+	}
+
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM quota_file WHERE tenantID=?",
+		"INSERT OR REPLACE INTO quota_file (tenantID, path, size) SELECT tenantID, path, size FROM quota_staging WHERE tenantID=?",
+		"DELETE FROM quota_staging WHERE tenantID=?",
+	} {
+		if _, err = tx.Exec(query, tenantID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err = tx.Exec(`
+        INSERT INTO quota_scan (tenantID, scanned_at) VALUES (?, ?)
+        ON CONFLICT(tenantID) DO UPDATE SET scanned_at=excluded.scanned_at
+    `, tenantID, time.Now().Unix()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (this QuotaImpl) stage(tenantID string, files []quotaFile) error {
+	if len(files) == 0 {
+		return nil
+	}
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if _, err = tx.Exec(
+			"INSERT INTO quota_staging (tenantID, path, size) VALUES (?, ?, ?)",
+			tenantID, f.path, f.size,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -35,6 +35,10 @@ func (this FileHook) Stat(ctx *App, path string) error {
 	return nil
 }
 
+func (this FileHook) Chmod(ctx *App, path string) error {
+	return nil
+}
+
 func (this FileHook) Mkdir(ctx *App, path string) error {
 	if this.record(ctx) {
 		go func() {
@@ -40,12 +40,21 @@ func Build(r *mux.Router) {
 	middlewares = []Middleware{ApiHeaders, AdminOnly, SecureOrigin, PluginInjector}
 	admin.HandleFunc("/config", NewMiddlewareChain(PrivateConfigHandler, middlewares)).Methods("GET")
 	admin.HandleFunc("/config", NewMiddlewareChain(PrivateConfigUpdateHandler, middlewares)).Methods("POST")
+	admin.HandleFunc("/config/validate", NewMiddlewareChain(PrivateConfigValidateHandler, middlewares)).Methods("POST")
+	admin.HandleFunc("/config/history", NewMiddlewareChain(PrivateConfigHistoryHandler, middlewares)).Methods("GET")
+	admin.HandleFunc("/config/history/{id}", NewMiddlewareChain(PrivateConfigHistoryDiffHandler, middlewares)).Methods("GET")
+	admin.HandleFunc("/config/history/{id}/restore", NewMiddlewareChain(PrivateConfigHistoryRestoreHandler, middlewares)).Methods("POST")
+	admin.HandleFunc("/keyring", NewMiddlewareChain(AdminKeyringHandler, middlewares)).Methods("GET")
+	admin.HandleFunc("/keyring/rotate", NewMiddlewareChain(AdminKeyringRotateHandler, middlewares)).Methods("POST")
+	admin.HandleFunc("/keyring/retire", NewMiddlewareChain(AdminKeyringRetireHandler, middlewares)).Methods("POST")
 	admin.HandleFunc("/workflow", NewMiddlewareChain(WorkflowAll, middlewares)).Methods("GET")
 	admin.HandleFunc("/workflow/{workflowID}", NewMiddlewareChain(WorkflowGet, middlewares)).Methods("GET")
 	admin.HandleFunc("/workflow", NewMiddlewareChain(WorkflowUpsert, middlewares)).Methods("POST")
 	admin.HandleFunc("/workflow", NewMiddlewareChain(WorkflowDelete, middlewares)).Methods("DELETE")
 	admin.HandleFunc("/middlewares/authentication", NewMiddlewareChain(AdminAuthenticationMiddleware, middlewares)).Methods("GET")
 	admin.HandleFunc("/audit", NewMiddlewareChain(FetchAuditHandler, middlewares)).Methods("GET")
+	admin.HandleFunc("/backends/health", NewMiddlewareChain(AdminBackendHealthHandler, middlewares)).Methods("GET")
+	admin.HandleFunc("/backends/health", NewMiddlewareChain(AdminBackendHealthProbeHandler, middlewares)).Methods("POST")
 	middlewares = []Middleware{IndexHeaders, AdminOnly, PluginInjector}
 	admin.HandleFunc("/logs", NewMiddlewareChain(FetchLogHandler, middlewares)).Methods("GET")
 
@@ -64,8 +73,14 @@ func Build(r *mux.Router) {
 	files.HandleFunc("/rm", NewMiddlewareChain(FileRm, middlewares)).Methods("POST")
 	files.HandleFunc("/mkdir", NewMiddlewareChain(FileMkdir, middlewares)).Methods("POST")
 	files.HandleFunc("/touch", NewMiddlewareChain(FileTouch, middlewares)).Methods("POST")
+	files.HandleFunc("/chmod", NewMiddlewareChain(FileChmod, middlewares)).Methods("POST")
+	files.HandleFunc("/link", NewMiddlewareChain(FileReadlink, middlewares)).Methods("GET")
+	files.HandleFunc("/link", NewMiddlewareChain(FileSymlink, middlewares)).Methods("POST")
 	middlewares = []Middleware{ApiHeaders, SecureHeaders, SecureOrigin, SessionStart, LoggedInOnly, PluginInjector}
 	files.HandleFunc("/search", NewMiddlewareChain(FileSearch, middlewares)).Methods("GET")
+	files.HandleFunc("/usage", NewMiddlewareChain(FileUsage, middlewares)).Methods("GET")
+	files.HandleFunc("/du", NewMiddlewareChain(FileDu, middlewares)).Methods("GET")
+	files.HandleFunc("/checksum", NewMiddlewareChain(FileChecksum, middlewares)).Methods("GET")
 
 	// API for Shared link
 	share := r.PathPrefix(WithBase("/api/share")).Subrouter()